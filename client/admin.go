@@ -0,0 +1,23 @@
+package client
+
+import (
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// AdminReconcile checks the remote's database against the state of
+// its host, and returns the repaired and remaining inconsistencies
+func AdminReconcile(r shared.RemoteDef) (shared.ReconcileDef, error) {
+	var report shared.ReconcileDef
+
+	resp, err := Get(r, "/admin/reconcile")
+	if err != nil {
+		return report, err
+	}
+
+	err = DecodeJson(resp, &report)
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/gorilla/websocket"
+
 	"github.com/quadrifoglio/wir/shared"
 )
 
@@ -26,6 +28,25 @@ func Get(r shared.RemoteDef, path string) (*http.Response, error) {
 	return resp, nil
 }
 
+// Websocket opens a websocket connection
+// to the specified path of the remote
+func Websocket(r shared.RemoteDef, path string) (*websocket.Conn, error) {
+	ws, resp, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s:%d%s", r.Host, r.Port, path), nil)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+
+			if err := CheckResponse(resp); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, err
+	}
+
+	return ws, nil
+}
+
 // PostJson sends an HTTP POST request containing
 // the specified data encoded as JSON and returns the response
 func PostJson(r shared.RemoteDef, path string, req interface{}) (*http.Response, error) {
@@ -72,7 +93,7 @@ func CheckResponse(resp *http.Response) error {
 
 		err := json.NewDecoder(resp.Body).Decode(&e)
 		if err != nil {
-			return fmt.Errorf("Remote: HTTP %d", resp.Status)
+			return fmt.Errorf("Remote: HTTP %d", resp.StatusCode)
 		}
 
 		return fmt.Errorf("Remote: HTTP %d: %s", resp.StatusCode, e.Error)
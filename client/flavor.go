@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// FlavorCreate send a flavor creation request to the specified remote and
+// returns the newly created flavor information
+func FlavorCreate(r shared.RemoteDef, req shared.FlavorDef) (shared.FlavorDef, error) {
+	var flavor shared.FlavorDef
+
+	resp, err := PostJson(r, "/flavors", req)
+	if err != nil {
+		return flavor, err
+	}
+
+	err = DecodeJson(resp, &flavor)
+	if err != nil {
+		return flavor, err
+	}
+
+	return flavor, nil
+}
+
+// FlavorList fetches all the flavors from the specified
+// server and returns them as an array
+func FlavorList(r shared.RemoteDef) ([]shared.FlavorDef, error) {
+	var flavors []shared.FlavorDef
+
+	resp, err := Get(r, "/flavors")
+	if err != nil {
+		return nil, err
+	}
+
+	err = DecodeJson(resp, &flavors)
+	if err != nil {
+		return nil, err
+	}
+
+	return flavors, nil
+}
+
+// FlavorGet fetches the flavor from the specified
+// server and returns it
+func FlavorGet(r shared.RemoteDef, id string) (shared.FlavorDef, error) {
+	var flavor shared.FlavorDef
+
+	resp, err := Get(r, fmt.Sprintf("/flavors/%s", id))
+	if err != nil {
+		return flavor, err
+	}
+
+	err = DecodeJson(resp, &flavor)
+	if err != nil {
+		return flavor, err
+	}
+
+	return flavor, nil
+}
+
+// FlavorUpdate send a flavor update request to the specified remote and
+// returns the new flavor information
+func FlavorUpdate(r shared.RemoteDef, id string, req shared.FlavorDef) (shared.FlavorDef, error) {
+	var flavor shared.FlavorDef
+
+	resp, err := PostJson(r, fmt.Sprintf("/flavors/%s", id), req)
+	if err != nil {
+		return flavor, err
+	}
+
+	err = DecodeJson(resp, &flavor)
+	if err != nil {
+		return flavor, err
+	}
+
+	return flavor, nil
+}
+
+// FlavorDelete send a flavor delete request
+// to the specified remote
+func FlavorDelete(r shared.RemoteDef, id string) error {
+	resp, err := Delete(r, fmt.Sprintf("/flavors/%s", id))
+	if err != nil {
+		return err
+	}
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -6,21 +6,21 @@ import (
 )
 
 // ImageCreate send an image creation request to the specified remote and
-// returns the newly created image information
-func ImageCreate(r shared.RemoteDef, req shared.ImageDef) (shared.ImageDef, error) {
-	var img shared.ImageDef
+// returns the job creating the image. Its result is the ID of the image
+func ImageCreate(r shared.RemoteDef, req shared.ImageDef) (shared.JobDef, error) {
+	var job shared.JobDef
 
 	resp, err := PostJson(r, "/images", req)
 	if err != nil {
-		return img, err
+		return job, err
 	}
 
-	err = DecodeJson(resp, &img)
+	err = DecodeJson(resp, &job)
 	if err != nil {
-		return img, err
+		return job, err
 	}
 
-	return img, nil
+	return job, nil
 }
 
 // ImageList fetches all the images from the specified
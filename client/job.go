@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// JobList fetches all the jobs from the specified
+// server and returns them as an array
+func JobList(r shared.RemoteDef) ([]shared.JobDef, error) {
+	var jobs []shared.JobDef
+
+	resp, err := Get(r, "/jobs")
+	if err != nil {
+		return jobs, err
+	}
+
+	err = DecodeJson(resp, &jobs)
+	if err != nil {
+		return jobs, err
+	}
+
+	return jobs, nil
+}
+
+// JobGet fetches the job from the specified
+// server and returns it
+func JobGet(r shared.RemoteDef, id string) (shared.JobDef, error) {
+	var job shared.JobDef
+
+	resp, err := Get(r, fmt.Sprintf("/jobs/%s", id))
+	if err != nil {
+		return job, err
+	}
+
+	err = DecodeJson(resp, &job)
+	if err != nil {
+		return job, err
+	}
+
+	return job, nil
+}
+
+// JobCancel requests the cancellation
+// of the specified job
+func JobCancel(r shared.RemoteDef, id string) (shared.JobDef, error) {
+	var job shared.JobDef
+
+	resp, err := Get(r, fmt.Sprintf("/jobs/%s/cancel", id))
+	if err != nil {
+		return job, err
+	}
+
+	err = DecodeJson(resp, &job)
+	if err != nil {
+		return job, err
+	}
+
+	return job, nil
+}
+
+// JobWait waits for the specified job to be over, calling 'cb' (if not nil)
+// each time its state is retreived. It returns an error if the job did not succeed
+func JobWait(r shared.RemoteDef, id string, cb func(job shared.JobDef)) (shared.JobDef, error) {
+	for {
+		job, err := JobGet(r, id)
+		if err != nil {
+			return job, err
+		}
+
+		if cb != nil {
+			cb(job)
+		}
+
+		switch job.Status {
+		case shared.JobDone:
+			return job, nil
+		case shared.JobFailed, shared.JobCanceled:
+			return job, fmt.Errorf("Job %s: %s", job.Status, job.Error)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -2,25 +2,47 @@ package client
 
 import (
 	"fmt"
+	"io/ioutil"
+
+	"github.com/gorilla/websocket"
+
 	"github.com/quadrifoglio/wir/shared"
 )
 
-// MachineCreate send an mume creation request to the specified remote and
-// returns the newly created mume information
-func MachineCreate(r shared.RemoteDef, req shared.MachineDef) (shared.MachineDef, error) {
-	var m shared.MachineDef
+// MachineCreate send a machine creation request to the specified remote and
+// returns the job creating the machine. Its result is the ID of the machine
+func MachineCreate(r shared.RemoteDef, req shared.MachineDef) (shared.JobDef, error) {
+	var job shared.JobDef
 
 	resp, err := PostJson(r, "/machines", req)
 	if err != nil {
-		return m, err
+		return job, err
 	}
 
-	err = DecodeJson(resp, &m)
+	err = DecodeJson(resp, &job)
 	if err != nil {
-		return m, err
+		return job, err
 	}
 
-	return m, nil
+	return job, nil
+}
+
+// MachineFetch asks the specified remote to fetch a machine from another
+// server and returns the corresponding job. Its result is the ID of the machine
+func MachineFetch(r shared.RemoteDef, req shared.MachineFetchDef) (shared.JobDef, error) {
+	var job shared.JobDef
+
+	resp, err := PostJson(r, "/machines/fetch", req)
+	if err != nil {
+		return job, err
+	}
+
+	err = DecodeJson(resp, &job)
+	if err != nil {
+		return job, err
+	}
+
+	return job, nil
 }
 
 // MachineList fetches all the machines from the specified
@@ -60,9 +82,9 @@ func MachineGet(r shared.RemoteDef, id string) (shared.MachineDef, error) {
 }
 
 // MachineUpdate send an mume update request to the specified remote and
-// returns the new mume information
-func MachineUpdate(r shared.RemoteDef, id string, req shared.MachineDef) (shared.MachineDef, error) {
-	var m shared.MachineDef
+// returns the new mume information, with the changes applied live
+func MachineUpdate(r shared.RemoteDef, id string, req shared.MachineDef) (shared.MachineUpdateDef, error) {
+	var m shared.MachineUpdateDef
 
 	resp, err := PostJson(r, fmt.Sprintf("/machines/%s", id), req)
 	if err != nil {
@@ -77,6 +99,132 @@ func MachineUpdate(r shared.RemoteDef, id string, req shared.MachineDef) (shared
 	return m, nil
 }
 
+// MachineVolumeAttach attaches a volume to the specified
+// machine, hot-plugging it if the machine is running
+func MachineVolumeAttach(r shared.RemoteDef, id, vol string) (shared.MachineDef, error) {
+	var m shared.MachineDef
+
+	resp, err := PostJson(r, fmt.Sprintf("/machines/%s/volumes/%s/attach", id, vol), nil)
+	if err != nil {
+		return m, err
+	}
+
+	err = DecodeJson(resp, &m)
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// MachineVolumeDetach detaches a volume from the specified
+// machine, unplugging it if the machine is running
+func MachineVolumeDetach(r shared.RemoteDef, id, vol string) (shared.MachineDef, error) {
+	var m shared.MachineDef
+
+	resp, err := PostJson(r, fmt.Sprintf("/machines/%s/volumes/%s/detach", id, vol), nil)
+	if err != nil {
+		return m, err
+	}
+
+	err = DecodeJson(resp, &m)
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// MachineInterfaceAdd adds a network interface to the specified
+// machine, hot-plugging it if the machine is running
+func MachineInterfaceAdd(r shared.RemoteDef, id string, req shared.InterfaceDef) (shared.MachineDef, error) {
+	var m shared.MachineDef
+
+	resp, err := PostJson(r, fmt.Sprintf("/machines/%s/interfaces", id), req)
+	if err != nil {
+		return m, err
+	}
+
+	err = DecodeJson(resp, &m)
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// MachineInterfaceRemove removes the n-th network interface of the
+// specified machine, unplugging it if the machine is running
+func MachineInterfaceRemove(r shared.RemoteDef, id string, n int) (shared.MachineDef, error) {
+	var m shared.MachineDef
+
+	resp, err := Delete(r, fmt.Sprintf("/machines/%s/interfaces/%d", id, n))
+	if err != nil {
+		return m, err
+	}
+
+	err = DecodeJson(resp, &m)
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// MachineGetBalloon returns the memory balloon
+// information of the specified running machine
+func MachineGetBalloon(r shared.RemoteDef, id string) (shared.BalloonDef, error) {
+	var def shared.BalloonDef
+
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/balloon", id))
+	if err != nil {
+		return def, err
+	}
+
+	err = DecodeJson(resp, &def)
+	if err != nil {
+		return def, err
+	}
+
+	return def, nil
+}
+
+// MachineSetBalloon changes the memory target of the
+// balloon of the specified running machine
+func MachineSetBalloon(r shared.RemoteDef, id string, req shared.BalloonDef) (shared.BalloonDef, error) {
+	var def shared.BalloonDef
+
+	resp, err := PostJson(r, fmt.Sprintf("/machines/%s/balloon", id), req)
+	if err != nil {
+		return def, err
+	}
+
+	err = DecodeJson(resp, &def)
+	if err != nil {
+		return def, err
+	}
+
+	return def, nil
+}
+
+// MachineResize moves the specified machine to another flavor
+// and returns its new information, with the changes applied live
+func MachineResize(r shared.RemoteDef, id string, req shared.MachineResizeDef) (shared.MachineUpdateDef, error) {
+	var m shared.MachineUpdateDef
+
+	resp, err := PostJson(r, fmt.Sprintf("/machines/%s/resize", id), req)
+	if err != nil {
+		return m, err
+	}
+
+	err = DecodeJson(resp, &m)
+	if err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
 // MachineDelete send an mume delete request
 // to the specified remote
 func MachineDelete(r shared.RemoteDef, id string) error {
@@ -145,10 +293,74 @@ func MachineStart(r shared.RemoteDef, id string) error {
 	return nil
 }
 
-// MachineStop sends a machine stop request
+// MachineStop sends a machine stop request to the specified remote
+// If force is true, the machine is killed instead of being shut down
+func MachineStop(r shared.RemoteDef, id string, force bool) error {
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/stop?force=%t", id, force))
+	if err != nil {
+		return err
+	}
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MachinePause sends a machine pause request
+// to the specified remote
+func MachinePause(r shared.RemoteDef, id string) error {
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/pause", id))
+	if err != nil {
+		return err
+	}
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MachineResume sends a machine resume request
+// to the specified remote
+func MachineResume(r shared.RemoteDef, id string) error {
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/resume", id))
+	if err != nil {
+		return err
+	}
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MachineReset sends a machine reset request
+// to the specified remote
+func MachineReset(r shared.RemoteDef, id string) error {
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/reset", id))
+	if err != nil {
+		return err
+	}
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MachineReboot sends a machine reboot request
 // to the specified remote
-func MachineStop(r shared.RemoteDef, id string) error {
-	resp, err := Get(r, fmt.Sprintf("/machines/%s/stop", id))
+func MachineReboot(r shared.RemoteDef, id string) error {
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/reboot", id))
 	if err != nil {
 		return err
 	}
@@ -178,3 +390,107 @@ func MachineStatus(r shared.RemoteDef, id string) (shared.MachineStatusDef, erro
 
 	return status, nil
 }
+
+// MachineConsoleLog returns the logged output
+// of the serial console of the specified machine
+func MachineConsoleLog(r shared.RemoteDef, id string) ([]byte, error) {
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/console/log", id))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	err = CheckResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// MachineConsole attaches to the serial console of the specified
+// machine. The output of the console is received as binary
+// messages, and the messages sent are written to it
+func MachineConsole(r shared.RemoteDef, id string) (*websocket.Conn, error) {
+	return Websocket(r, fmt.Sprintf("/machines/%s/console", id))
+}
+
+// MachineVncToken requests a short-lived token giving
+// access to the VNC proxy of the specified machine
+func MachineVncToken(r shared.RemoteDef, id string) (shared.VncTokenDef, error) {
+	var token shared.VncTokenDef
+
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/vnc/token", id))
+	if err != nil {
+		return token, err
+	}
+
+	err = DecodeJson(resp, &token)
+	if err != nil {
+		return token, err
+	}
+
+	return token, nil
+}
+
+// MachineVncCerts returns the certificates needed to connect
+// to the VNC server of the specified machine using TLS. The token
+// is required when the server verifies the client certificates
+func MachineVncCerts(r shared.RemoteDef, id string, token shared.VncTokenDef) (shared.VncCertsDef, error) {
+	var certs shared.VncCertsDef
+
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/vnc/certs?token=%s", id, token.Token))
+	if err != nil {
+		return certs, err
+	}
+
+	err = DecodeJson(resp, &certs)
+	if err != nil {
+		return certs, err
+	}
+
+	return certs, nil
+}
+
+// MachineSpiceCerts returns the certificate authority needed to
+// connect to the SPICE server of the specified machine using TLS
+func MachineSpiceCerts(r shared.RemoteDef, id string) (shared.VncCertsDef, error) {
+	var certs shared.VncCertsDef
+
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/spice/certs", id))
+	if err != nil {
+		return certs, err
+	}
+
+	err = DecodeJson(resp, &certs)
+	if err != nil {
+		return certs, err
+	}
+
+	return certs, nil
+}
+
+// MachineVncURL returns the websocket URL of the VNC
+// proxy of the specified machine for the given token
+func MachineVncURL(r shared.RemoteDef, id string, token shared.VncTokenDef) string {
+	return fmt.Sprintf("ws://%s:%d/machines/%s/vnc?token=%s", r.Host, r.Port, id, token.Token)
+}
+
+// MachineEvents returns the event history
+// of the specified machine, oldest first
+func MachineEvents(r shared.RemoteDef, id string) ([]shared.MachineEventDef, error) {
+	var events []shared.MachineEventDef
+
+	resp, err := Get(r, fmt.Sprintf("/machines/%s/events", id))
+	if err != nil {
+		return events, err
+	}
+
+	err = DecodeJson(resp, &events)
+	if err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/quadrifoglio/wir/client"
+)
+
+// AdminReconcile checks the remote's database against
+// its host and prints the resulting report
+func AdminReconcile() {
+	report, err := client.AdminReconcile(GetRemote())
+	if err != nil {
+		Fatal(err)
+	}
+
+	for _, f := range report.Fixed {
+		fmt.Printf("Fixed: %s\n", f)
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("Problem: %s\n", p)
+	}
+
+	if len(report.Fixed) == 0 && len(report.Problems) == 0 {
+		fmt.Println("No inconsistency found")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/quadrifoglio/wir/client"
+)
+
+// ConsoleEscape is the key used to
+// detach from a console (Ctrl-])
+const ConsoleEscape = 0x1d
+
+// stty runs the stty command on the terminal
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+
+	return cmd.Run()
+}
+
+// MachineConsole attaches the terminal to the serial
+// console of the machine, or prints its log
+func MachineConsole() {
+	if *CMachineConsoleLog {
+		data, err := client.MachineConsoleLog(GetRemote(), *CMachineConsoleID)
+		if err != nil {
+			Fatal(err)
+		}
+
+		os.Stdout.Write(data)
+		return
+	}
+
+	ws, err := client.MachineConsole(GetRemote(), *CMachineConsoleID)
+	if err != nil {
+		Fatal(err)
+	}
+
+	defer ws.Close()
+
+	fmt.Fprintln(os.Stderr, "Connected to the console, press Ctrl-] to detach")
+
+	err = stty("raw", "-echo")
+	if err != nil {
+		Fatal(fmt.Errorf("Failed to set the terminal in raw mode: %s", err))
+	}
+
+	defer stty("sane")
+
+	done := make(chan error, 2)
+
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+
+			os.Stdout.Write(data)
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 1024)
+
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+
+			for i := 0; i < n; i++ {
+				if buf[i] == ConsoleEscape {
+					done <- nil
+					return
+				}
+			}
+
+			err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	err = <-done
+	if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+		stty("sane")
+		Fatal(err)
+	}
+
+	fmt.Fprint(os.Stderr, "\r\nDetached from the console\r\n")
+}
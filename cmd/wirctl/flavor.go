@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/quadrifoglio/wir/client"
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// FlavorList lists all the flavors on
+// the remote
+func FlavorList() {
+	flavors, err := client.FlavorList(GetRemote())
+	if err != nil {
+		Fatal(err)
+	}
+
+	if len(flavors) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{
+			"Name",
+			"Cores",
+			"Memory",
+			"Disk",
+			"Disk I/O Limits",
+			"CPU Quota (%)",
+			"CPU Weight",
+		})
+
+		for _, f := range flavors {
+			disk := "image"
+			if f.Disk > 0 {
+				disk = strconv.FormatUint(f.Disk, 10)
+			}
+
+			quota := "none"
+			if f.CpuQuota > 0 {
+				quota = strconv.Itoa(f.CpuQuota)
+			}
+
+			weight := "default"
+			if f.CpuWeight > 0 {
+				weight = strconv.Itoa(f.CpuWeight)
+			}
+
+			table.Append([]string{
+				f.Name,
+				strconv.Itoa(f.Cores),
+				strconv.FormatUint(f.Memory, 10),
+				disk,
+				FormatThrottle(f.DiskThrottle),
+				quota,
+				weight,
+			})
+		}
+
+		table.Render()
+	}
+}
+
+// FlavorCreate creates a new
+// flavor on the remote
+func FlavorCreate() {
+	var req shared.FlavorDef
+	req.Name = *CFlavorCreateName
+	req.Cores = *CFlavorCreateCores
+	req.Memory = *CFlavorCreateMemory
+	req.Disk = *CFlavorCreateDisk
+	req.CpuQuota = *CFlavorCreateCpuQuota
+	req.CpuWeight = *CFlavorCreateCpuWeight
+
+	if len(*CFlavorCreateDiskThrottle) > 0 {
+		throttle, err := ParseThrottle(*CFlavorCreateDiskThrottle)
+		if err != nil {
+			Fatal(err)
+		}
+
+		req.DiskThrottle = throttle
+	}
+
+	_, err := client.FlavorCreate(GetRemote(), req)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// FlavorUpdate updates the specified
+// flavor on the remote
+func FlavorUpdate() {
+	req, err := client.FlavorGet(GetRemote(), *CFlavorUpdateName)
+	if err != nil {
+		Fatal(err)
+	}
+
+	if *CFlavorUpdateCores > 0 {
+		req.Cores = *CFlavorUpdateCores
+	}
+	if *CFlavorUpdateMemory > 0 {
+		req.Memory = *CFlavorUpdateMemory
+	}
+	if *CFlavorUpdateDisk >= 0 {
+		req.Disk = uint64(*CFlavorUpdateDisk)
+	}
+	if len(*CFlavorUpdateDiskThrottle) > 0 {
+		throttle, err := ParseThrottle(*CFlavorUpdateDiskThrottle)
+		if err != nil {
+			Fatal(err)
+		}
+
+		req.DiskThrottle = throttle
+	}
+	if *CFlavorUpdateCpuQuota >= 0 {
+		req.CpuQuota = *CFlavorUpdateCpuQuota
+	}
+	if *CFlavorUpdateCpuWeight >= 0 {
+		req.CpuWeight = *CFlavorUpdateCpuWeight
+	}
+
+	_, err = client.FlavorUpdate(GetRemote(), *CFlavorUpdateName, req)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// FlavorDelete deletes the specified
+// flavor from the remote
+func FlavorDelete() {
+	err := client.FlavorDelete(GetRemote(), *CFlavorDeleteName)
+	if err != nil {
+		Fatal(err)
+	}
+}
@@ -38,12 +38,12 @@ func ImageCreate() {
 	req.Type = *CImageCreateType
 	req.Source = *CImageCreateSource
 
-	img, err := client.ImageCreate(GetRemote(), req)
+	job, err := client.ImageCreate(GetRemote(), req)
 	if err != nil {
 		Fatal(err)
 	}
 
-	fmt.Println(img.ID)
+	fmt.Println(WaitJob(job.ID))
 }
 
 // ImageUpdate updates the specified
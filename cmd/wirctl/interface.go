@@ -12,19 +12,16 @@ import (
 )
 
 func MachineInterfaceCreate() {
-	req, err := client.MachineGet(GetRemote(), *CMachineNicCreateMachine)
-	if err != nil {
-		Fatal(err)
-	}
-
 	var nic shared.InterfaceDef
 	nic.Network = *CMachineNicCreateNetwork
 	nic.MAC = *CMachineNicCreateMAC
 	nic.IP = *CMachineNicCreateIP
+	nic.Bandwidth.IngressRate = *CMachineNicCreateInRate
+	nic.Bandwidth.IngressBurst = *CMachineNicCreateInBurst
+	nic.Bandwidth.EgressRate = *CMachineNicCreateOutRate
+	nic.Bandwidth.EgressBurst = *CMachineNicCreateOutBurst
 
-	req.Interfaces = append(req.Interfaces, nic)
-
-	_, err = client.MachineUpdate(GetRemote(), req.ID, req)
+	_, err := client.MachineInterfaceAdd(GetRemote(), *CMachineNicCreateMachine, nic)
 	if err != nil {
 		Fatal(err)
 	}
@@ -40,8 +37,10 @@ func MachineInterfaceList() {
 	table.SetHeader([]string{
 		"Index",
 		"Network ID",
-		"IP Address",
 		"MAC Address",
+		"IP Address",
+		"Ingress Limit",
+		"Egress Limit",
 	})
 
 	if len(req.Interfaces) > 0 {
@@ -52,6 +51,8 @@ func MachineInterfaceList() {
 				nic.Network,
 				nic.MAC,
 				nic.IP,
+				FormatBandwidth(nic.Bandwidth.IngressRate, nic.Bandwidth.IngressBurst),
+				FormatBandwidth(nic.Bandwidth.EgressRate, nic.Bandwidth.EgressBurst),
 			})
 		}
 
@@ -79,27 +80,44 @@ func MachineInterfaceUpdate() {
 	if len(*CMachineNicUpdateIP) > 0 {
 		req.Interfaces[index].IP = *CMachineNicUpdateIP
 	}
+	if *CMachineNicUpdateInRate >= 0 {
+		req.Interfaces[index].Bandwidth.IngressRate = uint64(*CMachineNicUpdateInRate)
+	}
+	if *CMachineNicUpdateInBurst >= 0 {
+		req.Interfaces[index].Bandwidth.IngressBurst = uint64(*CMachineNicUpdateInBurst)
+	}
+	if *CMachineNicUpdateOutRate >= 0 {
+		req.Interfaces[index].Bandwidth.EgressRate = uint64(*CMachineNicUpdateOutRate)
+	}
+	if *CMachineNicUpdateOutBurst >= 0 {
+		req.Interfaces[index].Bandwidth.EgressBurst = uint64(*CMachineNicUpdateOutBurst)
+	}
 
-	_, err = client.MachineUpdate(GetRemote(), req.ID, req)
+	m, err := client.MachineUpdate(GetRemote(), req.ID, req)
 	if err != nil {
 		Fatal(err)
 	}
-}
 
-func MachineInterfaceDelete() {
-	req, err := client.MachineGet(GetRemote(), *CMachineNicDeleteMachine)
-	if err != nil {
-		Fatal(err)
+	for _, change := range m.Pending {
+		fmt.Printf("Requires a restart: %s\n", change)
 	}
+}
 
-	index := *CMachineNicDeleteIndex
-	if index >= len(req.Interfaces) {
-		Fatal(fmt.Errorf("No such interface"))
+// FormatBandwidth formats a bandwidth limit (kbit/s)
+// and its burst size (KiB)
+func FormatBandwidth(rate, burst uint64) string {
+	if rate == 0 {
+		return "none"
+	}
+	if burst == 0 {
+		return fmt.Sprintf("%d kbit/s", rate)
 	}
 
-	req.Interfaces = append(req.Interfaces[:index], req.Interfaces[index+1:]...)
+	return fmt.Sprintf("%d kbit/s (burst %d KiB)", rate, burst)
+}
 
-	_, err = client.MachineUpdate(GetRemote(), req.ID, req)
+func MachineInterfaceDelete() {
+	_, err := client.MachineInterfaceRemove(GetRemote(), *CMachineNicDeleteMachine, *CMachineNicDeleteIndex)
 	if err != nil {
 		Fatal(err)
 	}
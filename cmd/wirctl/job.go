@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/quadrifoglio/wir/client"
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// jobProgress returns the progress of
+// the current step of the job as text
+func jobProgress(job shared.JobDef) string {
+	if job.Total > 0 {
+		return fmt.Sprintf("%d%%", job.Progress*100/job.Total)
+	}
+	if job.Progress > 0 {
+		return strconv.FormatUint(job.Progress, 10) + " bytes"
+	}
+
+	return ""
+}
+
+// WaitJob waits for the specified job to be over while displaying
+// its progress on the error output, and returns its result
+func WaitJob(id string) string {
+	job, err := client.JobWait(GetRemote(), id, func(job shared.JobDef) {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s: %s %s", job.Status, job.Step, jobProgress(job))
+	})
+
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		Fatal(err)
+	}
+
+	return job.Result
+}
+
+// JobList lists all the jobs on
+// the remote
+func JobList() {
+	jobs, err := client.JobList(GetRemote())
+	if err != nil {
+		Fatal(err)
+	}
+
+	if len(jobs) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Type", "Status", "Step", "Progress", "Result", "Error", "Created"})
+
+		for _, job := range jobs {
+			table.Append([]string{
+				job.ID,
+				job.Type,
+				job.Status,
+				job.Step,
+				jobProgress(job),
+				job.Result,
+				job.Error,
+				time.Unix(job.Created, 0).Format(time.RFC3339),
+			})
+		}
+
+		table.Render()
+	}
+}
+
+// JobWait waits for the specified
+// job and prints its result
+func JobWait() {
+	fmt.Println(WaitJob(*CJobWaitID))
+}
+
+// JobCancel cancels the specified
+// job on the remote
+func JobCancel() {
+	_, err := client.JobCancel(GetRemote(), *CJobCancelID)
+	if err != nil {
+		Fatal(err)
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 
@@ -25,19 +30,30 @@ func MachineList() {
 			"ID",
 			"Name",
 			"Image",
+			"Flavor",
 			"Cores",
 			"Memory",
 			"Disk",
+			"Disk I/O Limits",
+			"Restart",
 		})
 
 		for _, m := range ms {
+			flavor := m.Flavor
+			if len(flavor) == 0 {
+				flavor = "none"
+			}
+
 			table.Append([]string{
 				m.ID,
 				m.Name,
 				m.Image,
+				flavor,
 				strconv.Itoa(m.Cores),
 				strconv.FormatUint(m.Memory, 10),
 				strconv.FormatUint(m.Disk, 10),
+				FormatThrottle(m.DiskThrottle),
+				m.RestartPolicy,
 			})
 		}
 
@@ -51,16 +67,27 @@ func MachineCreate() {
 	var req shared.MachineDef
 	req.Name = *CMachineCreateName
 	req.Image = *CMachineCreateImage
+	req.Flavor = *CMachineCreateFlavor
 	req.Cores = *CMachineCreateCores
 	req.Memory = *CMachineCreateMemory
 	req.Disk = *CMachineCreateDisk
+	req.RestartPolicy = *CMachineCreateRestart
+
+	if len(*CMachineCreateDiskThrottle) > 0 {
+		throttle, err := ParseThrottle(*CMachineCreateDiskThrottle)
+		if err != nil {
+			Fatal(err)
+		}
+
+		req.DiskThrottle = throttle
+	}
 
-	m, err := client.MachineCreate(GetRemote(), req)
+	job, err := client.MachineCreate(GetRemote(), req)
 	if err != nil {
 		Fatal(err)
 	}
 
-	fmt.Println(m.ID)
+	fmt.Println(WaitJob(job.ID))
 }
 
 // MachineUpdate updates the specified
@@ -74,20 +101,63 @@ func MachineUpdate() {
 	if len(*CMachineUpdateName) > 0 {
 		req.Name = *CMachineUpdateName
 	}
-	if *CMachineUpdateCores > 0 {
+	// A machine sized by hand no longer follows its flavor
+	if *CMachineUpdateCores > 0 && *CMachineUpdateCores != req.Cores {
 		req.Cores = *CMachineUpdateCores
+		req.Flavor = ""
 	}
-	if *CMachineUpdateMemory > 0 {
+	if *CMachineUpdateMemory > 0 && *CMachineUpdateMemory != req.Memory {
 		req.Memory = *CMachineUpdateMemory
+		req.Flavor = ""
 	}
 	if *CMachineUpdateDisk > 0 {
 		req.Disk = *CMachineUpdateDisk
 	}
+	if len(*CMachineUpdateRestart) > 0 {
+		req.RestartPolicy = *CMachineUpdateRestart
+	}
+	if len(*CMachineUpdateDiskThrottle) > 0 {
+		throttle, err := ParseThrottle(*CMachineUpdateDiskThrottle)
+		if err != nil {
+			Fatal(err)
+		}
+
+		if throttle != req.DiskThrottle {
+			req.DiskThrottle = throttle
+			req.Flavor = ""
+		}
+	}
+
+	m, err := client.MachineUpdate(GetRemote(), *CMachineUpdateID, req)
+	if err != nil {
+		Fatal(err)
+	}
+
+	PrintMachineChanges(m)
+}
 
-	_, err = client.MachineUpdate(GetRemote(), *CMachineUpdateID, req)
+// MachineResize moves the specified
+// machine to another flavor
+func MachineResize() {
+	req := shared.MachineResizeDef{Flavor: *CMachineResizeFlavor}
+
+	m, err := client.MachineResize(GetRemote(), *CMachineResizeID, req)
 	if err != nil {
 		Fatal(err)
 	}
+
+	PrintMachineChanges(m)
+}
+
+// PrintMachineChanges prints the changes of an update
+// or resize, depending on wether they were applied live
+func PrintMachineChanges(m shared.MachineUpdateDef) {
+	for _, change := range m.Applied {
+		fmt.Printf("Applied to the running machine: %s\n", change)
+	}
+	for _, change := range m.Pending {
+		fmt.Printf("Requires a restart: %s\n", change)
+	}
 }
 
 // MachineDelete deletes the specified
@@ -107,26 +177,215 @@ func MachineGetKvmOpts() {
 		Fatal(err)
 	}
 
+	display := opts.Display
+	if len(display) == 0 {
+		display = shared.DisplayVNC
+	}
+
+	video := opts.Video
+	if len(video) == 0 {
+		video = shared.VideoStd
+	}
+
+	bus := opts.VolumeBus
+	if len(bus) == 0 {
+		bus = shared.VolumeBusIDE
+	}
+
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{
+	header := []string{
 		"Hypervisor PID",
 		"CD-ROM",
-		"VNC Enabled",
-		"VNC Address",
-		"VNC Port",
+		"Display",
+		"Video",
+		"Volume Bus",
+	}
+	row := []string{
+		strconv.Itoa(opts.PID),
+		opts.CDRom,
+		display,
+		video,
+		bus,
+	}
+
+	if display == shared.DisplaySpice {
+		agent := "false"
+		if opts.Spice.Agent {
+			agent = "true"
+		}
+
+		header = append(header, "SPICE Address", "SPICE Port", "SPICE TLS Port", "SPICE Agent")
+		row = append(row,
+			opts.Spice.Address,
+			strconv.Itoa(opts.Spice.Port),
+			strconv.Itoa(opts.Spice.TLSPort),
+			agent,
+		)
+	} else {
+		enabled := "false"
+		if opts.VNC.Enabled {
+			enabled = "true"
+		}
+
+		tls := "false"
+		if opts.VNC.TLS && opts.VNC.VerifyClient {
+			tls = "true (client certificates)"
+		} else if opts.VNC.TLS {
+			tls = "true"
+		}
+
+		header = append(header, "VNC Enabled", "VNC Address", "VNC Display", "VNC Websocket Port", "VNC TLS", "VNC Password Expiry")
+		row = append(row,
+			enabled,
+			opts.VNC.Address,
+			strconv.Itoa(opts.VNC.Port),
+			strconv.Itoa(opts.VNC.WebsocketPort),
+			tls,
+			strconv.Itoa(opts.VNC.PasswordExpiry),
+		)
+	}
+
+	table.SetHeader(header)
+	table.Append(row)
+	table.Render()
+
+	model := opts.CPU.Model
+	if len(model) == 0 {
+		model = "default"
+	}
+
+	nested := "false"
+	if opts.CPU.Nested {
+		nested = "true"
+	}
+
+	sockets, threads := opts.CPU.Sockets, opts.CPU.Threads
+	if sockets == 0 {
+		sockets = 1
+	}
+	if threads == 0 {
+		threads = 1
+	}
+
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"CPU Model",
+		"Sockets",
+		"Threads per Core",
+		"Nested Virtualization",
+		"CPU Flags",
+		"Max Cores",
 	})
 
-	enabled := "false"
-	if opts.VNC.Enabled {
-		enabled = "true"
+	maxCores := "none"
+	if opts.Hotplug.MaxCores > 0 {
+		maxCores = strconv.Itoa(opts.Hotplug.MaxCores)
 	}
 
 	table.Append([]string{
-		strconv.Itoa(opts.PID),
-		opts.CDRom,
-		enabled,
-		opts.VNC.Address,
-		strconv.Itoa(opts.VNC.Port),
+		model,
+		strconv.Itoa(sockets),
+		strconv.Itoa(threads),
+		nested,
+		strings.Join(opts.CPU.Flags, ","),
+		maxCores,
+	})
+
+	table.Render()
+
+	firmware := opts.Boot.Firmware
+	if len(firmware) == 0 {
+		firmware = shared.FirmwareBIOS
+	}
+
+	order := "cdrom,disk (default)"
+	if len(opts.Boot.Order) > 0 {
+		order = strings.Join(opts.Boot.Order, ",")
+	}
+
+	menu := "false"
+	if opts.Boot.Menu {
+		menu = "true"
+	}
+
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Firmware",
+		"Boot Order",
+		"Boot Menu",
+	})
+
+	table.Append([]string{
+		firmware,
+		order,
+		menu,
+	})
+
+	table.Render()
+
+	min := "default"
+	if opts.Balloon.Min > 0 {
+		min = strconv.FormatUint(opts.Balloon.Min, 10)
+	}
+
+	auto := "false"
+	if opts.Balloon.Auto {
+		auto = "true"
+	}
+
+	maxMemory := "none"
+	if opts.Hotplug.MaxMemory > 0 {
+		maxMemory = strconv.FormatUint(opts.Hotplug.MaxMemory, 10)
+	}
+
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Max Memory (MiB)",
+		"Balloon Min (MiB)",
+		"Automatic Balloon",
+	})
+
+	table.Append([]string{
+		maxMemory,
+		min,
+		auto,
+	})
+
+	table.Render()
+
+	quota := "none"
+	if opts.Limits.CpuQuota > 0 {
+		quota = strconv.Itoa(opts.Limits.CpuQuota)
+	}
+
+	weight := "default"
+	if opts.Limits.CpuWeight > 0 {
+		weight = strconv.Itoa(opts.Limits.CpuWeight)
+	}
+
+	cpuset := "all"
+	if len(opts.Limits.CpuSet) > 0 {
+		cpuset = opts.Limits.CpuSet
+	}
+
+	memoryMax := "none"
+	if opts.Limits.MemoryMax > 0 {
+		memoryMax = strconv.FormatUint(opts.Limits.MemoryMax, 10)
+	}
+
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"CPU Quota (%)",
+		"CPU Weight",
+		"CPU Set",
+		"Memory Limit (MiB)",
+	})
+
+	table.Append([]string{
+		quota,
+		weight,
+		cpuset,
+		memoryMax,
 	})
 
 	table.Render()
@@ -140,7 +399,10 @@ func MachineSetKvmOpts() {
 		Fatal(err)
 	}
 
-	// TODO: Enable/Disable VNC
+	// TODO: Disable VNC
+	if *CMachineKvmSetVncEnabled {
+		req.VNC.Enabled = true
+	}
 
 	if len(*CMachineKvmSetCDRom) > 0 {
 		req.CDRom = *CMachineKvmSetCDRom
@@ -148,9 +410,128 @@ func MachineSetKvmOpts() {
 	if len(*CMachineKvmSetVncAddr) > 0 {
 		req.VNC.Address = *CMachineKvmSetVncAddr
 	}
+	if *CMachineKvmSetVncProxy {
+		req.VNC.Address = ""
+		req.VNC.Port = 0
+		req.VNC.WebsocketPort = 0
+	}
 	if *CMachineKvmSetVncPort != 0 {
 		req.VNC.Port = *CMachineKvmSetVncPort
 	}
+	if *CMachineKvmSetVncWsPort != 0 {
+		req.VNC.WebsocketPort = *CMachineKvmSetVncWsPort
+	}
+	if *CMachineKvmSetVncTls {
+		req.VNC.TLS = true
+	}
+	if *CMachineKvmSetVncNoTls {
+		req.VNC.TLS = false
+		req.VNC.VerifyClient = false
+	}
+	if *CMachineKvmSetVncVerifyClient {
+		req.VNC.VerifyClient = true
+	}
+	if len(*CMachineKvmSetVncPassword) > 0 {
+		req.VNC.Password = *CMachineKvmSetVncPassword
+	}
+	if *CMachineKvmSetVncNoPassword {
+		req.VNC.Password = ""
+		req.VNC.PasswordExpiry = 0
+	}
+	if *CMachineKvmSetVncPasswordExpiry >= 0 {
+		req.VNC.PasswordExpiry = *CMachineKvmSetVncPasswordExpiry
+	}
+	if len(*CMachineKvmSetDisplay) > 0 {
+		req.Display = *CMachineKvmSetDisplay
+
+		// The agent channel only exists with the SPICE display
+		if req.Display != shared.DisplaySpice {
+			req.Spice.Agent = false
+		}
+	}
+	if len(*CMachineKvmSetVideo) > 0 {
+		req.Video = *CMachineKvmSetVideo
+	}
+	if len(*CMachineKvmSetVolumeBus) > 0 {
+		req.VolumeBus = *CMachineKvmSetVolumeBus
+	}
+	if len(*CMachineKvmSetSpiceAddr) > 0 {
+		req.Spice.Address = *CMachineKvmSetSpiceAddr
+	}
+	if *CMachineKvmSetSpicePort >= 0 {
+		req.Spice.Port = *CMachineKvmSetSpicePort
+	}
+	if *CMachineKvmSetSpiceTlsPort >= 0 {
+		req.Spice.TLSPort = *CMachineKvmSetSpiceTlsPort
+	}
+	if len(*CMachineKvmSetSpicePassword) > 0 {
+		req.Spice.Password = *CMachineKvmSetSpicePassword
+	}
+	if *CMachineKvmSetSpiceAgent {
+		req.Spice.Agent = true
+	}
+	if *CMachineKvmSetSpiceNoAgent {
+		req.Spice.Agent = false
+	}
+	if len(*CMachineKvmSetCpuModel) > 0 {
+		req.CPU.Model = *CMachineKvmSetCpuModel
+	}
+	if *CMachineKvmSetCpuSockets != 0 {
+		req.CPU.Sockets = *CMachineKvmSetCpuSockets
+	}
+	if *CMachineKvmSetCpuThreads != 0 {
+		req.CPU.Threads = *CMachineKvmSetCpuThreads
+	}
+	if *CMachineKvmSetCpuNested {
+		req.CPU.Nested = true
+	}
+	if *CMachineKvmSetCpuNoNested {
+		req.CPU.Nested = false
+	}
+	if len(*CMachineKvmSetCpuFlags) > 0 {
+		req.CPU.Flags = strings.Split(*CMachineKvmSetCpuFlags, ",")
+	}
+	if len(*CMachineKvmSetFirmware) > 0 {
+		req.Boot.Firmware = *CMachineKvmSetFirmware
+	}
+	if len(*CMachineKvmSetBootOrder) > 0 {
+		req.Boot.Order = strings.Split(*CMachineKvmSetBootOrder, ",")
+	}
+	if *CMachineKvmSetBootMenu {
+		req.Boot.Menu = true
+	}
+	if *CMachineKvmSetBootNoMenu {
+		req.Boot.Menu = false
+	}
+	if *CMachineKvmSetMaxCores >= 0 {
+		req.Hotplug.MaxCores = *CMachineKvmSetMaxCores
+	}
+	if *CMachineKvmSetMaxMemory >= 0 {
+		req.Hotplug.MaxMemory = uint64(*CMachineKvmSetMaxMemory)
+	}
+	if *CMachineKvmSetBalloonMin >= 0 {
+		req.Balloon.Min = uint64(*CMachineKvmSetBalloonMin)
+	}
+	if *CMachineKvmSetBalloonAuto {
+		req.Balloon.Auto = true
+	}
+	if *CMachineKvmSetBalloonNoAuto {
+		req.Balloon.Auto = false
+	}
+	if *CMachineKvmSetCpuQuota >= 0 {
+		req.Limits.CpuQuota = *CMachineKvmSetCpuQuota
+	}
+	if *CMachineKvmSetCpuWeight >= 0 {
+		req.Limits.CpuWeight = *CMachineKvmSetCpuWeight
+	}
+	if *CMachineKvmSetCpuSet == "all" {
+		req.Limits.CpuSet = ""
+	} else if len(*CMachineKvmSetCpuSet) > 0 {
+		req.Limits.CpuSet = *CMachineKvmSetCpuSet
+	}
+	if *CMachineKvmSetMemoryMax >= 0 {
+		req.Limits.MemoryMax = uint64(*CMachineKvmSetMemoryMax)
+	}
 	if len(*CMachineKvmSetLinuxHostname) > 0 {
 		req.Linux.Hostname = *CMachineKvmSetLinuxHostname
 	}
@@ -176,7 +557,43 @@ func MachineStart() {
 // MachineStop stops a
 // machine on the remote
 func MachineStop() {
-	err := client.MachineStop(GetRemote(), *CMachineStopID)
+	err := client.MachineStop(GetRemote(), *CMachineStopID, *CMachineStopForce)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// MachinePause suspends the execution
+// of a machine on the remote
+func MachinePause() {
+	err := client.MachinePause(GetRemote(), *CMachinePauseID)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// MachineResume resumes the execution
+// of a paused machine on the remote
+func MachineResume() {
+	err := client.MachineResume(GetRemote(), *CMachineResumeID)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// MachineReset hard resets a
+// machine on the remote
+func MachineReset() {
+	err := client.MachineReset(GetRemote(), *CMachineResetID)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// MachineReboot cleanly reboots a
+// machine on the remote
+func MachineReboot() {
+	err := client.MachineReboot(GetRemote(), *CMachineRebootID)
 	if err != nil {
 		Fatal(err)
 	}
@@ -192,10 +609,13 @@ func MachineStatus() {
 
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader([]string{
+		"State",
 		"Running",
+		"Paused",
 		"CPU Usage (%)",
 		"RAM Usage (MiB)",
 		"Disk Usage (bytes)",
+		"Reason",
 	})
 
 	running := "false"
@@ -203,12 +623,210 @@ func MachineStatus() {
 		running = "true"
 	}
 
+	paused := "false"
+	if status.Paused {
+		paused = "true"
+	}
+
 	table.Append([]string{
+		status.State,
 		running,
+		paused,
 		strconv.FormatFloat(float64(status.CpuUsage), 'f', 2, 32),
 		strconv.FormatUint(status.RamUsage, 10),
 		strconv.FormatUint(status.DiskUsage, 10),
+		status.Reason,
+	})
+
+	table.Render()
+
+	if status.LastExit != nil {
+		exit := status.LastExit
+
+		fmt.Printf("\nLast exit: %s, code %d", time.Unix(exit.Timestamp, 0).Format(time.RFC3339), exit.Code)
+		if len(exit.Signal) > 0 {
+			fmt.Printf(", signal %s", exit.Signal)
+		}
+		if !exit.Expected {
+			fmt.Printf(" (unexpected)")
+		}
+
+		fmt.Println()
+
+		for _, l := range exit.Stderr {
+			fmt.Printf("  %s\n", l)
+		}
+	}
+	if status.Cgroup != nil {
+		cg := status.Cgroup
+
+		quota := "none"
+		if cg.CpuQuota > 0 {
+			quota = fmt.Sprintf("%d%%", cg.CpuQuota)
+		}
+
+		memoryMax := "none"
+		if cg.MemoryMax > 0 {
+			memoryMax = fmt.Sprintf("%d MiB", cg.MemoryMax)
+		}
+
+		fmt.Printf("\nCgroup: %s\n", cg.Path)
+		fmt.Printf("  CPU quota %s, weight %d, CPUs %s, throttled %d us\n", quota, cg.CpuWeight, cg.CpuSet, cg.CpuThrottled)
+		fmt.Printf("  Memory %d MiB, limit %s\n", cg.MemoryCurrent, memoryMax)
+
+		for _, v := range cg.Vcpus {
+			fmt.Printf("  vCPU %d: thread %d on CPUs %s\n", v.Index, v.Thread, v.CpuSet)
+		}
+	}
+}
+
+// MachineBalloon changes the memory target of the balloon
+// of the machine if requested, and prints its status
+func MachineBalloon() {
+	var def shared.BalloonDef
+	var err error
+
+	if *CMachineBalloonTarget > 0 {
+		def, err = client.MachineSetBalloon(GetRemote(), *CMachineBalloonID, shared.BalloonDef{Target: *CMachineBalloonTarget})
+	} else {
+		def, err = client.MachineGetBalloon(GetRemote(), *CMachineBalloonID)
+	}
+
+	if err != nil {
+		Fatal(err)
+	}
+
+	auto := "false"
+	if def.Auto {
+		auto = "true"
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Current Memory (MiB)",
+		"Min (MiB)",
+		"Max (MiB)",
+		"Automatic",
+	})
+
+	table.Append([]string{
+		strconv.FormatUint(def.Actual, 10),
+		strconv.FormatUint(def.Min, 10),
+		strconv.FormatUint(def.Max, 10),
+		auto,
 	})
 
 	table.Render()
+
+	if def.Target > 0 {
+		fmt.Printf("\nTarget set to %d MiB\n", def.Target)
+	}
+}
+
+// MachineVnc prints the URL of the VNC proxy of
+// the machine, valid for a short period of time
+func MachineVnc() {
+	token, err := client.MachineVncToken(GetRemote(), *CMachineVncID)
+	if err != nil {
+		Fatal(err)
+	}
+
+	if len(*CMachineVncCerts) > 0 {
+		certs, err := client.MachineVncCerts(GetRemote(), *CMachineVncID, token)
+		if err != nil {
+			Fatal(err)
+		}
+
+		WriteCerts(*CMachineVncCerts, certs)
+		return
+	}
+
+	fmt.Println(client.MachineVncURL(GetRemote(), *CMachineVncID, token))
+}
+
+// MachineEvents prints the event
+// history of the machine
+func MachineEvents() {
+	events, err := client.MachineEvents(GetRemote(), *CMachineEventsID)
+	if err != nil {
+		Fatal(err)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "Event", "Message"})
+
+	for _, e := range events {
+		table.Append([]string{
+			time.Unix(e.Timestamp, 0).Format(time.RFC3339),
+			e.Type,
+			e.Message,
+		})
+	}
+
+	table.Render()
+}
+
+// MachineSpice prints the URI of the
+// SPICE server of the machine
+func MachineSpice() {
+	if len(*CMachineSpiceCerts) > 0 {
+		certs, err := client.MachineSpiceCerts(GetRemote(), *CMachineSpiceID)
+		if err != nil {
+			Fatal(err)
+		}
+
+		WriteCerts(*CMachineSpiceCerts, certs)
+		return
+	}
+
+	opts, err := client.MachineGetKvmOpts(GetRemote(), *CMachineSpiceID)
+	if err != nil {
+		Fatal(err)
+	}
+
+	if opts.Display != shared.DisplaySpice {
+		Fatal(fmt.Errorf("The machine does not use the SPICE display"))
+	}
+
+	// A server listening on all addresses is reached through the remote
+	host := opts.Spice.Address
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = GetRemote().Host
+	}
+
+	uri := fmt.Sprintf("spice://%s?", host)
+	if opts.Spice.Port > 0 {
+		uri = fmt.Sprintf("%sport=%d&", uri, opts.Spice.Port)
+	}
+	if opts.Spice.TLSPort > 0 {
+		uri = fmt.Sprintf("%stls-port=%d&", uri, opts.Spice.TLSPort)
+	}
+
+	fmt.Println(strings.TrimSuffix(uri, "&"))
+}
+
+// WriteCerts writes TLS certificates
+// received from the remote into a folder
+func WriteCerts(dir string, certs shared.VncCertsDef) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		Fatal(err)
+	}
+
+	files := map[string]string{
+		"ca-cert.pem":     certs.CACert,
+		"client-cert.pem": certs.ClientCert,
+		"client-key.pem":  certs.ClientKey,
+	}
+
+	for name, data := range files {
+		if len(data) == 0 {
+			continue
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		if err != nil {
+			Fatal(err)
+		}
+	}
 }
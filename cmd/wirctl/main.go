@@ -15,6 +15,22 @@ var (
 	// Global flags
 	CRemote = kingpin.Flag("remote", "Remote API server (host:port)").Default("127.0.0.1:8000").String()
 
+	// Admin command
+	CAdminCommand = kingpin.Command("admin", "Host administration actions")
+
+	CAdminReconcile = CAdminCommand.Command("reconcile", "Check the database against the host and repair it")
+
+	// Job command
+	CJobCommand = kingpin.Command("job", "Background jobs actions")
+
+	CJobList = CJobCommand.Command("list", "List all the jobs")
+
+	CJobWait   = CJobCommand.Command("wait", "Wait for a job to be over")
+	CJobWaitID = CJobWait.Arg("id", "Job ID").Required().String()
+
+	CJobCancel   = CJobCommand.Command("cancel", "Cancel a job")
+	CJobCancelID = CJobCancel.Arg("id", "Job ID").Required().String()
+
 	// Image command
 	CImageCommand = kingpin.Command("image", "Images manipulation actions")
 
@@ -64,21 +80,52 @@ var (
 	CNetworkDelete     = CNetworkCommand.Command("delete", "Delete a network")
 	CNetworkDeleteName = CNetworkDelete.Arg("id", "Network name").Required().String()
 
+	// Flavor command
+	CFlavorCommand = kingpin.Command("flavor", "Flavor manipulation actions")
+
+	CFlavorList = CFlavorCommand.Command("list", "List all the flavors")
+
+	// Flavor creation
+	CFlavorCreate             = CFlavorCommand.Command("create", "Create a new flavor")
+	CFlavorCreateName         = CFlavorCreate.Flag("name", "Flavor name").Required().String()
+	CFlavorCreateCores        = CFlavorCreate.Flag("cores", "Number of CPUs").Required().Int()
+	CFlavorCreateMemory       = CFlavorCreate.Flag("ram", "Quantity of RAM in MiB").Required().Uint64()
+	CFlavorCreateDisk         = CFlavorCreate.Flag("disk", "Disk space in bytes (0: size of the image)").Uint64()
+	CFlavorCreateDiskThrottle = CFlavorCreate.Flag("disk-throttle", "Disk I/O limits: comma-separated name=value pairs ({read,write}-{iops,bps}[-burst]), or none").String()
+	CFlavorCreateCpuQuota     = CFlavorCreate.Flag("cpu-quota", "Maximum CPU time in percent of one host CPU (0: no limit)").Int()
+	CFlavorCreateCpuWeight    = CFlavorCreate.Flag("cpu-weight", "Relative share of the CPU time, from 1 to 10000 (0: default)").Int()
+
+	// Flavor update
+	CFlavorUpdate             = CFlavorCommand.Command("update", "Update a flavor that no machine uses")
+	CFlavorUpdateName         = CFlavorUpdate.Arg("name", "Flavor name").Required().String()
+	CFlavorUpdateCores        = CFlavorUpdate.Flag("cores", "Number of CPUs").Int()
+	CFlavorUpdateMemory       = CFlavorUpdate.Flag("ram", "Quantity of RAM in MiB").Uint64()
+	CFlavorUpdateDisk         = CFlavorUpdate.Flag("disk", "Disk space in bytes (0: size of the image)").Default("-1").Int64()
+	CFlavorUpdateDiskThrottle = CFlavorUpdate.Flag("disk-throttle", "Disk I/O limits: comma-separated name=value pairs ({read,write}-{iops,bps}[-burst]), or none").String()
+	CFlavorUpdateCpuQuota     = CFlavorUpdate.Flag("cpu-quota", "Maximum CPU time in percent of one host CPU (0: no limit)").Default("-1").Int()
+	CFlavorUpdateCpuWeight    = CFlavorUpdate.Flag("cpu-weight", "Relative share of the CPU time, from 1 to 10000 (0: default)").Default("-1").Int()
+
+	// Flavor delete
+	CFlavorDelete     = CFlavorCommand.Command("delete", "Delete a flavor that no machine uses")
+	CFlavorDeleteName = CFlavorDelete.Arg("name", "Flavor name").Required().String()
+
 	// Volume command
 	CVolumeCommand = kingpin.Command("volume", "Volume manipulation actions")
 
 	CVolumeList = CVolumeCommand.Command("list", "List all the volumes")
 
 	// Volume creation
-	CVolumeCreate     = CVolumeCommand.Command("create", "Create a new volume")
-	CVolumeCreateName = CVolumeCreate.Flag("name", "Volume name").Required().String()
-	CVolumeCreateType = CVolumeCreate.Flag("type", "Volume type (kvm, vz)").Required().String()
-	CVolumeCreateSize = CVolumeCreate.Flag("size", "Volume size in bytes").Required().Uint64()
+	CVolumeCreate         = CVolumeCommand.Command("create", "Create a new volume")
+	CVolumeCreateName     = CVolumeCreate.Flag("name", "Volume name").Required().String()
+	CVolumeCreateType     = CVolumeCreate.Flag("type", "Volume type (kvm, vz)").Required().String()
+	CVolumeCreateSize     = CVolumeCreate.Flag("size", "Volume size in bytes").Required().Uint64()
+	CVolumeCreateThrottle = CVolumeCreate.Flag("throttle", "I/O limits: comma-separated name=value pairs ({read,write}-{iops,bps}[-burst]), or none").String()
 
 	// Volume update
-	CVolumeUpdate     = CVolumeCommand.Command("update", "Update a volume")
-	CVolumeUpdateID   = CVolumeUpdate.Arg("id", "Volume ID").Required().String()
-	CVolumeUpdateName = CVolumeUpdate.Flag("name", "Volume name").Required().String()
+	CVolumeUpdate         = CVolumeCommand.Command("update", "Update a volume")
+	CVolumeUpdateID       = CVolumeUpdate.Arg("id", "Volume ID").Required().String()
+	CVolumeUpdateName     = CVolumeUpdate.Flag("name", "Volume name").String()
+	CVolumeUpdateThrottle = CVolumeUpdate.Flag("throttle", "I/O limits: comma-separated name=value pairs ({read,write}-{iops,bps}[-burst]), or none").String()
 
 	// Volume delete
 	CVolumeDelete   = CVolumeCommand.Command("delete", "Delete a volume")
@@ -90,43 +137,72 @@ var (
 	CMachineList = CMachineCommand.Command("list", "List all the machines")
 
 	// Machine creation
-	CMachineCreate       = CMachineCommand.Command("create", "Create a new machine")
-	CMachineCreateName   = CMachineCreate.Flag("name", "Machine name").Required().String()
-	CMachineCreateImage  = CMachineCreate.Flag("image", "Image ID").String()
-	CMachineCreateCores  = CMachineCreate.Flag("cores", "Number of CPUs").Required().Int()
-	CMachineCreateMemory = CMachineCreate.Flag("ram", "Quantity of RAM in MiB").Required().Uint64()
-	CMachineCreateDisk   = CMachineCreate.Flag("disk", "Maximum disk space in bytes").Uint64()
+	CMachineCreate             = CMachineCommand.Command("create", "Create a new machine")
+	CMachineCreateName         = CMachineCreate.Flag("name", "Machine name").Required().String()
+	CMachineCreateImage        = CMachineCreate.Flag("image", "Image ID").String()
+	CMachineCreateFlavor       = CMachineCreate.Flag("flavor", "Flavor to take the cores, RAM, disk and limits from").String()
+	CMachineCreateCores        = CMachineCreate.Flag("cores", "Number of CPUs (required without a flavor)").Int()
+	CMachineCreateMemory       = CMachineCreate.Flag("ram", "Quantity of RAM in MiB (required without a flavor)").Uint64()
+	CMachineCreateDisk         = CMachineCreate.Flag("disk", "Maximum disk space in bytes").Uint64()
+	CMachineCreateRestart      = CMachineCreate.Flag("restart", "Restart policy (never, on-boot, always, on-failure)").String()
+	CMachineCreateDiskThrottle = CMachineCreate.Flag("disk-throttle", "Disk I/O limits: comma-separated name=value pairs ({read,write}-{iops,bps}[-burst]), or none").String()
 
 	// Machine update
-	CMachineUpdate       = CMachineCommand.Command("update", "Update a machine")
-	CMachineUpdateID     = CMachineUpdate.Arg("id", "Machine ID").Required().String()
-	CMachineUpdateName   = CMachineUpdate.Flag("name", "Machine name").String()
-	CMachineUpdateCores  = CMachineUpdate.Flag("cores", "Number of CPUs").Int()
-	CMachineUpdateMemory = CMachineUpdate.Flag("ram", "Quantity of RAM in MiB").Uint64()
-	CMachineUpdateDisk   = CMachineUpdate.Flag("disk", "Maximum disk space in bytes").Uint64()
+	CMachineUpdate             = CMachineCommand.Command("update", "Update a machine")
+	CMachineUpdateID           = CMachineUpdate.Arg("id", "Machine ID").Required().String()
+	CMachineUpdateName         = CMachineUpdate.Flag("name", "Machine name").String()
+	CMachineUpdateCores        = CMachineUpdate.Flag("cores", "Number of CPUs (the machine no longer follows its flavor)").Int()
+	CMachineUpdateMemory       = CMachineUpdate.Flag("ram", "Quantity of RAM in MiB (the machine no longer follows its flavor)").Uint64()
+	CMachineUpdateDisk         = CMachineUpdate.Flag("disk", "Maximum disk space in bytes").Uint64()
+	CMachineUpdateRestart      = CMachineUpdate.Flag("restart", "Restart policy (never, on-boot, always, on-failure)").String()
+	CMachineUpdateDiskThrottle = CMachineUpdate.Flag("disk-throttle", "Disk I/O limits: comma-separated name=value pairs ({read,write}-{iops,bps}[-burst]), or none (the machine no longer follows its flavor)").String()
+
+	// Machine resize
+	CMachineResize       = CMachineCommand.Command("resize", "Move a machine to another flavor")
+	CMachineResizeID     = CMachineResize.Arg("id", "Machine ID").Required().String()
+	CMachineResizeFlavor = CMachineResize.Flag("flavor", "Flavor name").Required().String()
 
 	// Machine delete
 	CMachineDelete   = CMachineCommand.Command("delete", "Delete a machine")
 	CMachineDeleteID = CMachineDelete.Arg("id", "Machine ID").Required().String()
 
+	// Machine volumes
+	CMachineVol = CMachineCommand.Command("volume", "Volume attachment actions")
+
+	CMachineVolAttach        = CMachineVol.Command("attach", "Attach a volume (hot-plugged if the machine is running)")
+	CMachineVolAttachMachine = CMachineVolAttach.Arg("machine", "Machine ID").Required().String()
+	CMachineVolAttachVolume  = CMachineVolAttach.Arg("volume", "Volume ID").Required().String()
+
+	CMachineVolDetach        = CMachineVol.Command("detach", "Detach a volume (unplugged if the machine is running)")
+	CMachineVolDetachMachine = CMachineVolDetach.Arg("machine", "Machine ID").Required().String()
+	CMachineVolDetachVolume  = CMachineVolDetach.Arg("volume", "Volume ID").Required().String()
+
 	// Machine network interfaces
 	CMachineNic = CMachineCommand.Command("interface", "Network interface manipulation actions")
 
 	CMachineNicList        = CMachineNic.Command("list", "List interfaces")
 	CMachineNicListMachine = CMachineNicList.Arg("machine", "Machine ID").Required().String()
 
-	CMachineNicCreate        = CMachineNic.Command("create", "Create an interface")
-	CMachineNicCreateMachine = CMachineNicCreate.Arg("machine", "Machine ID").Required().String()
-	CMachineNicCreateNetwork = CMachineNicCreate.Flag("network", "Network ID").Required().String()
-	CMachineNicCreateMAC     = CMachineNicCreate.Flag("mac", "MAC address").String()
-	CMachineNicCreateIP      = CMachineNicCreate.Flag("ip", "IP address").String()
-
-	CMachineNicUpdate        = CMachineNic.Command("update", "Update an interface")
-	CMachineNicUpdateMachine = CMachineNicUpdate.Arg("machine", "Machine ID").Required().String()
-	CMachineNicUpdateIndex   = CMachineNicUpdate.Arg("index", "Interface index").Required().Int()
-	CMachineNicUpdateNetwork = CMachineNicUpdate.Flag("network", "Network ID").String()
-	CMachineNicUpdateMAC     = CMachineNicUpdate.Flag("mac", "MAC address").String()
-	CMachineNicUpdateIP      = CMachineNicUpdate.Flag("ip", "IP address").String()
+	CMachineNicCreate         = CMachineNic.Command("create", "Create an interface")
+	CMachineNicCreateMachine  = CMachineNicCreate.Arg("machine", "Machine ID").Required().String()
+	CMachineNicCreateNetwork  = CMachineNicCreate.Flag("network", "Network ID").Required().String()
+	CMachineNicCreateMAC      = CMachineNicCreate.Flag("mac", "MAC address").String()
+	CMachineNicCreateIP       = CMachineNicCreate.Flag("ip", "IP address").String()
+	CMachineNicCreateInRate   = CMachineNicCreate.Flag("ingress-rate", "Rate of the traffic received by the machine in kbit/s").Uint64()
+	CMachineNicCreateInBurst  = CMachineNicCreate.Flag("ingress-burst", "Burst of received traffic in KiB").Uint64()
+	CMachineNicCreateOutRate  = CMachineNicCreate.Flag("egress-rate", "Rate of the traffic sent by the machine in kbit/s").Uint64()
+	CMachineNicCreateOutBurst = CMachineNicCreate.Flag("egress-burst", "Burst of sent traffic in KiB").Uint64()
+
+	CMachineNicUpdate         = CMachineNic.Command("update", "Update an interface")
+	CMachineNicUpdateMachine  = CMachineNicUpdate.Arg("machine", "Machine ID").Required().String()
+	CMachineNicUpdateIndex    = CMachineNicUpdate.Arg("index", "Interface index").Required().Int()
+	CMachineNicUpdateNetwork  = CMachineNicUpdate.Flag("network", "Network ID").String()
+	CMachineNicUpdateMAC      = CMachineNicUpdate.Flag("mac", "MAC address").String()
+	CMachineNicUpdateIP       = CMachineNicUpdate.Flag("ip", "IP address").String()
+	CMachineNicUpdateInRate   = CMachineNicUpdate.Flag("ingress-rate", "Rate of the traffic received by the machine in kbit/s (0: no limit)").Default("-1").Int64()
+	CMachineNicUpdateInBurst  = CMachineNicUpdate.Flag("ingress-burst", "Burst of received traffic in KiB (0: default)").Default("-1").Int64()
+	CMachineNicUpdateOutRate  = CMachineNicUpdate.Flag("egress-rate", "Rate of the traffic sent by the machine in kbit/s (0: no limit)").Default("-1").Int64()
+	CMachineNicUpdateOutBurst = CMachineNicUpdate.Flag("egress-burst", "Burst of sent traffic in KiB (0: default)").Default("-1").Int64()
 
 	CMachineNicDelete        = CMachineNic.Command("delete", "Delete an interface")
 	CMachineNicDeleteMachine = CMachineNicDelete.Arg("machine", "Machine ID").Required().String()
@@ -138,27 +214,104 @@ var (
 	CMachineKvmGet   = CMachineKvm.Command("get", "Show KVM options")
 	CMachineKvmGetID = CMachineKvmGet.Arg("id", "Machine ID").Required().String()
 
-	CMachineKvmSet                = CMachineKvm.Command("set", "Set KVM options")
-	CMachineKvmSetID              = CMachineKvmSet.Arg("id", "Machine ID").Required().String()
-	CMachineKvmSetCDRom           = CMachineKvmSet.Flag("cdrom", "CD-ROM to be inserted into the machine at boot").String()
-	CMachineKvmSetVncEnabled      = CMachineKvmSet.Flag("vnc", "VNC server active").Bool()
-	CMachineKvmSetVncAddr         = CMachineKvmSet.Flag("vnc-address", "VNC server bind address").String()
-	CMachineKvmSetVncPort         = CMachineKvmSet.Flag("vnc-display", "VNC display port").Int()
-	CMachineKvmSetLinuxHostname   = CMachineKvmSet.Flag("linux-hostname", "Linux guest specific: hostname").String()
-	CMachineKvmSetLinuxRootPasswd = CMachineKvmSet.Flag("linux-root", "Linux guest specific: root password").String()
+	CMachineKvmSet                  = CMachineKvm.Command("set", "Set KVM options")
+	CMachineKvmSetID                = CMachineKvmSet.Arg("id", "Machine ID").Required().String()
+	CMachineKvmSetCDRom             = CMachineKvmSet.Flag("cdrom", "CD-ROM to be inserted into the machine at boot").String()
+	CMachineKvmSetVncEnabled        = CMachineKvmSet.Flag("vnc", "VNC server active").Bool()
+	CMachineKvmSetVncAddr           = CMachineKvmSet.Flag("vnc-address", "VNC server bind address").String()
+	CMachineKvmSetVncProxy          = CMachineKvmSet.Flag("vnc-proxy", "Proxy VNC through the API instead of binding an address (see 'machine vnc')").Bool()
+	CMachineKvmSetVncPort           = CMachineKvmSet.Flag("vnc-display", "VNC display port (allocated automatically if not specified)").Int()
+	CMachineKvmSetVncWsPort         = CMachineKvmSet.Flag("vnc-websocket", "VNC websocket port (allocated automatically if not specified)").Int()
+	CMachineKvmSetVncTls            = CMachineKvmSet.Flag("vnc-tls", "Encrypt VNC connections with TLS").Bool()
+	CMachineKvmSetVncNoTls          = CMachineKvmSet.Flag("vnc-no-tls", "Do not encrypt VNC connections").Bool()
+	CMachineKvmSetVncVerifyClient   = CMachineKvmSet.Flag("vnc-verify-client", "Require VNC clients to present a certificate (see 'machine vnc --certs')").Bool()
+	CMachineKvmSetVncPassword       = CMachineKvmSet.Flag("vnc-password", "VNC password").String()
+	CMachineKvmSetVncNoPassword     = CMachineKvmSet.Flag("vnc-no-password", "Remove the VNC password").Bool()
+	CMachineKvmSetVncPasswordExpiry = CMachineKvmSet.Flag("vnc-password-expiry", "Seconds during which the VNC password is valid after start (0: no expiry)").Default("-1").Int()
+	CMachineKvmSetDisplay           = CMachineKvmSet.Flag("display", "Remote display protocol (vnc, spice)").String()
+	CMachineKvmSetVideo             = CMachineKvmSet.Flag("video", "Video device (std, qxl, virtio)").String()
+	CMachineKvmSetVolumeBus         = CMachineKvmSet.Flag("volume-bus", "Bus of the volumes (ide, scsi: needed to attach volumes to the running machine)").String()
+	CMachineKvmSetSpiceAddr         = CMachineKvmSet.Flag("spice-address", "SPICE server bind address").String()
+	CMachineKvmSetSpicePort         = CMachineKvmSet.Flag("spice-port", "SPICE port of unencrypted connections (0: TLS only)").Default("-1").Int()
+	CMachineKvmSetSpiceTlsPort      = CMachineKvmSet.Flag("spice-tls-port", "SPICE port of TLS connections (0: no TLS)").Default("-1").Int()
+	CMachineKvmSetSpicePassword     = CMachineKvmSet.Flag("spice-password", "SPICE password").String()
+	CMachineKvmSetSpiceAgent        = CMachineKvmSet.Flag("spice-agent", "Add the channel of the SPICE guest agent").Bool()
+	CMachineKvmSetSpiceNoAgent      = CMachineKvmSet.Flag("spice-no-agent", "Remove the channel of the SPICE guest agent").Bool()
+	CMachineKvmSetCpuModel          = CMachineKvmSet.Flag("cpu-model", "CPU model ('host' for passthrough, or a QEMU model name)").String()
+	CMachineKvmSetCpuSockets        = CMachineKvmSet.Flag("cpu-sockets", "Number of CPU sockets").Int()
+	CMachineKvmSetCpuThreads        = CMachineKvmSet.Flag("cpu-threads", "Number of threads per CPU core").Int()
+	CMachineKvmSetCpuNested         = CMachineKvmSet.Flag("cpu-nested", "Enable nested virtualization").Bool()
+	CMachineKvmSetCpuNoNested       = CMachineKvmSet.Flag("cpu-no-nested", "Disable nested virtualization").Bool()
+	CMachineKvmSetCpuFlags          = CMachineKvmSet.Flag("cpu-flags", "Comma-separated CPU flags to enable (+flag) or disable (-flag), replacing the current ones").String()
+	CMachineKvmSetFirmware          = CMachineKvmSet.Flag("firmware", "Firmware (bios, uefi)").String()
+	CMachineKvmSetBootOrder         = CMachineKvmSet.Flag("boot-order", "Comma-separated boot devices by priority (disk, cdrom, network)").String()
+	CMachineKvmSetBootMenu          = CMachineKvmSet.Flag("boot-menu", "Show the boot menu").Bool()
+	CMachineKvmSetBootNoMenu        = CMachineKvmSet.Flag("boot-no-menu", "Do not show the boot menu").Bool()
+	CMachineKvmSetMaxCores          = CMachineKvmSet.Flag("max-cores", "Number of vCPUs the running machine can grow to (0: no hot-plug)").Default("-1").Int()
+	CMachineKvmSetMaxMemory         = CMachineKvmSet.Flag("max-memory", "Memory in MiB the running machine can grow to (0: no hot-plug)").Default("-1").Int64()
+	CMachineKvmSetBalloonMin        = CMachineKvmSet.Flag("balloon-min", "Lowest memory target of the balloon in MiB (0: a quarter of the memory)").Default("-1").Int64()
+	CMachineKvmSetBalloonAuto       = CMachineKvmSet.Flag("balloon-auto", "Reclaim idle guest memory when the host is under pressure").Bool()
+	CMachineKvmSetBalloonNoAuto     = CMachineKvmSet.Flag("balloon-no-auto", "Never reclaim guest memory automatically").Bool()
+	CMachineKvmSetCpuQuota          = CMachineKvmSet.Flag("cpu-quota", "Maximum CPU time in percent of one host CPU (0: no limit)").Default("-1").Int()
+	CMachineKvmSetCpuWeight         = CMachineKvmSet.Flag("cpu-weight", "Relative share of the CPU time, from 1 to 10000 (0: default)").Default("-1").Int()
+	CMachineKvmSetCpuSet            = CMachineKvmSet.Flag("cpuset", "Host CPUs the vCPUs are pinned to (e.g. 0-3,8, 'all': no pinning)").String()
+	CMachineKvmSetMemoryMax         = CMachineKvmSet.Flag("memory-max", "Maximum memory of the QEMU process in MiB (0: no limit)").Default("-1").Int64()
+	CMachineKvmSetLinuxHostname     = CMachineKvmSet.Flag("linux-hostname", "Linux guest specific: hostname").String()
+	CMachineKvmSetLinuxRootPasswd   = CMachineKvmSet.Flag("linux-root", "Linux guest specific: root password").String()
 
 	// Machine start
 	CMachineStart   = CMachineCommand.Command("start", "Start a machine")
 	CMachineStartID = CMachineStart.Arg("id", "Machine ID").Required().String()
 
 	// Machine stop
-	CMachineStop   = CMachineCommand.Command("stop", "Stop a machine")
-	CMachineStopID = CMachineStop.Arg("id", "Machine ID").Required().String()
+	CMachineStop      = CMachineCommand.Command("stop", "Stop a machine")
+	CMachineStopID    = CMachineStop.Arg("id", "Machine ID").Required().String()
+	CMachineStopForce = CMachineStop.Flag("force", "Kill the machine instead of shutting it down").Bool()
+
+	// Machine pause
+	CMachinePause   = CMachineCommand.Command("pause", "Suspend the execution of a machine")
+	CMachinePauseID = CMachinePause.Arg("id", "Machine ID").Required().String()
+
+	// Machine resume
+	CMachineResume   = CMachineCommand.Command("resume", "Resume the execution of a paused machine")
+	CMachineResumeID = CMachineResume.Arg("id", "Machine ID").Required().String()
+
+	// Machine reset
+	CMachineReset   = CMachineCommand.Command("reset", "Hard reset a machine")
+	CMachineResetID = CMachineReset.Arg("id", "Machine ID").Required().String()
+
+	// Machine reboot
+	CMachineReboot   = CMachineCommand.Command("reboot", "Cleanly reboot a machine")
+	CMachineRebootID = CMachineReboot.Arg("id", "Machine ID").Required().String()
 
 	// Machine start
 	CMachineStatus   = CMachineCommand.Command("status", "Status of a machine")
 	CMachineStatusID = CMachineStatus.Arg("id", "Machine ID").Required().String()
 
+	// Machine events
+	CMachineEvents   = CMachineCommand.Command("events", "Event history of a machine")
+	CMachineEventsID = CMachineEvents.Arg("id", "Machine ID").Required().String()
+
+	// Machine balloon
+	CMachineBalloon       = CMachineCommand.Command("balloon", "Show or change the memory left to a running machine by its balloon")
+	CMachineBalloonID     = CMachineBalloon.Arg("id", "Machine ID").Required().String()
+	CMachineBalloonTarget = CMachineBalloon.Flag("target", "New memory target in MiB").Uint64()
+
+	// Machine VNC
+	CMachineVnc      = CMachineCommand.Command("vnc", "Get the websocket URL of the VNC proxy of a machine")
+	CMachineVncID    = CMachineVnc.Arg("id", "Machine ID").Required().String()
+	CMachineVncCerts = CMachineVnc.Flag("certs", "Write the TLS certificates of the VNC server into a folder instead").String()
+
+	// Machine SPICE
+	CMachineSpice      = CMachineCommand.Command("spice", "Get the URI of the SPICE server of a machine")
+	CMachineSpiceID    = CMachineSpice.Arg("id", "Machine ID").Required().String()
+	CMachineSpiceCerts = CMachineSpice.Flag("certs", "Write the TLS certificate authority of the SPICE server into a folder instead").String()
+
+	// Machine console
+	CMachineConsole    = CMachineCommand.Command("console", "Attach to the serial console of a machine (detach with Ctrl-])")
+	CMachineConsoleID  = CMachineConsole.Arg("id", "Machine ID").Required().String()
+	CMachineConsoleLog = CMachineConsole.Flag("log", "Print the logged console output instead of attaching").Bool()
+
 	// Machine checkpoints
 	CCheckpoint = CMachineCommand.Command("checkpoint", "Checkpoint manipulation actions")
 
@@ -198,11 +351,25 @@ func GetRemote() shared.RemoteDef {
 		Fatal(fmt.Errorf("Invalid remote port"))
 	}
 
-	return shared.RemoteDef{l[0], port}
+	return shared.RemoteDef{Host: l[0], Port: port}
 }
 
 func main() {
 	switch kingpin.Parse() {
+	case "admin reconcile":
+		AdminReconcile()
+		break
+
+	case "job list":
+		JobList()
+		break
+	case "job wait":
+		JobWait()
+		break
+	case "job cancel":
+		JobCancel()
+		break
+
 	case "image create":
 		ImageCreate()
 		break
@@ -229,6 +396,19 @@ func main() {
 		NetworkDelete()
 		break
 
+	case "flavor create":
+		FlavorCreate()
+		break
+	case "flavor list":
+		FlavorList()
+		break
+	case "flavor update":
+		FlavorUpdate()
+		break
+	case "flavor delete":
+		FlavorDelete()
+		break
+
 	case "volume create":
 		VolumeCreate()
 		break
@@ -251,10 +431,20 @@ func main() {
 	case "machine update":
 		MachineUpdate()
 		break
+	case "machine resize":
+		MachineResize()
+		break
 	case "machine delete":
 		MachineDelete()
 		break
 
+	case "machine volume attach":
+		MachineVolumeAttach()
+		break
+	case "machine volume detach":
+		MachineVolumeDetach()
+		break
+
 	case "machine interface create":
 		MachineInterfaceCreate()
 		break
@@ -281,10 +471,37 @@ func main() {
 	case "machine stop":
 		MachineStop()
 		break
+	case "machine pause":
+		MachinePause()
+		break
+	case "machine resume":
+		MachineResume()
+		break
+	case "machine reset":
+		MachineReset()
+		break
+	case "machine reboot":
+		MachineReboot()
+		break
 
 	case "machine status":
 		MachineStatus()
 		break
+	case "machine events":
+		MachineEvents()
+		break
+	case "machine console":
+		MachineConsole()
+		break
+	case "machine balloon":
+		MachineBalloon()
+		break
+	case "machine vnc":
+		MachineVnc()
+		break
+	case "machine spice":
+		MachineSpice()
+		break
 
 	case "machine checkpoint create":
 		MachineCheckpointCreate()
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// throttleKeys returns the limits of the specified
+// I/O limits definition, by name
+func throttleKeys(def *shared.ThrottleDef) map[string]*uint64 {
+	return map[string]*uint64{
+		"read-iops":        &def.ReadIOPS,
+		"write-iops":       &def.WriteIOPS,
+		"read-bps":         &def.ReadBPS,
+		"write-bps":        &def.WriteBPS,
+		"read-iops-burst":  &def.ReadIOPSBurst,
+		"write-iops-burst": &def.WriteIOPSBurst,
+		"read-bps-burst":   &def.ReadBPSBurst,
+		"write-bps-burst":  &def.WriteBPSBurst,
+	}
+}
+
+// ParseThrottle parses I/O limits written as comma-separated
+// 'name=value' pairs (e.g. read-iops=500,write-bps=10485760),
+// or 'none' for no limit
+func ParseThrottle(s string) (shared.ThrottleDef, error) {
+	var def shared.ThrottleDef
+
+	if s == "none" {
+		return def, nil
+	}
+
+	keys := throttleKeys(&def)
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return def, fmt.Errorf("Invalid I/O limit '%s' (must be name=value)", pair)
+		}
+
+		limit, ok := keys[kv[0]]
+		if !ok {
+			return def, fmt.Errorf("Unknown I/O limit '%s'", kv[0])
+		}
+
+		v, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			return def, fmt.Errorf("Invalid value for I/O limit '%s'", kv[0])
+		}
+
+		*limit = v
+	}
+
+	return def, nil
+}
+
+// FormatThrottle formats the specified I/O limits
+// the way ParseThrottle parses them
+func FormatThrottle(def shared.ThrottleDef) string {
+	var pairs []string
+
+	for _, k := range []string{"read-iops", "write-iops", "read-bps", "write-bps", "read-iops-burst", "write-iops-burst", "read-bps-burst", "write-bps-burst"} {
+		if v := *throttleKeys(&def)[k]; v > 0 {
+			pairs = append(pairs, fmt.Sprintf("%s=%d", k, v))
+		}
+	}
+
+	if len(pairs) == 0 {
+		return "none"
+	}
+
+	return strings.Join(pairs, ",")
+}
@@ -26,6 +26,7 @@ func VolumeList() {
 			"Name",
 			"Type",
 			"Size",
+			"I/O Limits",
 		})
 
 		for _, vol := range vols {
@@ -34,6 +35,7 @@ func VolumeList() {
 				vol.Name,
 				vol.Type,
 				strconv.FormatUint(vol.Size, 10),
+				FormatThrottle(vol.Throttle),
 			})
 		}
 
@@ -49,6 +51,15 @@ func VolumeCreate() {
 	req.Type = *CVolumeCreateType
 	req.Size = *CVolumeCreateSize
 
+	if len(*CVolumeCreateThrottle) > 0 {
+		throttle, err := ParseThrottle(*CVolumeCreateThrottle)
+		if err != nil {
+			Fatal(err)
+		}
+
+		req.Throttle = throttle
+	}
+
 	vol, err := client.VolumeCreate(GetRemote(), req)
 	if err != nil {
 		Fatal(err)
@@ -68,6 +79,14 @@ func VolumeUpdate() {
 	if len(*CVolumeUpdateName) > 0 {
 		req.Name = *CVolumeUpdateName
 	}
+	if len(*CVolumeUpdateThrottle) > 0 {
+		throttle, err := ParseThrottle(*CVolumeUpdateThrottle)
+		if err != nil {
+			Fatal(err)
+		}
+
+		req.Throttle = throttle
+	}
 
 	_, err = client.VolumeUpdate(GetRemote(), *CVolumeUpdateID, req)
 	if err != nil {
@@ -83,3 +102,21 @@ func VolumeDelete() {
 		Fatal(err)
 	}
 }
+
+// MachineVolumeAttach attaches a
+// volume to a machine
+func MachineVolumeAttach() {
+	_, err := client.MachineVolumeAttach(GetRemote(), *CMachineVolAttachMachine, *CMachineVolAttachVolume)
+	if err != nil {
+		Fatal(err)
+	}
+}
+
+// MachineVolumeDetach detaches a
+// volume from a machine
+func MachineVolumeDetach() {
+	_, err := client.MachineVolumeDetach(GetRemote(), *CMachineVolDetachMachine, *CMachineVolDetachVolume)
+	if err != nil {
+		Fatal(err)
+	}
+}
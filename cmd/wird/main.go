@@ -20,12 +20,26 @@ type config struct {
 		Node     byte
 		Listen   string // Listen address of the HTTP server
 		Database string // Path of the database file
+		Backend  string // Backend to use for all machines ('fake' for testing, empty for default)
+
+		StopTimeout int // Seconds given to machines to shut down before being killed
+	}
+
+	Vnc struct {
+		Displays       string // Range of VNC display numbers allocated to machines (min-max)
+		WebsocketPorts string // Range of VNC websocket ports allocated to machines (min-max)
+	}
+
+	Firmware struct {
+		OvmfCode string // UEFI firmware code
+		OvmfVars string // Template of the UEFI variables of the machines
 	}
 
 	Storage struct {
 		Images   string // Folder in which images are stored
 		Volumes  string // Folder in which volumes are stored
 		Machines string // Folder in which machines are stored
+		Pki      string // Folder of the certificate authority managed by the server
 	}
 }
 
@@ -39,7 +53,24 @@ func main() {
 
 	log.Printf("Starting wird - Node #%d\n", c.Server.Node)
 
-	err := server.Init(c.Server.Node, c.Server.Database, c.Storage.Images, c.Storage.Volumes, c.Storage.Machines)
+	err := server.Init(server.Config{
+		NodeID:   c.Server.Node,
+		Database: c.Server.Database,
+
+		ImagePath:   c.Storage.Images,
+		VolumePath:  c.Storage.Volumes,
+		MachinePath: c.Storage.Machines,
+		PkiPath:     c.Storage.Pki,
+
+		Backend:     c.Server.Backend,
+		StopTimeout: c.Server.StopTimeout,
+
+		VncDisplays: c.Vnc.Displays,
+		VncWsPorts:  c.Vnc.WebsocketPorts,
+
+		OvmfCode: c.Firmware.OvmfCode,
+		OvmfVars: c.Firmware.OvmfVars,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -49,6 +80,11 @@ func main() {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", server.HandleIndex).Methods("GET")
+	r.HandleFunc("/admin/reconcile", server.HandleAdminReconcile).Methods("GET")
+
+	r.HandleFunc("/jobs", server.HandleJobList).Methods("GET")
+	r.HandleFunc("/jobs/{id}", server.HandleJobGet).Methods("GET")
+	r.HandleFunc("/jobs/{id}/cancel", server.HandleJobCancel).Methods("GET")
 
 	r.HandleFunc("/images", server.HandleImageCreate).Methods("POST")
 	r.HandleFunc("/images", server.HandleImageList).Methods("GET")
@@ -63,6 +99,12 @@ func main() {
 	r.HandleFunc("/networks/{name}", server.HandleNetworkUpdate).Methods("POST")
 	r.HandleFunc("/networks/{name}", server.HandleNetworkDelete).Methods("DELETE")
 
+	r.HandleFunc("/flavors", server.HandleFlavorCreate).Methods("POST")
+	r.HandleFunc("/flavors", server.HandleFlavorList).Methods("GET")
+	r.HandleFunc("/flavors/{name}", server.HandleFlavorGet).Methods("GET")
+	r.HandleFunc("/flavors/{name}", server.HandleFlavorUpdate).Methods("POST")
+	r.HandleFunc("/flavors/{name}", server.HandleFlavorDelete).Methods("DELETE")
+
 	r.HandleFunc("/volumes", server.HandleVolumeCreate).Methods("POST")
 	r.HandleFunc("/volumes", server.HandleVolumeList).Methods("GET")
 	r.HandleFunc("/volumes/{id}", server.HandleVolumeGet).Methods("GET")
@@ -79,8 +121,27 @@ func main() {
 	r.HandleFunc("/machines/{id}/kvm", server.HandleMachineSetKvmOpts).Methods("POST")
 	r.HandleFunc("/machines/{id}/start", server.HandleMachineStart).Methods("GET")
 	r.HandleFunc("/machines/{id}/stop", server.HandleMachineStop).Methods("GET")
+	r.HandleFunc("/machines/{id}/pause", server.HandleMachinePause).Methods("GET")
+	r.HandleFunc("/machines/{id}/resume", server.HandleMachineResume).Methods("GET")
+	r.HandleFunc("/machines/{id}/reset", server.HandleMachineReset).Methods("GET")
+	r.HandleFunc("/machines/{id}/reboot", server.HandleMachineReboot).Methods("GET")
 	r.HandleFunc("/machines/{id}/status", server.HandleMachineStatus).Methods("GET")
+	r.HandleFunc("/machines/{id}/volumes/{vol}/attach", server.HandleMachineVolumeAttach).Methods("POST")
+	r.HandleFunc("/machines/{id}/volumes/{vol}/detach", server.HandleMachineVolumeDetach).Methods("POST")
+	r.HandleFunc("/machines/{id}/interfaces", server.HandleMachineInterfaceAdd).Methods("POST")
+	r.HandleFunc("/machines/{id}/interfaces/{index}", server.HandleMachineInterfaceRemove).Methods("DELETE")
+	r.HandleFunc("/machines/{id}/balloon", server.HandleMachineGetBalloon).Methods("GET")
+	r.HandleFunc("/machines/{id}/balloon", server.HandleMachineSetBalloon).Methods("POST")
+	r.HandleFunc("/machines/{id}/resize", server.HandleMachineResize).Methods("POST")
+	r.HandleFunc("/machines/{id}/events", server.HandleMachineEvents).Methods("GET")
+	r.HandleFunc("/machines/{id}/console", server.HandleMachineConsole).Methods("GET")
+	r.HandleFunc("/machines/{id}/console/log", server.HandleMachineConsoleLog).Methods("GET")
+	r.HandleFunc("/machines/{id}/vnc", server.HandleMachineVnc).Methods("GET")
+	r.HandleFunc("/machines/{id}/vnc/token", server.HandleMachineVncToken).Methods("GET")
+	r.HandleFunc("/machines/{id}/vnc/certs", server.HandleMachineVncCerts).Methods("GET")
+	r.HandleFunc("/machines/{id}/spice/certs", server.HandleMachineSpiceCerts).Methods("GET")
 	r.HandleFunc("/machines/{id}/disk/data", server.HandleMachineDiskData).Methods("GET")
+	r.HandleFunc("/machines/{id}/nvram/data", server.HandleMachineNvramData).Methods("GET")
 
 	r.HandleFunc("/machines/{id}/checkpoints", server.HandleCheckpointCreate).Methods("POST")
 	r.HandleFunc("/machines/{id}/checkpoints", server.HandleCheckpointList).Methods("GET")
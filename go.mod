@@ -8,6 +8,7 @@ require (
 	github.com/alecthomas/units v0.0.0-20190924025748-f65c72e2690d // indirect
 	github.com/amoghe/go-crypt v0.0.0-20151031192136-f85b640b0eef
 	github.com/gorilla/mux v1.7.3
+	github.com/gorilla/websocket v1.4.1
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/olekukonko/tablewriter v0.0.2
 	github.com/quadrifoglio/go-dhcp v0.0.0-20161018170104-8d3c9cb1cfbe
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// Backend represents a hypervisor able to run machines
+// Each backend is registered under the image type it handles
+type Backend interface {
+	Create(def *shared.MachineDef) error                               // Create the machine's data (disk...)
+	SetLinuxOpts(id string, opts shared.LinuxOptsDef) error            // Apply the Linux options to the stopped machine
+	Start(id string) error                                             // Start the machine
+	Stop(id string, force bool) error                                  // Stop the machine (kill it right away if force)
+	Pause(id string) error                                             // Suspend the execution of the machine
+	Resume(id string) error                                            // Resume the execution of a paused machine
+	Reset(id string) error                                             // Hard reset the machine, which stays paused if it was
+	IsRunning(id string) bool                                          // Check if the machine is running
+	Status(id string) (shared.MachineStatusDef, error)                 // Get the machine's status & resource usage
+	CreateCheckpoint(id, name string) error                            // Create a checkpoint of the machine
+	ListCheckpoints(id string) ([]shared.CheckpointDef, error)         // List the machine's checkpoints
+	RestoreCheckpoint(id, name string) error                           // Restore the machine to a checkpoint
+	DeleteCheckpoint(id, name string) error                            // Delete a checkpoint of the machine
+	AttachVolume(id, vol string) error                                 // Plug a volume into the running machine
+	DetachVolume(id, vol string) error                                 // Unplug a volume from the running machine
+	AttachInterface(id string, n int, iface shared.InterfaceDef) error // Plug the n-th network interface into the running machine
+	DetachInterface(id string, n int) error                            // Unplug the n-th network interface from the running machine
+	SetThrottle(id, vol string, def shared.ThrottleDef) error          // Change the I/O limits of the disk (vol empty) or of a volume of the running machine
+	SetBandwidth(id string, n int, def shared.BandwidthDef) error      // Change the bandwidth limits of the n-th network interface of the running machine
+	HotAddCores(id string, cores int) error                            // Plug vCPUs into the running machine until it has the specified number
+	HotAddMemory(id string, memory uint64) error                       // Plug memory into the running machine until it has the specified amount (MiB)
+	Balloon(id string) (uint64, error)                                 // Get the memory (MiB) currently left to the running machine by its balloon
+	SetBalloon(id string, target uint64) error                         // Set the memory target (MiB) of the balloon of the running machine
+	GrowDisk(id string, size uint64) error                             // Grow the disk of the stopped machine to the specified size (bytes)
+	Delete(id string) error                                            // Delete the machine's data and its host network interfaces
+	Reconcile(fixed, problem ReportFunc)                               // Repair the host side shared by the machines (networks, cgroups...)
+	ReconcileMachine(def shared.MachineDef, fixed, problem ReportFunc) // Repair the host side of the machine (process, interfaces...)
+	CreateNetwork(def shared.NetworkDef) error                         // Create the host side of the network (bridge...)
+	DeleteNetwork(name string) error                                   // Delete the host side of the network
+}
+
+// KvmOptsBackend is implemented by the backends
+// running machines configured by KVM options
+type KvmOptsBackend interface {
+	ValidateKvmOpts(opts shared.KvmOptsDef) (error, int) // Check that the host supports the options (http status code)
+}
+
+// ReportFunc records a formatted message
+// in a reconciliation report
+type ReportFunc func(format string, args ...interface{})
+
+var (
+	backends = make(map[string]Backend)
+)
+
+func init() {
+	RegisterBackend(shared.BackendKVM, KvmBackend{})
+	RegisterBackend(shared.BackendLXC, LxcBackend{})
+}
+
+// RegisterBackend associates the specified backend
+// with an image type, replacing any previous one
+func RegisterBackend(typ string, b Backend) {
+	backends[typ] = b
+}
+
+// GetBackend returns the backend registered
+// for the specified image type
+func GetBackend(typ string) (Backend, error) {
+	b, ok := backends[typ]
+	if !ok {
+		return nil, fmt.Errorf("No backend available for type '%s'", typ)
+	}
+
+	return b, nil
+}
+
+// Backends returns the registered backends,
+// each one being listed only once
+func Backends() []Backend {
+	types := make([]string, 0, len(backends))
+	for typ := range backends {
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	list := make([]Backend, 0, len(types))
+
+	for _, typ := range types {
+		dup := false
+		for _, b := range list {
+			if b == backends[typ] {
+				dup = true
+			}
+		}
+
+		if !dup {
+			list = append(list, backends[typ])
+		}
+	}
+
+	return list
+}
+
+// MachineType returns the type of the specified machine, which
+// is the type of its image. Machines without images are KVM machines
+func MachineType(def shared.MachineDef) (string, error) {
+	if len(def.Image) == 0 {
+		return shared.BackendKVM, nil
+	}
+
+	img, err := DBImageGet(def.Image)
+	if err != nil {
+		return "", err
+	}
+
+	return img.Type, nil
+}
+
+// MachineBackend returns the backend that should handle the
+// specified machine, based on the type of its image
+// Machines without images are considered to be KVM machines
+func MachineBackend(def shared.MachineDef) (Backend, error) {
+	typ, err := MachineType(def)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetBackend(typ)
+}
+
+// MachineBackendByID returns the backend that should
+// handle the machine with the specified ID
+func MachineBackendByID(id string) (Backend, error) {
+	def, err := DBMachineGet(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return MachineBackend(def)
+}
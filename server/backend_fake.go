@@ -0,0 +1,441 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+const (
+	BackendFake = "fake"
+)
+
+// FakeBackend is a Backend implementation that does not run
+// any hypervisor: machines are simulated in memory, and their disks
+// and checkpoints are plain files. It is meant for testing purposes
+type FakeBackend struct {
+	mutex   sync.Mutex
+	running map[string]int    // Fake PIDs of the running machines
+	paused  map[string]bool   // Running machines that are paused
+	balloon map[string]uint64 // Memory targets (MiB) of the running machines, if changed
+	nextPid int
+}
+
+// NewFakeBackend creates a new fake backend
+// with no running machines
+func NewFakeBackend() *FakeBackend {
+	b := new(FakeBackend)
+	b.running = make(map[string]int)
+	b.paused = make(map[string]bool)
+	b.balloon = make(map[string]uint64)
+	b.nextPid = 100000
+
+	return b
+}
+
+// checkpointsFile returns the path of the file in which
+// the checkpoints of the specified machine are stored
+func (b *FakeBackend) checkpointsFile(id string) string {
+	return fmt.Sprintf("%s/checkpoints.json", MachinePath(id))
+}
+
+// readCheckpoints returns the stored checkpoints
+// of the specified machine
+func (b *FakeBackend) readCheckpoints(id string) ([]shared.CheckpointDef, error) {
+	chks := make([]shared.CheckpointDef, 0)
+
+	if !utils.FileExists(b.checkpointsFile(id)) {
+		return chks, nil
+	}
+
+	data, err := ioutil.ReadFile(b.checkpointsFile(id))
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &chks)
+	if err != nil {
+		return nil, err
+	}
+
+	return chks, nil
+}
+
+// writeCheckpoints replaces the stored checkpoints
+// of the specified machine
+func (b *FakeBackend) writeCheckpoints(id string, chks []shared.CheckpointDef) error {
+	data, err := json.Marshal(chks)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(b.checkpointsFile(id), data, 0644)
+}
+
+// Create creates an empty sparse disk file of the
+// requested size (or the size of the image)
+func (b *FakeBackend) Create(def *shared.MachineDef) error {
+	err := os.MkdirAll(MachinePath(def.ID), 0755)
+	if err != nil {
+		return err
+	}
+
+	if utils.FileExists(MachineDisk(def.ID)) { // Migration
+		return nil
+	}
+
+	if def.Disk == 0 && len(def.Image) > 0 {
+		size, err := utils.FileSize(ImageFile(def.Image))
+		if err != nil {
+			return err
+		}
+
+		def.Disk = size
+	}
+
+	f, err := os.Create(MachineDisk(def.ID))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return f.Truncate(int64(def.Disk))
+}
+
+// Any option is supported, nothing being run
+func (b *FakeBackend) ValidateKvmOpts(opts shared.KvmOptsDef) (error, int) {
+	return nil, 200
+}
+
+func (b *FakeBackend) SetLinuxOpts(id string, opts shared.LinuxOptsDef) error {
+	if b.IsRunning(id) {
+		return fmt.Errorf("Cannot set options while the machine is running")
+	}
+
+	return nil
+}
+
+// Start marks the machine as running and
+// assigns it a fake PID
+func (b *FakeBackend) Start(id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.running[id]; ok {
+		return fmt.Errorf("Machine already running")
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		return err
+	}
+
+	opts.PID = b.nextPid
+
+	err = DBMachineSetKvmOpts(id, opts)
+	if err != nil {
+		return err
+	}
+
+	b.running[id] = b.nextPid
+	b.nextPid++
+
+	return nil
+}
+
+// Stop marks the machine as stopped
+func (b *FakeBackend) Stop(id string, force bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.running[id]; !ok {
+		return fmt.Errorf("Machine already stopped")
+	}
+
+	delete(b.running, id)
+	delete(b.paused, id)
+	delete(b.balloon, id)
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		return err
+	}
+
+	opts.PID = 0
+
+	return DBMachineSetKvmOpts(id, opts)
+}
+
+func (b *FakeBackend) Pause(id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.running[id]; !ok {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	b.paused[id] = true
+	return nil
+}
+
+func (b *FakeBackend) Resume(id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.paused[id] {
+		return fmt.Errorf("Machine is not paused")
+	}
+
+	delete(b.paused, id)
+	return nil
+}
+
+func (b *FakeBackend) Reset(id string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.running[id]; !ok {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	// Like QEMU's system_reset, a paused machine stays paused
+	return nil
+}
+
+func (b *FakeBackend) IsRunning(id string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_, ok := b.running[id]
+	return ok
+}
+
+// Status reports a running machine as using
+// all of its memory and none of its CPU
+func (b *FakeBackend) Status(id string) (shared.MachineStatusDef, error) {
+	var def shared.MachineStatusDef
+	def.Running = b.IsRunning(id)
+
+	if def.Running {
+		b.mutex.Lock()
+		def.Paused = b.paused[id]
+		b.mutex.Unlock()
+
+		machine, err := DBMachineGet(id)
+		if err != nil {
+			return def, err
+		}
+
+		def.RamUsage = machine.Memory
+	}
+
+	disk, err := utils.FileSize(MachineDisk(id))
+	if err != nil {
+		return def, err
+	}
+
+	def.DiskUsage = disk
+
+	return def, nil
+}
+
+func (b *FakeBackend) CreateCheckpoint(id, name string) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	chks, err := b.readCheckpoints(id)
+	if err != nil {
+		return err
+	}
+
+	for i, chk := range chks {
+		if chk.Name == name {
+			chks = append(chks[:i], chks[i+1:]...)
+			break
+		}
+	}
+
+	chks = append(chks, shared.CheckpointDef{Name: name, Timestamp: time.Now().Unix()})
+
+	return b.writeCheckpoints(id, chks)
+}
+
+func (b *FakeBackend) ListCheckpoints(id string) ([]shared.CheckpointDef, error) {
+	return b.readCheckpoints(id)
+}
+
+func (b *FakeBackend) RestoreCheckpoint(id, name string) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine must be running to be restored")
+	}
+
+	chks, err := b.readCheckpoints(id)
+	if err != nil {
+		return err
+	}
+
+	for _, chk := range chks {
+		if chk.Name == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Checkpoint not found")
+}
+
+func (b *FakeBackend) DeleteCheckpoint(id, name string) error {
+	chks, err := b.readCheckpoints(id)
+	if err != nil {
+		return err
+	}
+
+	for i, chk := range chks {
+		if chk.Name == name {
+			return b.writeCheckpoints(id, append(chks[:i], chks[i+1:]...))
+		}
+	}
+
+	return fmt.Errorf("Checkpoint not found")
+}
+
+// Like KVM, volumes are only hot-plugged on the SCSI bus
+func (b *FakeBackend) AttachVolume(id, vol string) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return kvmVolumeHotplug(id)
+}
+
+func (b *FakeBackend) DetachVolume(id, vol string) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return kvmVolumeHotplug(id)
+}
+
+// Interfaces only exist in the database
+func (b *FakeBackend) AttachInterface(id string, n int, iface shared.InterfaceDef) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return nil
+}
+
+func (b *FakeBackend) DetachInterface(id string, n int) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return nil
+}
+
+func (b *FakeBackend) SetThrottle(id, vol string, def shared.ThrottleDef) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return nil
+}
+
+func (b *FakeBackend) SetBandwidth(id string, n int, def shared.BandwidthDef) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return nil
+}
+
+func (b *FakeBackend) HotAddCores(id string, cores int) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return nil
+}
+
+func (b *FakeBackend) HotAddMemory(id string, memory uint64) error {
+	if !b.IsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return nil
+}
+
+// The guest gets its memory target right away
+func (b *FakeBackend) Balloon(id string) (uint64, error) {
+	if !b.IsRunning(id) {
+		return 0, fmt.Errorf("Machine is not running")
+	}
+
+	b.mutex.Lock()
+	target, ok := b.balloon[id]
+	b.mutex.Unlock()
+
+	if ok {
+		return target, nil
+	}
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return machine.Memory, nil
+}
+
+func (b *FakeBackend) SetBalloon(id string, target uint64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.running[id]; !ok {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	b.balloon[id] = target
+	return nil
+}
+
+func (b *FakeBackend) GrowDisk(id string, size uint64) error {
+	if b.IsRunning(id) {
+		return fmt.Errorf("Machine is running")
+	}
+
+	return os.Truncate(MachineDisk(id), int64(size))
+}
+
+func (b *FakeBackend) Delete(id string) error {
+	if b.IsRunning(id) {
+		return fmt.Errorf("Machine is running")
+	}
+
+	return os.RemoveAll(MachinePath(id))
+}
+
+func (b *FakeBackend) Reconcile(fixed, problem ReportFunc) {
+}
+
+func (b *FakeBackend) ReconcileMachine(def shared.MachineDef, fixed, problem ReportFunc) {
+	if !utils.FileExists(MachineDisk(def.ID)) {
+		problem("Machine %s: disk %s is missing", def.ID, MachineDisk(def.ID))
+	}
+}
+
+// Networks only exist in the database
+func (b *FakeBackend) CreateNetwork(def shared.NetworkDef) error {
+	return nil
+}
+
+func (b *FakeBackend) DeleteNetwork(name string) error {
+	return nil
+}
@@ -2,16 +2,15 @@ package server
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/amoghe/go-crypt"
 	"github.com/quadrifoglio/go-qemu"
 	"github.com/quadrifoglio/go-qmp"
 
@@ -23,8 +22,158 @@ import (
 const (
 	GiB             = 1073741824
 	DefaultDiskSize = 25 * GiB
+	KvmStderrLines  = 20 // Number of lines of QEMU error output kept after its exit
+
+	KvmUnplugTimeout = 10 * time.Second // Time given to the guest to release an unplugged device
+	KvmBalloonDevice = "balloon0"       // ID of the memory balloon device
+	KvmDiskDevice    = "disk0"          // ID of the main disk device
+)
+
+var (
+	kvmWatchMutex sync.Mutex
+	kvmWatchers   = make(map[string]chan struct{}) // Closed once the exit of the QEMU process of the machine is recorded
 )
 
+// KvmBackend is the Backend implementation
+// using QEMU/KVM as the hypervisor
+type KvmBackend struct{}
+
+func (KvmBackend) Create(def *shared.MachineDef) error {
+	return MachineKvmCreate(def)
+}
+
+func (KvmBackend) ValidateKvmOpts(opts shared.KvmOptsDef) (error, int) {
+	return MachineKvmValidateOpts(opts)
+}
+
+func (KvmBackend) SetLinuxOpts(id string, opts shared.LinuxOptsDef) error {
+	return MachineKvmSetLinuxOpts(id, opts)
+}
+
+func (KvmBackend) Start(id string) error {
+	return MachineKvmStart(id)
+}
+
+func (KvmBackend) Stop(id string, force bool) error {
+	return MachineKvmStop(id, force)
+}
+
+func (KvmBackend) Pause(id string) error {
+	return MachineKvmPause(id)
+}
+
+func (KvmBackend) Resume(id string) error {
+	return MachineKvmResume(id)
+}
+
+func (KvmBackend) Reset(id string) error {
+	return MachineKvmReset(id)
+}
+
+func (KvmBackend) IsRunning(id string) bool {
+	return MachineKvmIsRunning(id)
+}
+
+func (KvmBackend) Status(id string) (shared.MachineStatusDef, error) {
+	return MachineKvmStatus(id)
+}
+
+func (KvmBackend) CreateCheckpoint(id, name string) error {
+	return MachineKvmCreateCheckpoint(id, name)
+}
+
+func (KvmBackend) ListCheckpoints(id string) ([]shared.CheckpointDef, error) {
+	return MachineKvmListCheckpoints(id)
+}
+
+func (KvmBackend) RestoreCheckpoint(id, name string) error {
+	return MachineKvmRestoreCheckpoint(id, name)
+}
+
+func (KvmBackend) DeleteCheckpoint(id, name string) error {
+	return MachineKvmDeleteCheckpoint(id, name)
+}
+
+func (KvmBackend) AttachVolume(id, vol string) error {
+	return MachineKvmAttachVolume(id, vol)
+}
+
+func (KvmBackend) DetachVolume(id, vol string) error {
+	return MachineKvmDetachVolume(id, vol)
+}
+
+func (KvmBackend) AttachInterface(id string, n int, iface shared.InterfaceDef) error {
+	return MachineKvmAttachInterface(id, n, iface)
+}
+
+func (KvmBackend) DetachInterface(id string, n int) error {
+	return MachineKvmDetachInterface(id, n)
+}
+
+func (KvmBackend) SetThrottle(id, vol string, def shared.ThrottleDef) error {
+	return MachineKvmSetThrottle(id, vol, def)
+}
+
+func (KvmBackend) SetBandwidth(id string, n int, def shared.BandwidthDef) error {
+	return SetInterfaceBandwidth(id, n, def)
+}
+
+func (KvmBackend) HotAddCores(id string, cores int) error {
+	return MachineKvmHotAddCores(id, cores)
+}
+
+func (KvmBackend) HotAddMemory(id string, memory uint64) error {
+	return MachineKvmHotAddMemory(id, memory)
+}
+
+func (KvmBackend) Balloon(id string) (uint64, error) {
+	return MachineKvmGetBalloon(id)
+}
+
+func (KvmBackend) SetBalloon(id string, target uint64) error {
+	return MachineKvmSetBalloon(id, target)
+}
+
+func (KvmBackend) GrowDisk(id string, size uint64) error {
+	return system.ResizeQcow2(MachineDisk(id), size)
+}
+
+func (KvmBackend) Delete(id string) error {
+	return MachineKvmDelete(id)
+}
+
+func (KvmBackend) Reconcile(fixed, problem ReportFunc) {
+	BridgeReconcile(fixed, problem)
+	reconcileKvmCgroups(fixed, problem)
+}
+
+func (KvmBackend) ReconcileMachine(def shared.MachineDef, fixed, problem ReportFunc) {
+	reconcileKvmMachine(def, fixed, problem)
+}
+
+func (KvmBackend) CreateNetwork(def shared.NetworkDef) error {
+	return BridgeCreateNetwork(def)
+}
+
+func (KvmBackend) DeleteNetwork(name string) error {
+	return BridgeDeleteNetwork(name)
+}
+
+// MachineKvmValidateOpts checks that the host can run a KVM
+// machine with the specified options (CPU, firmware, limits)
+// and returns the coresponding http status code
+func MachineKvmValidateOpts(opts shared.KvmOptsDef) (error, int) {
+	if err, status := kvmCpuHostCheck(opts); err != nil {
+		return err, status
+	}
+
+	if err, status := kvmBootHostCheck(opts); err != nil {
+		return err, status
+	}
+
+	return kvmLimitsHostCheck(opts)
+}
+
 // MachineKvmIsRunning checks if the speicifed machine
 // is currently running
 func MachineKvmIsRunning(id string) bool {
@@ -169,12 +318,7 @@ func MachineKvmSetLinuxHostname(id, hostname string) error {
 
 	defer system.Unmount(path)
 
-	err = utils.ReplaceFileContents(fmt.Sprintf("%s/etc/hostname", path), []byte(hostname))
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return LinuxSetHostname(path, hostname)
 }
 
 // MachineKvmSetLinuxRootPassword sets the root password for
@@ -188,7 +332,6 @@ func MachineKvmSetLinuxRootPassword(id string, passwd string) error {
 	defer system.NBDDisconnectQcow2()
 
 	path := fmt.Sprintf("/tmp/wir/%s", id)
-	shadowPath := fmt.Sprintf("%s/etc/shadow", path)
 
 	if !utils.FileExists(path) {
 		err := os.MkdirAll(path, 0755)
@@ -218,41 +361,24 @@ func MachineKvmSetLinuxRootPassword(id string, passwd string) error {
 
 	defer system.Unmount(path)
 
-	data, err := ioutil.ReadFile(shadowPath)
-	if err != nil {
-		return err
-	}
-
-	salt := utils.RandID()
-	str, err := crypt.Crypt(passwd, fmt.Sprintf("$6$%s$", salt[:8]))
-	if err != nil {
-		return err
-	}
-
-	regex := regexp.MustCompile("^root:[^:]+:")
-	dataStr := regex.ReplaceAllLiteralString(string(data), fmt.Sprintf("root:%s:", str))
-
-	err = utils.ReplaceFileContents(shadowPath, []byte(dataStr))
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return LinuxSetRootPassword(path, passwd)
 }
 
-func MachineKvmSetOpts(id string, opts shared.KvmOptsDef) error {
+// MachineKvmSetLinuxOpts applies the Linux
+// options to the disk of the machine
+func MachineKvmSetLinuxOpts(id string, opts shared.LinuxOptsDef) error {
 	if MachineKvmIsRunning(id) {
 		return fmt.Errorf("Cannot set KVM options while the machine is running")
 	}
 
-	if len(opts.Linux.Hostname) > 0 {
-		err := MachineKvmSetLinuxHostname(id, opts.Linux.Hostname)
+	if len(opts.Hostname) > 0 {
+		err := MachineKvmSetLinuxHostname(id, opts.Hostname)
 		if err != nil {
 			return err
 		}
 	}
-	if len(opts.Linux.RootPassword) > 0 {
-		err := MachineKvmSetLinuxRootPassword(id, opts.Linux.RootPassword)
+	if len(opts.RootPassword) > 0 {
+		err := MachineKvmSetLinuxRootPassword(id, opts.RootPassword)
 		if err != nil {
 			return err
 		}
@@ -261,6 +387,208 @@ func MachineKvmSetOpts(id string, opts shared.KvmOptsDef) error {
 	return nil
 }
 
+// MachineKvmArgs returns the command line arguments
+// of the QEMU process of the specified machine
+func MachineKvmArgs(def shared.MachineDef, opts shared.KvmOptsDef) []string {
+	args := MachineKvmCpuArgs(def, opts)
+	args = append(args, MachineKvmMemoryArgs(def, opts)...)
+	args = append(args, "-enable-kvm")
+
+	args = append(args, MachineKvmFirmwareArgs(def.ID, opts)...)
+
+	diskBoot, cdromBoot, nicsBoot := kvmBootIndexes(def, opts)
+
+	if len(opts.CDRom) > 0 {
+		args = append(args, "-drive", fmt.Sprintf("file=%s,media=cdrom,if=none,id=drive-cdrom0", opts.CDRom))
+		args = append(args, "-device", fmt.Sprintf("ide-cd,drive=drive-cdrom0,id=cdrom0%s", kvmBootIndex(cdromBoot)))
+	}
+
+	args = append(args, "-drive", fmt.Sprintf("file=%s,format=qcow2,if=none,id=drive-%s%s", MachineDisk(def.ID), KvmDiskDevice, kvmDriveThrottleOpts(def.DiskThrottle)))
+	args = append(args, "-device", fmt.Sprintf("ide-hd,drive=drive-%s,id=%s%s", KvmDiskDevice, KvmDiskDevice, kvmBootIndex(diskBoot)))
+
+	// On the SCSI controller, volumes can be hot-plugged. They stay on the
+	// IDE bus by default, where guests without virtio drivers can see them
+	if opts.VolumeBus == shared.VolumeBusSCSI {
+		args = append(args, "-device", "virtio-scsi-pci,id=scsi0")
+
+		for _, v := range def.Volumes {
+			args = append(args, "-blockdev", fmt.Sprintf("driver=qcow2,node-name=%s,file.driver=file,file.filename=%s", kvmVolumeNode(v), VolumeFile(v)))
+			args = append(args, "-device", fmt.Sprintf("scsi-hd,bus=scsi0.0,drive=%s,id=%s", kvmVolumeNode(v), kvmVolumeDevice(v)))
+		}
+	} else {
+		for _, v := range def.Volumes {
+			args = append(args, "-drive", fmt.Sprintf("file=%s,format=qcow2,id=%s", VolumeFile(v), kvmVolumeNode(v)))
+		}
+	}
+
+	if len(def.Interfaces) == 0 {
+		args = append(args, "-net", "none")
+	}
+
+	for i, iface := range def.Interfaces {
+		args = append(args, "-netdev", fmt.Sprintf("tap,id=net%d,ifname=%s", i, MachineNicName(def.ID, i)))
+		args = append(args, "-device", fmt.Sprintf("virtio-net,netdev=net%d,mac=%s,id=%s%s", i, iface.MAC, kvmNicDevice(i), kvmBootIndex(nicsBoot[i])))
+	}
+
+	if len(opts.Video) > 0 {
+		args = append(args, "-vga", opts.Video)
+	}
+
+	if opts.Display == shared.DisplaySpice {
+		args = append(args, MachineSpiceArgs(def.ID, opts)...)
+	} else if opts.VNC.Enabled {
+		var vnc string
+
+		if len(opts.VNC.Address) == 0 {
+			// Proxied by the daemon (/machines/<id>/vnc)
+			vnc = fmt.Sprintf("unix:%s", MachineVncPath(def.ID))
+		} else {
+			vnc = fmt.Sprintf("%s:%d", opts.VNC.Address, opts.VNC.Port)
+			if opts.VNC.WebsocketPort > 0 {
+				vnc = fmt.Sprintf("%s,websocket=%d", vnc, opts.VNC.WebsocketPort)
+			}
+		}
+
+		if opts.VNC.TLS {
+			verify := "no"
+			if opts.VNC.VerifyClient {
+				verify = "yes"
+			}
+
+			args = append(args, "-object", fmt.Sprintf("tls-creds-x509,id=vnctls0,dir=%s,endpoint=server,verify-peer=%s", MachineVncTlsPath(def.ID), verify))
+			vnc = fmt.Sprintf("%s,tls-creds=vnctls0", vnc)
+
+			// The CA of the daemon signs the client certificates of every
+			// machine: only accept the ones issued for this machine
+			if opts.VNC.VerifyClient {
+				args = append(args, "-object", fmt.Sprintf("authz-simple,id=vncauthz0,identity=CN=%s", MachineVncClientName(def.ID)))
+				vnc = fmt.Sprintf("%s,tls-authz=vncauthz0", vnc)
+			}
+		}
+		if len(opts.VNC.Password) > 0 {
+			vnc = fmt.Sprintf("%s,password", vnc)
+		}
+
+		args = append(args, "-vnc", vnc)
+	} else {
+		args = append(args, "-display", "none")
+	}
+
+	args = append(args, "-qmp", fmt.Sprintf("unix:%s,server,nowait", MachineMonitorPath(def.ID)))
+	args = append(args, "-chardev", fmt.Sprintf("socket,id=serial0,path=%s,server,nowait", MachineSerialPath(def.ID)))
+	args = append(args, "-serial", "chardev:serial0")
+	args = append(args, "-device", fmt.Sprintf("virtio-balloon-pci,id=%s", KvmBalloonDevice))
+	args = append(args, "-usbdevice", "tablet")
+	args = append(args, "-rtc", "driftfix=slew,base=localtime")
+
+	if opts.Display == shared.DisplaySpice && opts.Spice.Agent {
+		args = append(args, MachineSpiceAgentArgs()...)
+	}
+
+	return args
+}
+
+// MachineKvmWatch waits for the QEMU process of the machine to exit
+// and records its termination, then closes done. If the exit was not
+// requested through the API, the machine is marked as crashed (or
+// stopped if the guest powered itself off)
+func MachineKvmWatch(id string, proc *system.Process, done chan struct{}) {
+	defer func() {
+		kvmWatchMutex.Lock()
+		if kvmWatchers[id] == done {
+			delete(kvmWatchers, id)
+		}
+		kvmWatchMutex.Unlock()
+
+		close(done)
+	}()
+
+	<-proc.Done
+
+	err := MachineKvmCgroupCleanup(id)
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to remove cgroup: %s\n", id, err)
+	}
+
+	state, _, err := DBMachineGetState(id)
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to get state: %s\n", id, err)
+		return
+	}
+
+	exit := shared.MachineExitDef{
+		Timestamp: proc.ExitTime.Unix(),
+		Code:      proc.ExitCode,
+		Signal:    proc.Signal,
+		Expected:  state != shared.StateRunning && state != shared.StatePaused && state != shared.StateCrashed,
+		Stderr:    proc.Stderr(),
+	}
+
+	err = DBMachineSetExit(id, exit)
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to save exit status: %s\n", id, err)
+	}
+
+	err = DBMachineAddEvent(id, "exit", fmt.Sprintf("QEMU process %d: %s", proc.Pid, proc.ExitReason()))
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to save event: %s\n", id, err)
+	}
+
+	if exit.Expected {
+		return
+	}
+
+	log.Printf("Machine %s - QEMU process exited unexpectedly: %s\n", id, proc.ExitReason())
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to get KVM options: %s\n", id, err)
+		return
+	}
+
+	// A newer QEMU process already owns the machine
+	if opts.PID != proc.Pid {
+		return
+	}
+
+	opts.PID = 0
+
+	err = DBMachineSetKvmOpts(id, opts)
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to clear PID: %s\n", id, err)
+	}
+
+	if exit.Code == 0 {
+		err = DBMachineSetState(id, shared.StateStopped, "The guest powered off")
+	} else {
+		err = DBMachineSetState(id, shared.StateCrashed, fmt.Sprintf("QEMU exited unexpectedly (%s)", proc.ExitReason()))
+	}
+
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to save state: %s\n", id, err)
+	}
+}
+
+// kvmWatchWait waits for the exit of the previous QEMU process of the
+// specified machine to be recorded, for at most the specified duration,
+// and returns true if it was
+func kvmWatchWait(id string, timeout time.Duration) bool {
+	kvmWatchMutex.Lock()
+	done, ok := kvmWatchers[id]
+	kvmWatchMutex.Unlock()
+
+	if !ok {
+		return true
+	}
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 // MachineKvmStart starts the mahine based on the machine ID
 // and returns the PID of the hypervisor's process
 func MachineKvmStart(id string) error {
@@ -268,6 +596,12 @@ func MachineKvmStart(id string) error {
 		return fmt.Errorf("Machine already running")
 	}
 
+	// The previous process (e.g. on reboot) must be gone, and its exit recorded
+	// before the machine's state changes again, so that it is not taken for a crash
+	if !kvmWatchWait(id, 10*time.Second) {
+		return fmt.Errorf("The previous QEMU process of the machine is still exiting")
+	}
+
 	def, err := DBMachineGet(id)
 	if err != nil {
 		return err
@@ -278,85 +612,122 @@ func MachineKvmStart(id string) error {
 		return err
 	}
 
-	disk, err := qemu.OpenImage(MachineDisk(def.ID))
+	if opts.Boot.Firmware == shared.FirmwareUEFI {
+		err := MachineKvmNvramSetup(def.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Display == shared.DisplaySpice && opts.Spice.TLSPort > 0 {
+		err := MachineSpiceTlsSetup(def.ID, opts)
+		if err != nil {
+			return err
+		}
+	} else if opts.Display != shared.DisplaySpice && opts.VNC.Enabled && opts.VNC.TLS {
+		err := MachineVncTlsSetup(def.ID, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Started paused (-S), until it is moved into the slice of the machine
+	args := append(MachineKvmArgs(def, opts), "-S")
+
+	proc, err := system.StartProcess("qemu-system-x86_64", args, KvmStderrLines, func(s string) {
+		log.Printf("machine %s stderr: %s\n", def.ID, s)
+	})
+
 	if err != nil {
 		return err
 	}
 
-	m := qemu.NewMachine(def.Cores, def.Memory)
-	m.AddDriveImage(disk)
+	done := make(chan struct{})
 
-	if len(opts.CDRom) > 0 {
-		m.AddCDRom(opts.CDRom)
+	kvmWatchMutex.Lock()
+	kvmWatchers[def.ID] = done
+	kvmWatchMutex.Unlock()
+
+	go MachineKvmWatch(def.ID, proc, done)
+
+	err = MachineKvmCgroupSetup(def.ID, proc.Pid, opts)
+	if err != nil {
+		syscall.Kill(proc.Pid, syscall.SIGKILL)
+
+		return fmt.Errorf("Failed to apply resource limits: %s", err)
 	}
 
-	for _, v := range def.Volumes {
-		m.AddDrive(qemu.Drive{VolumeFile(v), qemu.ImageFormatQCOW2})
+	err = MachineConsoleAttach(def.ID)
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to attach serial console: %s\n", def.ID, err)
 	}
 
+	// Wait 1 second, just to make sure that the interfaces have been created by QEMU
+	time.Sleep(1 * time.Second)
 	for i, iface := range def.Interfaces {
-		netdev, err := qemu.NewNetworkDevice("tap", fmt.Sprintf("net%d", i))
+		err := AttachInterfaceToNetwork(id, i, iface)
 		if err != nil {
+			syscall.Kill(proc.Pid, syscall.SIGKILL)
+
 			return err
 		}
-
-		netdev.SetMacAddress(iface.MAC)
-		netdev.SetHostInterfaceName(MachineNicName(id, i))
-
-		m.AddNetworkDevice(netdev)
 	}
 
-	if opts.VNC.Enabled {
-		m.AddVNC(opts.VNC.Address, opts.VNC.Port, opts.VNC.WebsocketPort, true)
+	c, err := qmp.Open("unix", MachineMonitorPath(def.ID))
+	if err != nil {
+		syscall.Kill(proc.Pid, syscall.SIGKILL)
+
+		return fmt.Errorf("Failed to connect to the monitor: %s", err)
 	}
 
-	m.AddMonitorUnix(MachineMonitorPath(def.ID))
-	m.AddOption("-balloon", "virtio")
-	m.AddOption("-usbdevice", "tablet")
-	m.AddOption("-boot", "order=dc")
-	m.AddOption("-rtc", "driftfix=slew,base=localtime")
+	defer c.Close()
 
-	// x86_64 arch (using qemu-system-x86_64), with kvm
-	proc, err := m.Start("x86_64", true, func(s string) {
-		log.Printf("machine %s stderr: %s\n", def.ID, utils.OneLine([]byte(s)))
+	_, err = c.Command("qom-set", map[string]interface{}{
+		"path":     "/machine/peripheral/" + KvmBalloonDevice,
+		"property": "guest-stats-polling-interval",
+		"value":    3,
 	})
 
 	if err != nil {
-		return err
+		log.Printf("Not fatal - Machine %s - Set balloon stats polling interval: %s\n", def.ID, err)
 	}
 
-	// Wait 1 second, just to make sure that the interfaces have been created by QEMU
-	time.Sleep(1 * time.Second)
-	for i, iface := range def.Interfaces {
-		err := AttachInterfaceToNetwork(id, i, iface)
+	if len(opts.Limits.CpuSet) > 0 {
+		res, err := c.Command("query-cpus-fast", nil)
+		if err == nil {
+			err = kvmPinVcpus(opts.Limits.CpuSet, res)
+		}
+
 		if err != nil {
-			proc.Kill()
+			syscall.Kill(proc.Pid, syscall.SIGKILL)
 
-			return err
+			return fmt.Errorf("Failed to pin the vCPU threads: %s", err)
 		}
 	}
 
-	opts.PID = proc.Pid
+	// The I/O limits of the volumes are only set through QMP
+	for _, v := range def.Volumes {
+		vol, err := DBVolumeGet(v)
+		if err != nil || !throttled(vol.Throttle) {
+			continue
+		}
 
-	err = DBMachineSetKvmOpts(def.ID, opts)
-	if err != nil {
-		return err
+		_, err = c.Command("block_set_io_throttle", kvmVolumeThrottleArgs(opts, v, vol.Throttle))
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Set I/O limits of volume %s: %s\n", def.ID, v, err)
+		}
 	}
 
-	c, err := qmp.Open("unix", MachineMonitorPath(def.ID))
-	if err == nil {
-		defer c.Close()
-
-		_, err := c.Command("qom-set", map[string]interface{}{
-			"path":     "/machine/peripheral-anon/device[1]",
-			"property": "guest-stats-polling-interval",
-			"value":    3,
+	if opts.Display == shared.DisplaySpice && len(opts.Spice.Password) > 0 {
+		_, err = c.Command("set_password", map[string]interface{}{
+			"protocol": "spice",
+			"password": opts.Spice.Password,
 		})
 
 		if err != nil {
-			log.Printf("Not fatal - Machine %s - Set balloon stats polling interval: %s\n", def.ID, err)
+			log.Printf("Not fatal - Machine %s - Set SPICE password: %s\n", def.ID, err)
 		}
-
+	} else if opts.VNC.Enabled && len(opts.VNC.Password) > 0 {
 		_, err = c.Command("change", map[string]interface{}{
 			"device": "vnc",
 			"target": "password",
@@ -366,42 +737,175 @@ func MachineKvmStart(id string) error {
 		if err != nil {
 			log.Printf("Not fatal - Machine %s - Set VNC password: %s\n", def.ID, err)
 		}
-	}
 
-	return nil
-}
+		if opts.VNC.PasswordExpiry > 0 {
+			_, err = c.Command("expire_password", map[string]interface{}{
+				"protocol": "vnc",
+				"time":     fmt.Sprintf("+%d", opts.VNC.PasswordExpiry),
+			})
 
-// MachineKvmStop stops the machine by finding its
-// PID an sending it a SIGTERM signal
-func MachineKvmStop(id string) error {
-	opts, err := DBMachineGetKvmOpts(id)
+			if err != nil {
+				log.Printf("Not fatal - Machine %s - Set VNC password expiry: %s\n", def.ID, err)
+			}
+		}
+	}
+
+	// The guest only runs once QEMU is in the slice of the machine and its vCPUs are pinned
+	_, err = c.Command("cont", nil)
 	if err != nil {
+		syscall.Kill(proc.Pid, syscall.SIGKILL)
+
 		return err
 	}
 
-	if opts.PID == 0 {
-		return fmt.Errorf("Machine already stopped")
-	}
+	// Only a machine that started is given a PID: a failed start must not
+	// leave the PID of its dead process behind, which may be reused later
+	opts.PID = proc.Pid
 
-	proc, err := os.FindProcess(opts.PID)
+	err = DBMachineSetKvmOpts(def.ID, opts)
 	if err != nil {
+		syscall.Kill(proc.Pid, syscall.SIGKILL)
+
 		return err
 	}
 
-	opts.PID = 0
+	return nil
+}
 
-	err = DBMachineSetKvmOpts(id, opts)
+// MachineKvmStop stops the machine by asking the guest to power down
+// via ACPI, falling back to SIGTERM then SIGKILL if it does not
+// exit in time. If force is true, the process is killed right away
+func MachineKvmStop(id string, force bool) error {
+	opts, err := DBMachineGetKvmOpts(id)
 	if err != nil {
 		return err
 	}
 
-	err = proc.Kill()
+	if opts.PID == 0 {
+		return fmt.Errorf("Machine already stopped")
+	}
+
+	proc, err := os.FindProcess(opts.PID)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	stopped := false
+
+	if !force {
+		err := kvmPowerdown(id)
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - ACPI power down: %s\n", id, err)
+		} else {
+			stopped = system.WaitProcessExit(opts.PID, GlobalStopTimeout)
+		}
+
+		if !stopped {
+			err := proc.Signal(syscall.SIGTERM)
+			if err != nil {
+				return err
+			}
+
+			stopped = system.WaitProcessExit(opts.PID, 5*time.Second)
+		}
+	}
+
+	if !stopped {
+		err := proc.Kill()
+		if err != nil {
+			return err
+		}
+
+		// QEMU holds the disk, the interfaces and the sockets until it is gone
+		if !system.WaitProcessExit(opts.PID, 5*time.Second) {
+			return fmt.Errorf("QEMU process %d did not exit after being killed", opts.PID)
+		}
+	}
+
+	opts.PID = 0
+
+	err = DBMachineSetKvmOpts(id, opts)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// kvmPowerdown asks the guest of the specified machine to power down
+// via ACPI, resuming it first if it is paused: a paused guest would
+// never process the request
+func kvmPowerdown(id string) error {
+	paused, err := MachineKvmIsPaused(id)
+	if err != nil {
+		return err
+	}
+
+	if paused {
+		err := MachineKvmResume(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = MachineKvmCommand(id, "system_powerdown", nil)
+	return err
+}
+
+// MachineKvmCommand sends a QMP command to the specified
+// running machine and returns the result
+func MachineKvmCommand(id, command string, args map[string]interface{}) (qmp.JsonValue, error) {
+	if !MachineKvmIsRunning(id) {
+		return nil, fmt.Errorf("Machine is not running")
+	}
+
+	c, err := qmp.Open("unix", MachineMonitorPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	return c.Command(command, args)
+}
+
+// MachineKvmIsPaused checks if the execution of
+// the specified running machine is suspended
+func MachineKvmIsPaused(id string) (bool, error) {
+	res, err := MachineKvmCommand(id, "query-status", nil)
+	if err != nil {
+		return false, err
+	}
+
+	if rr, ok := res.(map[string]interface{}); ok {
+		if status, ok := rr["status"].(string); ok {
+			return status == "paused", nil
+		}
+	}
+
+	return false, fmt.Errorf("Invalid output from query-status")
+}
+
+// MachineKvmPause suspends the execution
+// of the specified machine
+func MachineKvmPause(id string) error {
+	_, err := MachineKvmCommand(id, "stop", nil)
+	return err
+}
+
+// MachineKvmResume resumes the execution
+// of the specified paused machine
+func MachineKvmResume(id string) error {
+	_, err := MachineKvmCommand(id, "cont", nil)
+	return err
+}
+
+// MachineKvmReset hard resets
+// the specified machine
+func MachineKvmReset(id string) error {
+	_, err := MachineKvmCommand(id, "system_reset", nil)
+	return err
+}
 
 // MachineKvmGetBallonFreeMem retreives the guest's free memory
 // in MiB from the virtio-balloon driver via QMP
@@ -414,7 +918,7 @@ func MachineKvmGetBallonFreeMem(id string) (uint64, error) {
 	defer c.Close()
 
 	res, err := c.Command("qom-get", map[string]interface{}{
-		"path":     "/machine/peripheral-anon/device[1]",
+		"path":     "/machine/peripheral/" + KvmBalloonDevice,
 		"property": "guest-stats",
 	})
 
@@ -437,6 +941,33 @@ func MachineKvmGetBallonFreeMem(id string) (uint64, error) {
 	return 0, fmt.Errorf("Invalid output from qom-get")
 }
 
+// MachineKvmGetBalloon retreives the memory in MiB
+// currently left to the guest by the balloon via QMP
+func MachineKvmGetBalloon(id string) (uint64, error) {
+	res, err := MachineKvmCommand(id, "query-balloon", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if rr, ok := res.(map[string]interface{}); ok {
+		if actual, ok := rr["actual"].(float64); ok && actual >= 0 {
+			return uint64(actual / float64(1048576.0)), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid output from query-balloon")
+}
+
+// MachineKvmSetBalloon asks the guest to inflate or deflate its balloon
+// so that the specified amount of memory (MiB) is left to it
+func MachineKvmSetBalloon(id string, target uint64) error {
+	_, err := MachineKvmCommand(id, "balloon", map[string]interface{}{
+		"value": target * 1048576,
+	})
+
+	return err
+}
+
 // MachineKvmStatus returns a MachineStatusDef
 // representing the current status of the machine
 func MachineKvmStatus(id string) (shared.MachineStatusDef, error) {
@@ -444,6 +975,13 @@ func MachineKvmStatus(id string) (shared.MachineStatusDef, error) {
 	def.Running = MachineKvmIsRunning(id)
 
 	if def.Running {
+		paused, err := MachineKvmIsPaused(id)
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to get paused status: %s\n", id, err)
+		}
+
+		def.Paused = paused
+
 		opts, err := DBMachineGetKvmOpts(id)
 		if err != nil {
 			return def, err
@@ -471,6 +1009,16 @@ func MachineKvmStatus(id string) (shared.MachineStatusDef, error) {
 
 		def.CpuUsage = cpu
 		def.RamUsage = machine.Memory - ram
+
+		def.Cgroup, err = MachineKvmCgroup(id, opts.PID)
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to get cgroup: %s\n", id, err)
+		}
+
+		def.Layout, err = MachineKvmLayout(id)
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to get vCPUs and memory layout: %s\n", id, err)
+		}
 	}
 
 	disk, err := utils.FileSize(MachineDisk(id))
@@ -522,7 +1070,7 @@ func MachineKvmListCheckpoints(id string) ([]shared.CheckpointDef, error) {
 
 	for _, snap := range snaps {
 		if strings.HasPrefix(snap.Name, "checkpoint_") {
-			chks = append(chks, shared.CheckpointDef{snap.Name[11:], snap.Date.Unix()})
+			chks = append(chks, shared.CheckpointDef{Name: snap.Name[11:], Timestamp: snap.Date.Unix()})
 		}
 	}
 
@@ -572,8 +1120,484 @@ func MachineKvmDelete(id string) error {
 		return fmt.Errorf("Machine is running")
 	}
 
-	err := os.RemoveAll(MachinePath(id))
+	def, err := DBMachineGet(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(MachinePath(id))
+	if err != nil {
+		return err
+	}
+
+	BridgeDeleteInterfaces(def)
+	return nil
+}
+
+// kvmVolumeNode returns the name of the QEMU
+// block node of the specified volume
+func kvmVolumeNode(vol string) string {
+	return fmt.Sprintf("drive-vol-%s", vol)
+}
+
+// kvmVolumeDevice returns the ID of the QEMU
+// device of the specified volume
+func kvmVolumeDevice(vol string) string {
+	return fmt.Sprintf("vol-%s", vol)
+}
+
+// MachineKvmHasDevice checks if the device with the
+// specified ID is plugged into the running machine
+func MachineKvmHasDevice(id, dev string) (bool, error) {
+	res, err := MachineKvmCommand(id, "qom-list", map[string]interface{}{
+		"path": "/machine/peripheral",
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	props, ok := res.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("Invalid output from qom-list")
+	}
+
+	for _, p := range props {
+		if prop, ok := p.(map[string]interface{}); ok && prop["name"] == dev {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// MachineKvmUnplugDevice removes a device from the running machine
+// and waits for the guest to release it
+func MachineKvmUnplugDevice(id, dev string) error {
+	_, err := MachineKvmCommand(id, "device_del", map[string]interface{}{
+		"id": dev,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(KvmUnplugTimeout)
+
+	for time.Now().Before(deadline) {
+		plugged, err := MachineKvmHasDevice(id, dev)
+		if err != nil {
+			return err
+		}
+
+		if !plugged {
+			return nil
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	return fmt.Errorf("The guest did not release the device within %s", KvmUnplugTimeout)
+}
+
+// kvmVolumeThrottleArgs returns the arguments of 'block_set_io_throttle'
+// for the specified volume of a machine. The volumes on the IDE bus have
+// no device ID, they are designated by the name of their drive
+func kvmVolumeThrottleArgs(opts shared.KvmOptsDef, vol string, def shared.ThrottleDef) map[string]interface{} {
+	if opts.VolumeBus == shared.VolumeBusSCSI {
+		return kvmThrottleArgs(kvmVolumeDevice(vol), def)
+	}
+
+	args := kvmThrottleArgs("", def)
+	delete(args, "id")
+	args["device"] = kvmVolumeNode(vol)
+
+	return args
+}
+
+// kvmVolumeHotplug checks that volumes can be plugged into
+// and unplugged from the specified running machine
+func kvmVolumeHotplug(id string) error {
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		return err
+	}
+
+	if opts.VolumeBus != shared.VolumeBusSCSI {
+		return fmt.Errorf("Volumes can only be attached to or detached from a running machine on the 'scsi' volume bus (KVM options)")
+	}
+
+	return nil
+}
+
+// MachineKvmAttachVolume plugs the specified
+// volume into the running machine
+func MachineKvmAttachVolume(id, vol string) error {
+	err := kvmVolumeHotplug(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = MachineKvmCommand(id, "blockdev-add", map[string]interface{}{
+		"driver":    "qcow2",
+		"node-name": kvmVolumeNode(vol),
+		"file": map[string]interface{}{
+			"driver":   "file",
+			"filename": VolumeFile(vol),
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = MachineKvmCommand(id, "device_add", map[string]interface{}{
+		"driver": "scsi-hd",
+		"bus":    "scsi0.0",
+		"drive":  kvmVolumeNode(vol),
+		"id":     kvmVolumeDevice(vol),
+	})
+
+	if err != nil {
+		MachineKvmCommand(id, "blockdev-del", map[string]interface{}{"node-name": kvmVolumeNode(vol)})
+		return err
+	}
+
+	def, err := DBVolumeGet(vol)
+	if err != nil {
+		return err
+	}
+
+	if throttled(def.Throttle) {
+		return MachineKvmSetThrottle(id, vol, def.Throttle)
+	}
+
+	return nil
+}
+
+// MachineKvmSetThrottle changes the I/O limits of the disk of
+// the machine (vol empty) or of the specified volume via QMP
+func MachineKvmSetThrottle(id, vol string, def shared.ThrottleDef) error {
+	args := kvmThrottleArgs(KvmDiskDevice, def)
+
+	if len(vol) > 0 {
+		opts, err := DBMachineGetKvmOpts(id)
+		if err != nil {
+			return err
+		}
+
+		args = kvmVolumeThrottleArgs(opts, vol, def)
+	}
+
+	_, err := MachineKvmCommand(id, "block_set_io_throttle", args)
+	return err
+}
+
+// MachineKvmDetachVolume unplugs the specified
+// volume from the running machine
+func MachineKvmDetachVolume(id, vol string) error {
+	err := kvmVolumeHotplug(id)
+	if err != nil {
+		return err
+	}
+
+	err = MachineKvmUnplugDevice(id, kvmVolumeDevice(vol))
+	if err != nil {
+		return err
+	}
+
+	_, err = MachineKvmCommand(id, "blockdev-del", map[string]interface{}{
+		"node-name": kvmVolumeNode(vol),
+	})
+
+	return err
+}
+
+// kvmNicDevice returns the ID of the QEMU device
+// of the n-th network interface of a machine
+func kvmNicDevice(n int) string {
+	return fmt.Sprintf("nic%d", n)
+}
+
+// MachineKvmAttachInterface plugs a new network interface into the
+// running machine, as its n-th interface, and attaches it to its network
+func MachineKvmAttachInterface(id string, n int, iface shared.InterfaceDef) error {
+	_, err := MachineKvmCommand(id, "netdev_add", map[string]interface{}{
+		"type":       "tap",
+		"id":         fmt.Sprintf("net%d", n),
+		"ifname":     MachineNicName(id, n),
+		"script":     "no",
+		"downscript": "no",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = MachineKvmCommand(id, "device_add", map[string]interface{}{
+		"driver": "virtio-net",
+		"netdev": fmt.Sprintf("net%d", n),
+		"mac":    iface.MAC,
+		"id":     kvmNicDevice(n),
+	})
+
+	if err != nil {
+		MachineKvmCommand(id, "netdev_del", map[string]interface{}{"id": fmt.Sprintf("net%d", n)})
+		return err
+	}
+
+	err = AttachInterfaceToNetwork(id, n, iface)
+	if err != nil {
+		MachineKvmDetachInterface(id, n)
+		return err
+	}
+
+	return nil
+}
+
+// MachineKvmDetachInterface unplugs the n-th network interface from
+// the running machine and removes its traces from the host
+func MachineKvmDetachInterface(id string, n int) error {
+	err := MachineKvmUnplugDevice(id, kvmNicDevice(n))
+	if err != nil {
+		return err
+	}
+
+	_, err = MachineKvmCommand(id, "netdev_del", map[string]interface{}{
+		"id": fmt.Sprintf("net%d", n),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	nic := MachineNicName(id, n)
+
+	err = system.EbtablesFlush(nic)
+	if err != nil {
+		return err
+	}
+
+	// QEMU normally deletes the tap device when the netdev is removed
+	if system.InterfaceExists(nic) {
+		return system.DeleteInterface(nic)
+	}
+
+	return nil
+}
+
+// MachineKvmHotAddCores plugs vCPUs into the free slots reserved
+// by QEMU until the machine has the specified number of them
+func MachineKvmHotAddCores(id string, cores int) error {
+	res, err := MachineKvmCommand(id, "query-hotpluggable-cpus", nil)
+	if err != nil {
+		return err
+	}
+
+	list, ok := res.([]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid output from query-hotpluggable-cpus")
+	}
+
+	var plugged int
+	var free []map[string]interface{}
+
+	for _, v := range list {
+		slot, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Invalid output from query-hotpluggable-cpus")
+		}
+
+		if _, ok := slot["qom-path"]; ok {
+			count, _ := slot["vcpus-count"].(float64)
+			plugged += int(count)
+		} else {
+			free = append(free, slot)
+		}
+	}
+
+	// QEMU lists the slots from the last one
+	sort.Slice(free, func(i, j int) bool {
+		pi, _ := free[i]["props"].(map[string]interface{})
+		pj, _ := free[j]["props"].(map[string]interface{})
+
+		for _, key := range []string{"node-id", "socket-id", "die-id", "core-id", "thread-id"} {
+			vi, _ := pi[key].(float64)
+			vj, _ := pj[key].(float64)
+
+			if vi != vj {
+				return vi < vj
+			}
+		}
+
+		return false
+	})
+
+	for _, slot := range free {
+		if plugged >= cores {
+			break
+		}
+
+		args := map[string]interface{}{
+			"driver": slot["type"],
+			"id":     fmt.Sprintf("cpu%d", plugged),
+		}
+
+		if props, ok := slot["props"].(map[string]interface{}); ok {
+			for k, v := range props {
+				args[k] = v
+			}
+		}
+
+		_, err := MachineKvmCommand(id, "device_add", args)
+		if err != nil {
+			return err
+		}
+
+		count, _ := slot["vcpus-count"].(float64)
+		plugged += int(count)
+	}
+
+	if plugged < cores {
+		return fmt.Errorf("Only %d vCPUs were reserved when the machine started", plugged)
+	}
+
+	// The new vCPU threads are not pinned yet
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil || len(opts.Limits.CpuSet) == 0 {
+		return err
+	}
+
+	res, err = MachineKvmCommand(id, "query-cpus-fast", nil)
+	if err != nil {
+		return err
+	}
+
+	return kvmPinVcpus(opts.Limits.CpuSet, res)
+}
+
+// MachineKvmLayout returns the vCPUs and memory the specified running
+// machine was started with, and the ones hot-added to it since
+func MachineKvmLayout(id string) (*shared.MachineLayoutDef, error) {
+	var def shared.MachineLayoutDef
+
+	res, err := MachineKvmCommand(id, "query-hotpluggable-cpus", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cpus, ok := res.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid output from query-hotpluggable-cpus")
+	}
+
+	for _, v := range cpus {
+		slot, _ := v.(map[string]interface{})
+		path, ok := slot["qom-path"].(string)
+		if !ok {
+			continue
+		}
+
+		// Devices added with device_add are named by the daemon
+		count, _ := slot["vcpus-count"].(float64)
+		if strings.HasPrefix(path, "/machine/peripheral/") {
+			def.AddedCores += int(count)
+		} else {
+			def.Cores += int(count)
+		}
+	}
+
+	res, err = MachineKvmCommand(id, "query-memory-size-summary", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, ok := res.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid output from query-memory-size-summary")
+	}
+
+	base, _ := summary["base-memory"].(float64)
+	def.Memory = uint64(base / float64(1048576.0))
+
+	res, err = MachineKvmCommand(id, "query-memory-devices", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dimms, _ := res.([]interface{})
+	def.Dimms = make([]uint64, len(dimms))
+
+	for _, v := range dimms {
+		dev, _ := v.(map[string]interface{})
+		data, _ := dev["data"].(map[string]interface{})
+		name, _ := data["id"].(string)
+		size, _ := data["size"].(float64)
+
+		var n int
+		if _, err := fmt.Sscanf(name, "dimm%d", &n); err != nil || n >= len(dimms) {
+			return nil, fmt.Errorf("Unknown memory device '%s'", name)
+		}
+
+		def.Dimms[n] = uint64(size / float64(1048576.0))
+	}
+
+	return &def, nil
+}
+
+// MachineKvmHotAddMemory plugs a memory DIMM into the machine
+// so that it has the specified amount of memory (MiB)
+func MachineKvmHotAddMemory(id string, memory uint64) error {
+	res, err := MachineKvmCommand(id, "query-memory-size-summary", nil)
+	if err != nil {
+		return err
+	}
+
+	summary, ok := res.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid output from query-memory-size-summary")
+	}
+
+	base, _ := summary["base-memory"].(float64)
+	plugged, _ := summary["plugged-memory"].(float64)
+
+	current := uint64((base + plugged) / float64(1048576.0))
+	if memory <= current {
+		return nil
+	}
+
+	res, err = MachineKvmCommand(id, "query-memory-devices", nil)
+	if err != nil {
+		return err
+	}
+
+	dimms, _ := res.([]interface{})
+	if len(dimms) >= KvmMemorySlots {
+		return fmt.Errorf("All the %d memory slots of the machine are used", KvmMemorySlots)
+	}
+
+	// Device names are not reused, as DIMMs can not be removed
+	mem := fmt.Sprintf("mem-dimm%d", len(dimms))
+	dimm := fmt.Sprintf("dimm%d", len(dimms))
+
+	_, err = MachineKvmCommand(id, "object-add", map[string]interface{}{
+		"qom-type": "memory-backend-ram",
+		"id":       mem,
+		"size":     (memory - current) * 1048576,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = MachineKvmCommand(id, "device_add", map[string]interface{}{
+		"driver": "pc-dimm",
+		"id":     dimm,
+		"memdev": mem,
+	})
+
 	if err != nil {
+		MachineKvmCommand(id, "object-del", map[string]interface{}{"id": mem})
 		return err
 	}
 
@@ -0,0 +1,379 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// LxcBackend is the Backend implementation
+// using LXC containers
+type LxcBackend struct{}
+
+func (LxcBackend) Create(def *shared.MachineDef) error {
+	return MachineLxcCreate(def)
+}
+
+func (LxcBackend) SetLinuxOpts(id string, opts shared.LinuxOptsDef) error {
+	return MachineLxcSetLinuxOpts(id, opts)
+}
+
+func (LxcBackend) Start(id string) error {
+	return MachineLxcStart(id)
+}
+
+func (LxcBackend) Stop(id string, force bool) error {
+	return MachineLxcStop(id, force)
+}
+
+func (LxcBackend) Pause(id string) error {
+	return MachineLxcPause(id)
+}
+
+func (LxcBackend) Resume(id string) error {
+	return MachineLxcResume(id)
+}
+
+func (LxcBackend) Reset(id string) error {
+	return MachineLxcReset(id)
+}
+
+func (LxcBackend) IsRunning(id string) bool {
+	return MachineLxcIsRunning(id)
+}
+
+func (LxcBackend) Status(id string) (shared.MachineStatusDef, error) {
+	return MachineLxcStatus(id)
+}
+
+func (LxcBackend) CreateCheckpoint(id, name string) error {
+	return fmt.Errorf("Checkpoints are not supported for LXC machines")
+}
+
+func (LxcBackend) ListCheckpoints(id string) ([]shared.CheckpointDef, error) {
+	return make([]shared.CheckpointDef, 0), nil
+}
+
+func (LxcBackend) RestoreCheckpoint(id, name string) error {
+	return fmt.Errorf("Checkpoints are not supported for LXC machines")
+}
+
+func (LxcBackend) DeleteCheckpoint(id, name string) error {
+	return fmt.Errorf("Checkpoints are not supported for LXC machines")
+}
+
+func (LxcBackend) AttachVolume(id, vol string) error {
+	return fmt.Errorf("Volumes can not be attached to running LXC machines")
+}
+
+func (LxcBackend) DetachVolume(id, vol string) error {
+	return fmt.Errorf("Volumes can not be detached from running LXC machines")
+}
+
+func (LxcBackend) AttachInterface(id string, n int, iface shared.InterfaceDef) error {
+	return fmt.Errorf("Network interfaces can not be added to running LXC machines")
+}
+
+func (LxcBackend) DetachInterface(id string, n int) error {
+	return fmt.Errorf("Network interfaces can not be removed from running LXC machines")
+}
+
+func (LxcBackend) SetThrottle(id, vol string, def shared.ThrottleDef) error {
+	return fmt.Errorf("I/O limits are not supported for LXC machines")
+}
+
+func (LxcBackend) SetBandwidth(id string, n int, def shared.BandwidthDef) error {
+	return SetInterfaceBandwidth(id, n, def)
+}
+
+func (LxcBackend) HotAddCores(id string, cores int) error {
+	return fmt.Errorf("CPUs can not be added to running LXC machines")
+}
+
+func (LxcBackend) HotAddMemory(id string, memory uint64) error {
+	return fmt.Errorf("Memory can not be added to running LXC machines")
+}
+
+func (LxcBackend) Balloon(id string) (uint64, error) {
+	return 0, fmt.Errorf("Memory ballooning is not supported for LXC machines")
+}
+
+func (LxcBackend) SetBalloon(id string, target uint64) error {
+	return fmt.Errorf("Memory ballooning is not supported for LXC machines")
+}
+
+func (LxcBackend) GrowDisk(id string, size uint64) error {
+	return fmt.Errorf("Disk resizing is not supported for LXC machines")
+}
+
+func (LxcBackend) Delete(id string) error {
+	return MachineLxcDelete(id)
+}
+
+func (LxcBackend) Reconcile(fixed, problem ReportFunc) {
+	BridgeReconcile(fixed, problem)
+}
+
+func (LxcBackend) ReconcileMachine(def shared.MachineDef, fixed, problem ReportFunc) {
+	reconcileLxcMachine(def, fixed, problem)
+}
+
+func (LxcBackend) CreateNetwork(def shared.NetworkDef) error {
+	return BridgeCreateNetwork(def)
+}
+
+func (LxcBackend) DeleteNetwork(name string) error {
+	return BridgeDeleteNetwork(name)
+}
+
+// MachineLxcIsRunning checks if the specified
+// container is currently running
+func MachineLxcIsRunning(id string) bool {
+	state, err := system.LxcState(id, GlobalMachinePath)
+	if err != nil {
+		return false
+	}
+
+	return state == "RUNNING" || state == "FROZEN"
+}
+
+// MachineLxcIsPaused checks if the specified
+// container is currently frozen
+func MachineLxcIsPaused(id string) bool {
+	state, err := system.LxcState(id, GlobalMachinePath)
+	if err != nil {
+		return false
+	}
+
+	return state == "FROZEN"
+}
+
+// MachineLxcCreate creates the container's root filesystem
+// by extracting the machine's image into it
+func MachineLxcCreate(def *shared.MachineDef) error {
+	if len(def.Image) == 0 {
+		return fmt.Errorf("An 'Image' is required for LXC machines")
+	}
+
+	if utils.FileExists(MachineRootfs(def.ID)) { // Already created
+		return nil
+	}
+
+	err := os.MkdirAll(MachineRootfs(def.ID), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = system.ExtractTar(ImageFile(def.Image), MachineRootfs(def.ID))
+	if err != nil {
+		os.RemoveAll(MachinePath(def.ID))
+		return err
+	}
+
+	return nil
+}
+
+// MachineLxcWriteConfig generates the LXC configuration
+// file of the container from its definition
+func MachineLxcWriteConfig(def shared.MachineDef) error {
+	var buf bytes.Buffer
+
+	hostname := def.ID
+	if data, err := ioutil.ReadFile(fmt.Sprintf("%s/etc/hostname", MachineRootfs(def.ID))); err == nil {
+		if h := strings.TrimSpace(string(data)); len(h) > 0 {
+			hostname = h
+		}
+	}
+
+	fmt.Fprintf(&buf, "lxc.uts.name = %s\n", hostname)
+	fmt.Fprintf(&buf, "lxc.rootfs.path = dir:%s\n", MachineRootfs(def.ID))
+	fmt.Fprintf(&buf, "lxc.include = /usr/share/lxc/config/common.conf\n")
+	fmt.Fprintf(&buf, "lxc.cgroup2.memory.max = %dM\n", def.Memory)
+	fmt.Fprintf(&buf, "lxc.cgroup2.cpu.max = %d 100000\n", def.Cores*100000)
+
+	for i, iface := range def.Interfaces {
+		fmt.Fprintf(&buf, "lxc.net.%d.type = veth\n", i)
+		fmt.Fprintf(&buf, "lxc.net.%d.link = %s\n", i, NetworkNicName(iface.Network))
+		fmt.Fprintf(&buf, "lxc.net.%d.veth.pair = %s\n", i, MachineNicName(def.ID, i))
+		fmt.Fprintf(&buf, "lxc.net.%d.hwaddr = %s\n", i, iface.MAC)
+		fmt.Fprintf(&buf, "lxc.net.%d.flags = up\n", i)
+	}
+
+	for _, v := range def.Volumes {
+		fmt.Fprintf(&buf, "lxc.mount.entry = %s mnt/%s none bind,create=dir 0 0\n", VolumeFile(v), v)
+	}
+
+	return ioutil.WriteFile(MachineLxcConfig(def.ID), buf.Bytes(), 0644)
+}
+
+// MachineLxcSetLinuxOpts applies the Linux options
+// to the container's root filesystem
+func MachineLxcSetLinuxOpts(id string, opts shared.LinuxOptsDef) error {
+	if MachineLxcIsRunning(id) {
+		return fmt.Errorf("Cannot set options while the machine is running")
+	}
+
+	if len(opts.Hostname) > 0 {
+		err := LinuxSetHostname(MachineRootfs(id), opts.Hostname)
+		if err != nil {
+			return err
+		}
+	}
+	if len(opts.RootPassword) > 0 {
+		err := LinuxSetRootPassword(MachineRootfs(id), opts.RootPassword)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MachineLxcStart generates the container's configuration,
+// starts it and attaches its interfaces to their networks
+func MachineLxcStart(id string) error {
+	if MachineLxcIsRunning(id) {
+		return fmt.Errorf("Machine already running")
+	}
+
+	def, err := DBMachineGet(id)
+	if err != nil {
+		return err
+	}
+
+	err = MachineLxcWriteConfig(def)
+	if err != nil {
+		return err
+	}
+
+	err = system.LxcStart(id, GlobalMachinePath)
+	if err != nil {
+		return err
+	}
+
+	for i, iface := range def.Interfaces {
+		err := AttachInterfaceToNetwork(id, i, iface)
+		if err != nil {
+			system.LxcStop(id, GlobalMachinePath, 0, true)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MachineLxcStop stops the specified container, killing
+// it if force is true or if it does not shut down in time
+func MachineLxcStop(id string, force bool) error {
+	if !MachineLxcIsRunning(id) {
+		return fmt.Errorf("Machine already stopped")
+	}
+
+	return system.LxcStop(id, GlobalMachinePath, int(GlobalStopTimeout.Seconds()), force)
+}
+
+// MachineLxcPause freezes the specified container
+func MachineLxcPause(id string) error {
+	if !MachineLxcIsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	return system.LxcFreeze(id, GlobalMachinePath)
+}
+
+// MachineLxcResume unfreezes the specified container
+func MachineLxcResume(id string) error {
+	if !MachineLxcIsPaused(id) {
+		return fmt.Errorf("Machine is not paused")
+	}
+
+	return system.LxcUnfreeze(id, GlobalMachinePath)
+}
+
+// MachineLxcReset kills the specified container and starts it
+// again. A frozen container is frozen again once restarted
+func MachineLxcReset(id string) error {
+	if !MachineLxcIsRunning(id) {
+		return fmt.Errorf("Machine is not running")
+	}
+
+	paused := MachineLxcIsPaused(id)
+
+	err := system.LxcStop(id, GlobalMachinePath, 0, true)
+	if err != nil {
+		return err
+	}
+
+	err = MachineLxcStart(id)
+	if err != nil {
+		return err
+	}
+
+	if paused {
+		return system.LxcFreeze(id, GlobalMachinePath)
+	}
+
+	return nil
+}
+
+// MachineLxcStatus returns a MachineStatusDef
+// representing the current status of the container
+func MachineLxcStatus(id string) (shared.MachineStatusDef, error) {
+	var def shared.MachineStatusDef
+	def.Running = MachineLxcIsRunning(id)
+	def.Paused = MachineLxcIsPaused(id)
+
+	if def.Running {
+		cpu1, _, err := system.LxcStats(id, GlobalMachinePath)
+		if err != nil {
+			return def, err
+		}
+
+		time.Sleep(200 * time.Millisecond)
+
+		cpu2, mem, err := system.LxcStats(id, GlobalMachinePath)
+		if err != nil {
+			return def, err
+		}
+
+		def.CpuUsage = float32(cpu2-cpu1) / float32(200*time.Millisecond) * 100
+		def.RamUsage = mem / 1048576
+	}
+
+	disk, err := utils.DirectorySize(MachineRootfs(id))
+	if err != nil {
+		return def, err
+	}
+
+	def.DiskUsage = disk
+
+	return def, nil
+}
+
+// MachineLxcDelete deletes the container's files
+func MachineLxcDelete(id string) error {
+	if MachineLxcIsRunning(id) {
+		return fmt.Errorf("Machine is running")
+	}
+
+	def, err := DBMachineGet(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(MachinePath(id))
+	if err != nil {
+		return err
+	}
+
+	BridgeDeleteInterfaces(def)
+	return nil
+}
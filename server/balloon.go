@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+)
+
+const (
+	BalloonInterval  = 10 * time.Second // Time between two checks of the host's memory
+	BalloonPressure  = 10               // Percentage of free host memory under which the idle memory of the guests is reclaimed
+	BalloonRelease   = 25               // Percentage of free host memory above which the reclaimed memory is given back
+	BalloonHeadroom  = 64               // Free memory (MiB) left to the guests when reclaiming their memory
+	BalloonMinFactor = 4                // The default minimum target is the memory of the machine divided by this factor
+)
+
+// kvmBalloonMin returns the lowest memory
+// target (MiB) allowed for the machine
+func kvmBalloonMin(def shared.MachineDef, opts shared.KvmOptsDef) uint64 {
+	if opts.Balloon.Min > 0 {
+		return opts.Balloon.Min
+	}
+
+	return def.Memory / BalloonMinFactor
+}
+
+// validateKvmBalloon checks the balloon options of a KVM machine
+// with the specified memory and returns the coresponding http status code
+func validateKvmBalloon(memory uint64, opts shared.KvmOptsDef) (error, int) {
+	if opts.Balloon.Min > memory {
+		return fmt.Errorf("'Balloon.Min' can't be greater than the memory of the machine (%d MiB)", memory), 400
+	}
+
+	return nil, 200
+}
+
+// MachineBalloon returns the balloon information of the
+// specified running machine
+func MachineBalloon(id string) (shared.BalloonDef, error) {
+	var def shared.BalloonDef
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		return def, err
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		return def, err
+	}
+
+	b, err := MachineBackend(machine)
+	if err != nil {
+		return def, err
+	}
+
+	actual, err := b.Balloon(id)
+	if err != nil {
+		return def, err
+	}
+
+	def.Actual = actual
+	def.Min = kvmBalloonMin(machine, opts)
+	def.Max = machine.Memory
+	def.Auto = opts.Balloon.Auto
+
+	return def, nil
+}
+
+// BalloonMonitor periodically checks the memory of the host, reclaims
+// the idle memory of the guests using the automatic balloon policy when
+// it runs low, and gives it back once it is available again. It never returns
+func BalloonMonitor() {
+	for {
+		time.Sleep(BalloonInterval)
+
+		used, total, err := system.MemoryUsage()
+		if err != nil || total == 0 {
+			log.Printf("Balloon: failed to get host memory usage: %s\n", err)
+			continue
+		}
+
+		free := (total - used) * 100 / total
+		if free >= BalloonPressure && free <= BalloonRelease {
+			continue
+		}
+
+		machines, err := DBMachineList()
+		if err != nil {
+			log.Printf("Balloon: failed to list machines: %s\n", err)
+			continue
+		}
+
+		for _, m := range machines {
+			balloonMachine(m, free < BalloonPressure)
+		}
+	}
+}
+
+// balloonMachine reclaims the idle memory of the specified machine,
+// or gives it back all of its memory, if it uses the automatic policy
+func balloonMachine(m shared.MachineDef, reclaim bool) {
+	opts, err := DBMachineGetKvmOpts(m.ID)
+	if err != nil || !opts.Balloon.Auto {
+		return
+	}
+
+	state, _, err := DBMachineGetState(m.ID)
+	if err != nil || state != shared.StateRunning {
+		return
+	}
+
+	b, err := MachineBackend(m)
+	if err != nil {
+		return
+	}
+
+	actual, err := b.Balloon(m.ID)
+	if err != nil {
+		log.Printf("Balloon: machine %s: failed to get balloon: %s\n", m.ID, err)
+		return
+	}
+
+	target := m.Memory
+
+	if reclaim {
+		status, err := b.Status(m.ID)
+		if err != nil {
+			log.Printf("Balloon: machine %s: failed to get status: %s\n", m.ID, err)
+			return
+		}
+
+		// Memory used by the guest, out of what the balloon left to it
+		inUse := actual
+		if status.RamUsage < m.Memory {
+			if free := m.Memory - status.RamUsage; free < actual {
+				inUse = actual - free
+			} else {
+				inUse = 0
+			}
+		}
+
+		target = inUse + BalloonHeadroom
+
+		if min := kvmBalloonMin(m, opts); target < min {
+			target = min
+		}
+		if target >= actual {
+			return
+		}
+	} else if actual >= m.Memory {
+		return
+	}
+
+	log.Printf("Balloon: machine %s: changing memory target from %d to %d MiB\n", m.ID, actual, target)
+
+	err = b.SetBalloon(m.ID, target)
+	if err != nil {
+		log.Printf("Balloon: machine %s: failed to set balloon: %s\n", m.ID, err)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// DefaultBootOrder is the boot order of the
+// machines that do not specify one
+var DefaultBootOrder = []string{shared.BootCDRom, shared.BootDisk}
+
+// validateKvmBoot checks the boot options of a KVM machine
+// and returns the coresponding http status code
+func validateKvmBoot(opts shared.KvmOptsDef) (error, int) {
+	switch opts.Boot.Firmware {
+	case "", shared.FirmwareBIOS:
+		break
+	case shared.FirmwareUEFI:
+		break
+	default:
+		return fmt.Errorf("Invalid 'Boot.Firmware' (must be bios, uefi)"), 400
+	}
+
+	seen := make(map[string]bool)
+
+	for _, dev := range opts.Boot.Order {
+		if dev != shared.BootDisk && dev != shared.BootCDRom && dev != shared.BootNetwork {
+			return fmt.Errorf("Invalid boot device '%s' (must be disk, cdrom, network)", dev), 400
+		}
+		if seen[dev] {
+			return fmt.Errorf("Boot device '%s' specified twice", dev), 400
+		}
+
+		seen[dev] = true
+	}
+
+	return nil, 200
+}
+
+// kvmBootHostCheck checks that the host has the firmware of a
+// KVM machine and returns the coresponding http status code
+func kvmBootHostCheck(opts shared.KvmOptsDef) (error, int) {
+	if opts.Boot.Firmware == shared.FirmwareUEFI && (!utils.FileExists(GlobalOvmfCode) || !utils.FileExists(GlobalOvmfVars)) {
+		return fmt.Errorf("UEFI firmware not found on the host (%s, %s)", GlobalOvmfCode, GlobalOvmfVars), 409
+	}
+
+	return nil, 200
+}
+
+// kvmBootIndexes returns the boot priority of the disk, the CD-ROM
+// and the network interfaces of the machine, as QEMU 'bootindex'
+// values (0 if the device is not bootable)
+func kvmBootIndexes(def shared.MachineDef, opts shared.KvmOptsDef) (int, int, []int) {
+	var disk, cdrom int
+	nics := make([]int, len(def.Interfaces))
+
+	order := opts.Boot.Order
+	if len(order) == 0 {
+		order = DefaultBootOrder
+	}
+
+	index := 1
+
+	for _, dev := range order {
+		switch dev {
+		case shared.BootDisk:
+			disk = index
+			index++
+		case shared.BootCDRom:
+			if len(opts.CDRom) > 0 {
+				cdrom = index
+				index++
+			}
+		case shared.BootNetwork:
+			for i := range nics {
+				nics[i] = index
+				index++
+			}
+		}
+	}
+
+	return disk, cdrom, nics
+}
+
+// kvmBootIndex returns the 'bootindex' property
+// of a device of the specified priority
+func kvmBootIndex(index int) string {
+	if index == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(",bootindex=%d", index)
+}
+
+// MachineKvmFirmwareArgs returns the command line arguments
+// of the firmware of the specified machine
+func MachineKvmFirmwareArgs(id string, opts shared.KvmOptsDef) []string {
+	var args []string
+
+	if opts.Boot.Firmware == shared.FirmwareUEFI {
+		args = append(args, "-drive", fmt.Sprintf("if=pflash,format=raw,readonly=on,file=%s", GlobalOvmfCode))
+		args = append(args, "-drive", fmt.Sprintf("if=pflash,format=qcow2,file=%s", MachineNvramPath(id)))
+	}
+
+	if opts.Boot.Menu {
+		args = append(args, "-boot", "menu=on")
+	}
+
+	return args
+}
+
+// MachineKvmNvramSetup creates the file containing the UEFI variables
+// of the machine from the configured template, if it does not exist
+// It is a qcow2 image so that the machine can still be checkpointed
+func MachineKvmNvramSetup(id string) error {
+	if utils.FileExists(MachineNvramPath(id)) {
+		return nil
+	}
+
+	return system.ConvertRawToQcow2(GlobalOvmfVars, MachineNvramPath(id))
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/quadrifoglio/go-qmp"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+)
+
+const (
+	CgroupSlice  = "wir.slice" // Parent cgroup of the slices of the machines
+	CgroupPeriod = 100000      // Period of the CPU quotas in microseconds
+)
+
+// Controllers enabled in the slices of the machines
+var cgroupControllers = []string{"cpu", "cpuset", "memory"}
+
+// MachineCgroupPath returns the path of the cgroup of the machine,
+// relative to the mount point of the cgroup hierarchy
+func MachineCgroupPath(id string) string {
+	return fmt.Sprintf("%s/wir-%s.slice", CgroupSlice, id)
+}
+
+// kvmLimited checks if the machine has any resource limit
+func kvmLimited(opts shared.KvmOptsDef) bool {
+	return opts.Limits.CpuQuota > 0 || opts.Limits.CpuWeight > 0 || len(opts.Limits.CpuSet) > 0 || opts.Limits.MemoryMax > 0
+}
+
+// validateKvmLimits checks the resource limits of a KVM machine
+// with the specified memory and returns the coresponding http status code
+func validateKvmLimits(memory uint64, opts shared.KvmOptsDef) (error, int) {
+	if opts.Limits.CpuQuota < 0 {
+		return fmt.Errorf("Invalid 'Limits.CpuQuota'"), 400
+	}
+
+	if opts.Limits.CpuWeight < 0 || opts.Limits.CpuWeight > 10000 {
+		return fmt.Errorf("'Limits.CpuWeight' must be between 1 and 10000"), 400
+	}
+
+	if len(opts.Limits.CpuSet) > 0 {
+		cpus, err := system.ParseCpuList(opts.Limits.CpuSet)
+		if err != nil {
+			return fmt.Errorf("Invalid 'Limits.CpuSet': %s", err), 400
+		}
+
+		for _, c := range cpus {
+			if c >= runtime.NumCPU() {
+				return fmt.Errorf("Invalid 'Limits.CpuSet': the host has no CPU %d", c), 400
+			}
+		}
+	}
+
+	if opts.Limits.MemoryMax > 0 && opts.Limits.MemoryMax < memory {
+		return fmt.Errorf("'Limits.MemoryMax' can't be lower than the memory of the machine (%d MiB)", memory), 400
+	}
+
+	return nil, 200
+}
+
+// kvmLimitsHostCheck checks that the host can enforce the resource
+// limits of a KVM machine and returns the coresponding http status code
+func kvmLimitsHostCheck(opts shared.KvmOptsDef) (error, int) {
+	if kvmLimited(opts) && !system.CgroupV2() {
+		return fmt.Errorf("Resource limits require the unified cgroup hierarchy (cgroup v2) on the host"), 409
+	}
+
+	return nil, 200
+}
+
+// MachineKvmCgroupSetup moves the specified QEMU process to the slice
+// of the machine, after applying the resource limits of the machine to it
+// Without cgroup v2, the process is left in the cgroup of the daemon
+func MachineKvmCgroupSetup(id string, pid int, opts shared.KvmOptsDef) error {
+	if !system.CgroupV2() {
+		if kvmLimited(opts) {
+			return fmt.Errorf("Resource limits require the unified cgroup hierarchy (cgroup v2) on the host")
+		}
+
+		return nil
+	}
+
+	path := MachineCgroupPath(id)
+
+	err := system.CgroupCreate(path, cgroupControllers)
+	if err != nil {
+		return err
+	}
+
+	quota := "max"
+	if opts.Limits.CpuQuota > 0 {
+		quota = strconv.Itoa(opts.Limits.CpuQuota * CgroupPeriod / 100)
+	}
+
+	weight := opts.Limits.CpuWeight
+	if weight == 0 {
+		weight = 100
+	}
+
+	memory := "max"
+	if opts.Limits.MemoryMax > 0 {
+		memory = strconv.FormatUint(opts.Limits.MemoryMax*1024*1024, 10)
+	}
+
+	// Every value is written, the slice may remain from a previous run
+	settings := []struct{ file, value string }{
+		{"cpu.max", fmt.Sprintf("%s %d", quota, CgroupPeriod)},
+		{"cpu.weight", strconv.Itoa(weight)},
+		{"cpuset.cpus", opts.Limits.CpuSet},
+		{"memory.max", memory},
+	}
+
+	for _, s := range settings {
+		err := system.CgroupSet(path, s.file, s.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return system.CgroupAddProcess(path, pid)
+}
+
+// MachineKvmCgroupCleanup removes the slice of the
+// machine once its QEMU process has exited
+func MachineKvmCgroupCleanup(id string) error {
+	if !system.CgroupV2() {
+		return nil
+	}
+
+	return system.CgroupDelete(MachineCgroupPath(id))
+}
+
+// kvmVcpuThreads returns the IDs of the host threads running the vCPUs
+// of a machine, by vCPU index, from the output of 'query-cpus-fast'
+func kvmVcpuThreads(res qmp.JsonValue) (map[int]int, error) {
+	list, ok := res.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid output from query-cpus-fast")
+	}
+
+	threads := make(map[int]int)
+
+	for _, v := range list {
+		cpu, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid output from query-cpus-fast")
+		}
+
+		index, ok1 := cpu["cpu-index"].(float64)
+		thread, ok2 := cpu["thread-id"].(float64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("Invalid output from query-cpus-fast")
+		}
+
+		threads[int(index)] = int(thread)
+	}
+
+	return threads, nil
+}
+
+// kvmPinVcpus pins each vCPU thread listed in the output of
+// 'query-cpus-fast' to one of the CPUs of the specified set,
+// in turn. Threads are not pinned when the set is empty
+func kvmPinVcpus(cpuset string, res qmp.JsonValue) error {
+	if len(cpuset) == 0 {
+		return nil
+	}
+
+	cpus, err := system.ParseCpuList(cpuset)
+	if err != nil {
+		return err
+	}
+
+	threads, err := kvmVcpuThreads(res)
+	if err != nil {
+		return err
+	}
+
+	for index, thread := range threads {
+		err := system.SetThreadAffinity(thread, cpus[index%len(cpus)])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MachineKvmCgroup returns the resource limits and usage of the slice
+// of the specified running machine, or nil if it has no slice
+func MachineKvmCgroup(id string, pid int) (*shared.MachineCgroupDef, error) {
+	path := MachineCgroupPath(id)
+	if !system.CgroupV2() || !system.CgroupExists(path) {
+		return nil, nil
+	}
+
+	def := &shared.MachineCgroupDef{Path: path}
+
+	max, err := system.CgroupGet(path, "cpu.max")
+	if err != nil {
+		return nil, err
+	}
+
+	if fields := strings.Fields(max); len(fields) == 2 && fields[0] != "max" {
+		quota, _ := strconv.Atoi(fields[0])
+		period, _ := strconv.Atoi(fields[1])
+
+		if period > 0 {
+			def.CpuQuota = quota * 100 / period
+		}
+	}
+
+	weight, err := system.CgroupGet(path, "cpu.weight")
+	if err != nil {
+		return nil, err
+	}
+
+	def.CpuWeight, _ = strconv.Atoi(weight)
+
+	def.CpuSet, err = system.CgroupGet(path, "cpuset.cpus.effective")
+	if err != nil {
+		return nil, err
+	}
+
+	def.CpuThrottled, err = system.CgroupStat(path, "cpu.stat", "throttled_usec")
+	if err != nil {
+		return nil, err
+	}
+
+	memory, err := system.CgroupGet(path, "memory.max")
+	if err != nil {
+		return nil, err
+	}
+
+	if memory != "max" {
+		bytes, _ := strconv.ParseUint(memory, 10, 64)
+		def.MemoryMax = bytes / 1048576
+	}
+
+	current, err := system.CgroupGet(path, "memory.current")
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, _ := strconv.ParseUint(current, 10, 64)
+	def.MemoryCurrent = bytes / 1048576
+
+	res, err := MachineKvmCommand(id, "query-cpus-fast", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := kvmVcpuThreads(res)
+	if err != nil {
+		return nil, err
+	}
+
+	for index := 0; len(def.Vcpus) < len(threads); index++ {
+		thread, ok := threads[index]
+		if !ok {
+			continue
+		}
+
+		cpus, err := system.ThreadAffinity(pid, thread)
+		if err != nil {
+			return nil, err
+		}
+
+		def.Vcpus = append(def.Vcpus, shared.VcpuPinDef{Index: index, Thread: thread, CpuSet: cpus})
+	}
+
+	return def, nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quadrifoglio/wir/utils"
+)
+
+const (
+	ConsoleLogSize  = 1024 * 1024 // Maximum size of a console log file
+	ConsoleLogCount = 4           // Number of rotated console log files to keep
+)
+
+// Console is the serial console of a running machine
+// Its output is logged and sent to the attached clients
+type Console struct {
+	id   string
+	conn net.Conn
+	log  *utils.RotatingFile
+
+	mutex   sync.Mutex
+	clients map[chan []byte]bool
+}
+
+var (
+	consoleMutex sync.Mutex
+	consoles     = make(map[string]*Console)
+)
+
+// MachineConsoleAttach connects to the serial port
+// socket of the specified machine and starts logging it
+func MachineConsoleAttach(id string) error {
+	consoleMutex.Lock()
+	defer consoleMutex.Unlock()
+
+	if _, ok := consoles[id]; ok {
+		return nil
+	}
+
+	var conn net.Conn
+	var err error
+
+	// The socket may not have been created by the hypervisor yet
+	for i := 0; i < 20; i++ {
+		conn, err = net.Dial("unix", MachineSerialPath(id))
+		if err == nil {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	l, err := utils.OpenRotatingFile(MachineConsoleLog(id), ConsoleLogSize, ConsoleLogCount)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c := &Console{id: id, conn: conn, log: l, clients: make(map[chan []byte]bool)}
+	consoles[id] = c
+
+	go c.run()
+
+	return nil
+}
+
+// MachineConsole returns the console of the specified
+// machine, if it is running and attached
+func MachineConsole(id string) (*Console, error) {
+	consoleMutex.Lock()
+	defer consoleMutex.Unlock()
+
+	c, ok := consoles[id]
+	if !ok {
+		return nil, fmt.Errorf("Machine has no console attached (is it running?)")
+	}
+
+	return c, nil
+}
+
+// run reads the output of the serial port until the
+// hypervisor closes it, logging and broadcasting it
+func (c *Console) run() {
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			_, err := c.log.Write(data)
+			if err != nil {
+				log.Printf("Not fatal - Machine %s - Failed to log console output: %s\n", c.id, err)
+			}
+
+			c.broadcast(data)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	consoleMutex.Lock()
+	delete(consoles, c.id)
+	consoleMutex.Unlock()
+
+	c.conn.Close()
+	c.log.Close()
+
+	c.mutex.Lock()
+	for ch := range c.clients {
+		close(ch)
+	}
+
+	c.clients = make(map[chan []byte]bool)
+	c.mutex.Unlock()
+}
+
+// broadcast sends the data to the attached clients
+// Slow clients miss the data instead of blocking the console
+func (c *Console) broadcast(data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for ch := range c.clients {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the output of the
+// console, closed when the console is detached
+func (c *Console) Subscribe() chan []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ch := make(chan []byte, 64)
+	c.clients[ch] = true
+
+	return ch
+}
+
+// Unsubscribe stops sending the output
+// of the console to the channel
+func (c *Console) Unsubscribe(ch chan []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.clients[ch] {
+		delete(c.clients, ch)
+		close(ch)
+	}
+}
+
+// Write sends input to the serial port of the machine
+func (c *Console) Write(p []byte) (int, error) {
+	return c.conn.Write(p)
+}
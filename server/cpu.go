@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+)
+
+var (
+	kvmCpuModelRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	kvmCpuFlagRegexp  = regexp.MustCompile(`^[+-]?[a-z0-9_.-]+$`)
+)
+
+// validateKvmCpu checks the CPU options of a KVM machine having
+// the specified number of cores, and returns the coresponding
+// http status code
+func validateKvmCpu(cores int, opts shared.KvmOptsDef) (error, int) {
+	if len(opts.CPU.Model) > 0 && !kvmCpuModelRegexp.MatchString(opts.CPU.Model) {
+		return fmt.Errorf("Invalid 'CPU.Model'"), 400
+	}
+
+	if opts.CPU.Sockets < 0 || opts.CPU.Threads < 0 {
+		return fmt.Errorf("Invalid 'CPU.Sockets' or 'CPU.Threads' number"), 400
+	}
+
+	sockets, threads := kvmCpuTopology(opts)
+	if cores%(sockets*threads) != 0 {
+		return fmt.Errorf("'Cores' (%d) must be a multiple of 'CPU.Sockets' * 'CPU.Threads' (%d)", cores, sockets*threads), 400
+	}
+
+	if opts.Hotplug.MaxCores < 0 || opts.Hotplug.MaxCores%(sockets*threads) != 0 {
+		return fmt.Errorf("'Hotplug.MaxCores' must be a multiple of 'CPU.Sockets' * 'CPU.Threads' (%d)", sockets*threads), 400
+	}
+
+	for _, f := range opts.CPU.Flags {
+		if !kvmCpuFlagRegexp.MatchString(f) {
+			return fmt.Errorf("Invalid CPU flag '%s'", f), 400
+		}
+	}
+
+	return nil, 200
+}
+
+// kvmCpuHostCheck checks that the host supports the CPU
+// options of a KVM machine and returns the coresponding http status code
+func kvmCpuHostCheck(opts shared.KvmOptsDef) (error, int) {
+	if !opts.CPU.Nested {
+		return nil, 200
+	}
+
+	flag, err := system.CpuVirtFlag()
+	if err != nil {
+		return err, 500
+	}
+
+	if len(flag) == 0 || !system.NestedVirtEnabled() {
+		return fmt.Errorf("Nested virtualization is not enabled on the host (kvm module 'nested' parameter)"), 409
+	}
+
+	return nil, 200
+}
+
+// kvmCpuTopology returns the number of sockets
+// and threads per core of the machine
+func kvmCpuTopology(opts shared.KvmOptsDef) (int, int) {
+	sockets, threads := opts.CPU.Sockets, opts.CPU.Threads
+
+	if sockets == 0 {
+		sockets = 1
+	}
+	if threads == 0 {
+		threads = 1
+	}
+
+	return sockets, threads
+}
+
+// kvmMaxCores returns the number of vCPUs the machine can
+// grow to while it is running, without any reservation by default
+func kvmMaxCores(def shared.MachineDef, opts shared.KvmOptsDef) int {
+	max := opts.Hotplug.MaxCores
+	if max < def.Cores {
+		max = def.Cores
+	}
+
+	return max
+}
+
+// MachineKvmCpuArgs returns the command line arguments defining
+// the CPU model and topology of the specified machine
+// The topology covers the vCPUs that can be hot-added
+func MachineKvmCpuArgs(def shared.MachineDef, opts shared.KvmOptsDef) []string {
+	smp := fmt.Sprintf("%d", def.Cores)
+	max := kvmMaxCores(def, opts)
+
+	sockets, threads := kvmCpuTopology(opts)
+	if (sockets > 1 || threads > 1) && max%(sockets*threads) == 0 {
+		smp = fmt.Sprintf("%s,sockets=%d,cores=%d,threads=%d", smp, sockets, max/(sockets*threads), threads)
+	}
+	if max > def.Cores {
+		smp = fmt.Sprintf("%s,maxcpus=%d", smp, max)
+	}
+
+	args := []string{"-smp", smp}
+
+	if len(opts.CPU.Model) == 0 && !opts.CPU.Nested && len(opts.CPU.Flags) == 0 {
+		return args
+	}
+
+	// Default model of qemu-system-x86_64
+	model := opts.CPU.Model
+	if len(model) == 0 {
+		model = "qemu64"
+	}
+
+	cpu := []string{model}
+
+	if opts.CPU.Nested {
+		flag, _ := system.CpuVirtFlag()
+		if len(flag) > 0 {
+			cpu = append(cpu, "+"+flag)
+		}
+	}
+
+	for _, f := range opts.CPU.Flags {
+		if !strings.HasPrefix(f, "+") && !strings.HasPrefix(f, "-") {
+			f = "+" + f
+		}
+
+		cpu = append(cpu, f)
+	}
+
+	return append(args, "-cpu", strings.Join(cpu, ","))
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/quadrifoglio/wir/shared"
 
@@ -46,6 +48,127 @@ const (
 		disk BIGINT NOT NULL
 	);
 
+	CREATE TABLE IF NOT EXISTS machine_state (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		state VARCHAR(255) NOT NULL,
+		reason VARCHAR(255),
+		updated BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS vnc_security (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		tls BOOLEAN NOT NULL,
+		verify_client BOOLEAN NOT NULL,
+		passwd_expiry INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_display (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		display VARCHAR(255) NOT NULL,
+		video VARCHAR(255) NOT NULL,
+		spice_addr VARCHAR(255) NOT NULL,
+		spice_port INTEGER NOT NULL,
+		spice_tls_port INTEGER NOT NULL,
+		spice_passwd VARCHAR(255) NOT NULL,
+		spice_agent BOOLEAN NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_cpu (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		model VARCHAR(255) NOT NULL,
+		sockets INTEGER NOT NULL,
+		threads INTEGER NOT NULL,
+		nested BOOLEAN NOT NULL,
+		flags TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_boot (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		firmware VARCHAR(255) NOT NULL,
+		boot_order VARCHAR(255) NOT NULL,
+		menu BOOLEAN NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_volumes (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		bus VARCHAR(255) NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_hotplug (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		max_cores INTEGER NOT NULL,
+		max_mem BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_balloon (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		min BIGINT NOT NULL,
+		auto BOOLEAN NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS kvm_limits (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		cpu_quota INTEGER NOT NULL,
+		cpu_weight INTEGER NOT NULL,
+		cpuset VARCHAR(255) NOT NULL,
+		mem_max BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS io_throttle (
+		drive CHAR(8) NOT NULL UNIQUE,
+		iops_rd BIGINT NOT NULL,
+		iops_wr BIGINT NOT NULL,
+		bps_rd BIGINT NOT NULL,
+		bps_wr BIGINT NOT NULL,
+		iops_rd_max BIGINT NOT NULL,
+		iops_wr_max BIGINT NOT NULL,
+		bps_rd_max BIGINT NOT NULL,
+		bps_wr_max BIGINT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS machine_event (
+		machine CHAR(8) NOT NULL REFERENCES machine(id),
+		time BIGINT NOT NULL,
+		type VARCHAR(255) NOT NULL,
+		message VARCHAR(255)
+	);
+
+	CREATE TABLE IF NOT EXISTS machine_exit (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		time BIGINT NOT NULL,
+		code INTEGER NOT NULL,
+		signal VARCHAR(255),
+		expected BOOLEAN NOT NULL,
+		stderr TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS restart_policy (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		policy VARCHAR(255) NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS flavor (
+		name VARCHAR(255) NOT NULL UNIQUE PRIMARY KEY,
+		cores INTEGER NOT NULL,
+		mem BIGINT NOT NULL,
+		disk BIGINT NOT NULL,
+		iops_rd BIGINT NOT NULL,
+		iops_wr BIGINT NOT NULL,
+		bps_rd BIGINT NOT NULL,
+		bps_wr BIGINT NOT NULL,
+		iops_rd_max BIGINT NOT NULL,
+		iops_wr_max BIGINT NOT NULL,
+		bps_rd_max BIGINT NOT NULL,
+		bps_wr_max BIGINT NOT NULL,
+		cpu_quota INTEGER NOT NULL,
+		cpu_weight INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS machine_flavor (
+		machine CHAR(8) NOT NULL UNIQUE REFERENCES machine(id),
+		flavor VARCHAR(255) NOT NULL REFERENCES flavor(name)
+	);
+
 	CREATE TABLE IF NOT EXISTS iface (
 		machine CHAR(8) NOT NULL REFERENCES machine(id),
 		net VARCHAR(255) NOT NULL,
@@ -53,6 +176,15 @@ const (
 		ip VARCHAR(255)
 	);
 
+	CREATE TABLE IF NOT EXISTS iface_bandwidth (
+		machine CHAR(8) NOT NULL REFERENCES machine(id),
+		idx INTEGER NOT NULL,
+		in_rate BIGINT NOT NULL,
+		in_burst BIGINT NOT NULL,
+		out_rate BIGINT NOT NULL,
+		out_burst BIGINT NOT NULL
+	);
+
 	CREATE TABLE IF NOT EXISTS attach (
 		machine CHAR(8) NOT NULL REFERENCES machine(id),
 		volume CHAR(8) NOT NULL REFERENCES volume(id)
@@ -373,6 +505,206 @@ func DBNetworkDelete(name string) error {
 	return nil
 }
 
+// FLAVORS
+
+// DBFlavorExists checks if the specified flavor name
+// exists in the database
+func DBFlavorExists(name string) bool {
+	rows, err := DB.Query("SELECT name FROM flavor WHERE name = ? LIMIT 1", name)
+	if err != nil {
+		log.Println("Flavor exists check:", err)
+		return false
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		return true
+	}
+
+	return false
+}
+
+// DBFlavorCreate creates a new flavor in the database
+// using the specified definition
+func DBFlavorCreate(def shared.FlavorDef) error {
+	_, err := DB.Exec(
+		"INSERT INTO flavor VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		def.Name,
+		def.Cores,
+		def.Memory,
+		def.Disk,
+		def.DiskThrottle.ReadIOPS,
+		def.DiskThrottle.WriteIOPS,
+		def.DiskThrottle.ReadBPS,
+		def.DiskThrottle.WriteBPS,
+		def.DiskThrottle.ReadIOPSBurst,
+		def.DiskThrottle.WriteIOPSBurst,
+		def.DiskThrottle.ReadBPSBurst,
+		def.DiskThrottle.WriteBPSBurst,
+		def.CpuQuota,
+		def.CpuWeight,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBFlavorFetch fetches a corresponding data structure
+// from the database
+func DBFlavorFetch(rows *sql.Rows) (shared.FlavorDef, error) {
+	var def shared.FlavorDef
+
+	err := rows.Scan(
+		&def.Name,
+		&def.Cores,
+		&def.Memory,
+		&def.Disk,
+		&def.DiskThrottle.ReadIOPS,
+		&def.DiskThrottle.WriteIOPS,
+		&def.DiskThrottle.ReadBPS,
+		&def.DiskThrottle.WriteBPS,
+		&def.DiskThrottle.ReadIOPSBurst,
+		&def.DiskThrottle.WriteIOPSBurst,
+		&def.DiskThrottle.ReadBPSBurst,
+		&def.DiskThrottle.WriteBPSBurst,
+		&def.CpuQuota,
+		&def.CpuWeight,
+	)
+
+	return def, err
+}
+
+// DBFlavorList returns all the flavors
+// stored in the database
+func DBFlavorList() ([]shared.FlavorDef, error) {
+	rows, err := DB.Query("SELECT * FROM flavor")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	flavors := make([]shared.FlavorDef, 0)
+	for rows.Next() {
+		def, err := DBFlavorFetch(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		flavors = append(flavors, def)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return flavors, nil
+}
+
+// DBFlavorGet returns the requested flavor
+// from the database
+func DBFlavorGet(name string) (shared.FlavorDef, error) {
+	var def shared.FlavorDef
+
+	rows, err := DB.Query("SELECT * FROM flavor WHERE name = ?", name)
+	if err != nil {
+		return def, err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		def, err = DBFlavorFetch(rows)
+		if err != nil {
+			return def, err
+		}
+
+		return def, nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return def, err
+	}
+
+	return def, fmt.Errorf("Flavor not found")
+}
+
+// DBFlavorUpdate replaces all the values of the specified flavor
+// with the new ones
+func DBFlavorUpdate(def shared.FlavorDef) error {
+	sqls := `
+		UPDATE flavor SET
+			cores = ?, mem = ?, disk = ?,
+			iops_rd = ?, iops_wr = ?, bps_rd = ?, bps_wr = ?,
+			iops_rd_max = ?, iops_wr_max = ?, bps_rd_max = ?, bps_wr_max = ?,
+			cpu_quota = ?, cpu_weight = ?
+		WHERE name = ?
+	`
+
+	_, err := DB.Exec(sqls,
+		def.Cores,
+		def.Memory,
+		def.Disk,
+		def.DiskThrottle.ReadIOPS,
+		def.DiskThrottle.WriteIOPS,
+		def.DiskThrottle.ReadBPS,
+		def.DiskThrottle.WriteBPS,
+		def.DiskThrottle.ReadIOPSBurst,
+		def.DiskThrottle.WriteIOPSBurst,
+		def.DiskThrottle.ReadBPSBurst,
+		def.DiskThrottle.WriteBPSBurst,
+		def.CpuQuota,
+		def.CpuWeight,
+		def.Name,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBFlavorMachines returns the IDs of the
+// machines sized from the specified flavor
+func DBFlavorMachines(name string) ([]string, error) {
+	rows, err := DB.Query("SELECT machine FROM machine_flavor WHERE flavor = ?", name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	machines := make([]string, 0)
+	for rows.Next() {
+		var id string
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		machines = append(machines, id)
+	}
+
+	return machines, rows.Err()
+}
+
+// DBFlavorDelete deletes the specified flavor
+// from the database
+func DBFlavorDelete(name string) error {
+	_, err := DB.Exec("DELETE FROM flavor WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // VOLUMES
 
 // DBVolumeExists checks if the specified volume ID
@@ -408,7 +740,7 @@ func DBVolumeCreate(def shared.VolumeDef) error {
 		return err
 	}
 
-	return nil
+	return DBThrottleSet(def.ID, def.Throttle)
 }
 
 // DBVolumeFetch fetches a corresponding data structure
@@ -423,6 +755,11 @@ func DBVolumeFetch(rows *sql.Rows) (shared.VolumeDef, error) {
 		&def.Size,
 	)
 
+	if err != nil {
+		return def, err
+	}
+
+	def.Throttle, err = DBThrottleGet(def.ID)
 	return def, err
 }
 
@@ -495,7 +832,7 @@ func DBVolumeUpdate(def shared.VolumeDef) error {
 		return err
 	}
 
-	return nil
+	return DBThrottleSet(def.ID, def.Throttle)
 }
 
 // DBVolumeDelete deletes the specified volume
@@ -506,6 +843,72 @@ func DBVolumeDelete(id string) error {
 		return err
 	}
 
+	return DBThrottleDelete(id)
+}
+
+// DBThrottleSet stores the I/O limits of the specified
+// drive (ID of a machine for its disk, or of a volume)
+func DBThrottleSet(drive string, def shared.ThrottleDef) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO io_throttle VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		drive,
+		def.ReadIOPS,
+		def.WriteIOPS,
+		def.ReadBPS,
+		def.WriteBPS,
+		def.ReadIOPSBurst,
+		def.WriteIOPSBurst,
+		def.ReadBPSBurst,
+		def.WriteBPSBurst,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBThrottleGet returns the I/O limits of the specified drive
+// Drives without stored limits are not throttled
+func DBThrottleGet(drive string) (shared.ThrottleDef, error) {
+	var def shared.ThrottleDef
+
+	rows, err := DB.Query("SELECT iops_rd, iops_wr, bps_rd, bps_wr, iops_rd_max, iops_wr_max, bps_rd_max, bps_wr_max FROM io_throttle WHERE drive = ? LIMIT 1", drive)
+	if err != nil {
+		return def, err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(
+			&def.ReadIOPS,
+			&def.WriteIOPS,
+			&def.ReadBPS,
+			&def.WriteBPS,
+			&def.ReadIOPSBurst,
+			&def.WriteIOPSBurst,
+			&def.ReadBPSBurst,
+			&def.WriteBPSBurst,
+		)
+
+		if err != nil {
+			return def, err
+		}
+	}
+
+	return def, rows.Err()
+}
+
+// DBThrottleDelete deletes the I/O limits
+// of the specified drive
+func DBThrottleDelete(drive string) error {
+	_, err := DB.Exec("DELETE FROM io_throttle WHERE drive = ?", drive)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -555,16 +958,57 @@ func DBMachineSetInterfaces(def shared.MachineDef) error {
 		return err
 	}
 
-	for _, i := range def.Interfaces {
+	_, err = DB.Exec("DELETE FROM iface_bandwidth WHERE machine = ?", def.ID)
+	if err != nil {
+		return err
+	}
+
+	for n, i := range def.Interfaces {
 		_, err := DB.Exec("INSERT INTO iface VALUES (?, ?, ?, ?)", def.ID, i.Network, i.MAC, i.IP)
 		if err != nil {
 			return err
 		}
+
+		_, err = DB.Exec(
+			"INSERT INTO iface_bandwidth VALUES (?, ?, ?, ?, ?, ?)",
+			def.ID,
+			n,
+			i.Bandwidth.IngressRate,
+			i.Bandwidth.IngressBurst,
+			i.Bandwidth.EgressRate,
+			i.Bandwidth.EgressBurst,
+		)
+
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// DBVolumeAttachedTo returns the ID of the machine to which
+// the specified volume is attached, or an empty string
+func DBVolumeAttachedTo(vol string) (string, error) {
+	rows, err := DB.Query("SELECT machine FROM attach WHERE volume = ? LIMIT 1", vol)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var id string
+
+	if rows.Next() {
+		err := rows.Scan(&id)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return id, rows.Err()
+}
+
 // DBMachineGetVolumes returns the list of volume IDs
 // associated with the machine
 func DBMachineGetVolumes(id string) ([]string, error) {
@@ -627,33 +1071,613 @@ func DBMachineSetKvmOpts(id string, def shared.KvmOptsDef) error {
 		return err
 	}
 
-	return nil
-}
-
-// DBMachineGetKvmOpts retreives the KVM-specific options of
-// the machine as a data structure
-func DBMachineGetKvmOpts(id string) (shared.KvmOptsDef, error) {
-	var def shared.KvmOptsDef
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO vnc_security VALUES (?, ?, ?, ?)",
+		id,
+		def.VNC.TLS,
+		def.VNC.VerifyClient,
+		def.VNC.PasswordExpiry,
+	)
 
-	rows, err := DB.Query("SELECT * FROM kvm_opt WHERE machine = ? LIMIT 1", id)
 	if err != nil {
-		return def, err
+		return err
 	}
 
-	defer rows.Close()
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO kvm_display VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id,
+		def.Display,
+		def.Video,
+		def.Spice.Address,
+		def.Spice.Port,
+		def.Spice.TLSPort,
+		def.Spice.Password,
+		def.Spice.Agent,
+	)
 
-	if rows.Next() {
-		err := rows.Scan(&id, &def.PID, &def.CDRom, &def.VNC.Enabled, &def.VNC.Address, &def.VNC.Port, &def.VNC.WebsocketPort, &def.VNC.Password)
-		if err != nil {
-			return def, err
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO kvm_cpu VALUES (?, ?, ?, ?, ?, ?)",
+		id,
+		def.CPU.Model,
+		def.CPU.Sockets,
+		def.CPU.Threads,
+		def.CPU.Nested,
+		strings.Join(def.CPU.Flags, ","),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO kvm_boot VALUES (?, ?, ?, ?)",
+		id,
+		def.Boot.Firmware,
+		strings.Join(def.Boot.Order, ","),
+		def.Boot.Menu,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO kvm_hotplug VALUES (?, ?, ?)",
+		id,
+		def.Hotplug.MaxCores,
+		def.Hotplug.MaxMemory,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO kvm_balloon VALUES (?, ?, ?)",
+		id,
+		def.Balloon.Min,
+		def.Balloon.Auto,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(
+		"INSERT OR REPLACE INTO kvm_limits VALUES (?, ?, ?, ?, ?)",
+		id,
+		def.Limits.CpuQuota,
+		def.Limits.CpuWeight,
+		def.Limits.CpuSet,
+		def.Limits.MemoryMax,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("INSERT OR REPLACE INTO kvm_volumes VALUES (?, ?)", id, def.VolumeBus)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBMachineGetKvmOpts retreives the KVM-specific options of
+// the machine as a data structure
+func DBMachineGetKvmOpts(id string) (shared.KvmOptsDef, error) {
+	var def shared.KvmOptsDef
+
+	rows, err := DB.Query("SELECT * FROM kvm_opt WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return def, err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&id, &def.PID, &def.CDRom, &def.VNC.Enabled, &def.VNC.Address, &def.VNC.Port, &def.VNC.WebsocketPort, &def.VNC.Password)
+		if err != nil {
+			return def, err
 		}
 
-		return def, nil
+		rows.Close()
+
+		err = dbMachineGetVncSecurity(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		err = dbMachineGetDisplay(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		err = dbMachineGetCpu(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		err = dbMachineGetBoot(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		err = dbMachineGetHotplug(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		err = dbMachineGetBalloon(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		err = dbMachineGetLimits(id, &def)
+		if err != nil {
+			return def, err
+		}
+
+		return def, dbMachineGetVolumeBus(id, &def)
 	}
 
 	return def, fmt.Errorf("KVM options not found")
 }
 
+// dbMachineGetVncSecurity retreives the VNC security options of the
+// machine. Machines without stored options use plain VNC
+func dbMachineGetVncSecurity(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT tls, verify_client, passwd_expiry FROM vnc_security WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&def.VNC.TLS, &def.VNC.VerifyClient, &def.VNC.PasswordExpiry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetDisplay retreives the display options of the
+// machine. Machines without stored options use VNC
+func dbMachineGetDisplay(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT display, video, spice_addr, spice_port, spice_tls_port, spice_passwd, spice_agent FROM kvm_display WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&def.Display, &def.Video, &def.Spice.Address, &def.Spice.Port, &def.Spice.TLSPort, &def.Spice.Password, &def.Spice.Agent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetCpu retreives the CPU options of the machine
+// Machines without stored options use the default CPU
+func dbMachineGetCpu(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT model, sockets, threads, nested, flags FROM kvm_cpu WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		var flags string
+
+		err := rows.Scan(&def.CPU.Model, &def.CPU.Sockets, &def.CPU.Threads, &def.CPU.Nested, &flags)
+		if err != nil {
+			return err
+		}
+
+		if len(flags) > 0 {
+			def.CPU.Flags = strings.Split(flags, ",")
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetBoot retreives the boot options of the machine
+// Machines without stored options use the BIOS
+func dbMachineGetBoot(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT firmware, boot_order, menu FROM kvm_boot WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		var order string
+
+		err := rows.Scan(&def.Boot.Firmware, &order, &def.Boot.Menu)
+		if err != nil {
+			return err
+		}
+
+		if len(order) > 0 {
+			def.Boot.Order = strings.Split(order, ",")
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetHotplug retreives the hot-add limits of the machine
+// Machines without stored options can not grow while running
+func dbMachineGetHotplug(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT max_cores, max_mem FROM kvm_hotplug WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&def.Hotplug.MaxCores, &def.Hotplug.MaxMemory)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetBalloon retreives the balloon options of the machine
+// Machines without stored options are never ballooned automatically
+func dbMachineGetBalloon(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT min, auto FROM kvm_balloon WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&def.Balloon.Min, &def.Balloon.Auto)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetLimits retreives the resource limits of the machine
+// Machines without stored limits are not limited
+func dbMachineGetLimits(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT cpu_quota, cpu_weight, cpuset, mem_max FROM kvm_limits WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&def.Limits.CpuQuota, &def.Limits.CpuWeight, &def.Limits.CpuSet, &def.Limits.MemoryMax)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// dbMachineGetVolumeBus retreives the bus of the volumes of the machine
+// Machines without stored options keep their volumes on the IDE bus
+func dbMachineGetVolumeBus(id string, def *shared.KvmOptsDef) error {
+	rows, err := DB.Query("SELECT bus FROM kvm_volumes WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&def.VolumeBus)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// DBDisplayPortsInUse returns the TCP ports of the remote displays (VNC
+// display and websocket ports, SPICE ports) assigned to the machines
+// other than the specified one, associated with the ID of the machine using them
+func DBDisplayPortsInUse(except string) (map[int]string, error) {
+	ports := make(map[int]string)
+
+	rows, err := DB.Query("SELECT machine, vnc_port, vnc_ws_port FROM kvm_opt WHERE vnc_enabled AND vnc_addr != '' AND machine != ?", except)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var display, ws int
+
+		err := rows.Scan(&id, &display, &ws)
+		if err != nil {
+			return nil, err
+		}
+
+		ports[VncDisplayPort+display] = id
+		if ws > 0 {
+			ports[ws] = id
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = DB.Query("SELECT machine, spice_port, spice_tls_port FROM kvm_display WHERE display = ? AND machine != ?", shared.DisplaySpice, except)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var port, tlsPort int
+
+		err := rows.Scan(&id, &port, &tlsPort)
+		if err != nil {
+			return nil, err
+		}
+
+		if port > 0 {
+			ports[port] = id
+		}
+		if tlsPort > 0 {
+			ports[tlsPort] = id
+		}
+	}
+
+	return ports, rows.Err()
+}
+
+// DBMachineSetState saves the lifecycle state of the
+// machine and the reason of the change into the database
+func DBMachineSetState(id, state, reason string) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO machine_state VALUES (?, ?, ?, ?)",
+		id,
+		state,
+		reason,
+		time.Now().Unix(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return DBMachineAddEvent(id, state, reason)
+}
+
+// DBMachineGetState retreives the lifecycle state of the machine
+// and the reason of the last change
+// Machines without a stored state are considered stopped
+func DBMachineGetState(id string) (string, string, error) {
+	var state, reason string
+
+	rows, err := DB.Query("SELECT state, reason FROM machine_state WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&state, &reason)
+		if err != nil {
+			return "", "", err
+		}
+
+		return state, reason, nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", "", err
+	}
+
+	return shared.StateStopped, "", nil
+}
+
+// DBMachineAddEvent appends an entry to
+// the event history of the machine
+func DBMachineAddEvent(id, typ, message string) error {
+	_, err := DB.Exec("INSERT INTO machine_event VALUES (?, ?, ?, ?)", id, time.Now().Unix(), typ, message)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBMachineListEvents returns the event
+// history of the machine, oldest first
+func DBMachineListEvents(id string) ([]shared.MachineEventDef, error) {
+	var events []shared.MachineEventDef
+
+	rows, err := DB.Query("SELECT time, type, message FROM machine_event WHERE machine = ? ORDER BY rowid", id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var event shared.MachineEventDef
+
+		err := rows.Scan(&event.Timestamp, &event.Type, &event.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DBMachineSetExit saves the termination of the
+// hypervisor process of the machine into the database
+func DBMachineSetExit(id string, def shared.MachineExitDef) error {
+	_, err := DB.Exec(
+		"INSERT OR REPLACE INTO machine_exit VALUES (?, ?, ?, ?, ?, ?)",
+		id,
+		def.Timestamp,
+		def.Code,
+		def.Signal,
+		def.Expected,
+		strings.Join(def.Stderr, "\n"),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBMachineGetExit retreives the last termination of the hypervisor
+// process of the machine, or nil if it never exited
+func DBMachineGetExit(id string) (*shared.MachineExitDef, error) {
+	rows, err := DB.Query("SELECT time, code, signal, expected, stderr FROM machine_exit WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		var def shared.MachineExitDef
+		var stderr string
+
+		err := rows.Scan(&def.Timestamp, &def.Code, &def.Signal, &def.Expected, &stderr)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(stderr) > 0 {
+			def.Stderr = strings.Split(stderr, "\n")
+		}
+
+		return &def, nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// DBMachineSetRestartPolicy saves the restart
+// policy of the machine into the database
+func DBMachineSetRestartPolicy(def shared.MachineDef) error {
+	_, err := DB.Exec("INSERT OR REPLACE INTO restart_policy VALUES (?, ?)", def.ID, def.RestartPolicy)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBMachineGetRestartPolicy returns the restart policy of the machine
+// Machines without a stored policy are never restarted
+func DBMachineGetRestartPolicy(id string) (string, error) {
+	var policy string
+
+	rows, err := DB.Query("SELECT policy FROM restart_policy WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&policy)
+		if err != nil {
+			return "", err
+		}
+
+		return policy, nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return shared.RestartNever, nil
+}
+
+// DBMachineSetFlavor saves the flavor of the specified
+// machine, removing it if the machine has none
+func DBMachineSetFlavor(def shared.MachineDef) error {
+	if len(def.Flavor) == 0 {
+		_, err := DB.Exec("DELETE FROM machine_flavor WHERE machine = ?", def.ID)
+		return err
+	}
+
+	_, err := DB.Exec("INSERT OR REPLACE INTO machine_flavor VALUES (?, ?)", def.ID, def.Flavor)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBMachineGetFlavor returns the name of the flavor of
+// the specified machine, empty if it has none
+func DBMachineGetFlavor(id string) (string, error) {
+	var flavor string
+
+	rows, err := DB.Query("SELECT flavor FROM machine_flavor WHERE machine = ? LIMIT 1", id)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&flavor)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return flavor, rows.Err()
+}
+
 // DBMachineGetInterfaces returns the details of the interfaces
 // associated with the machine
 func DBMachineGetInterfaces(id string) ([]shared.InterfaceDef, error) {
@@ -681,7 +1705,36 @@ func DBMachineGetInterfaces(id string) ([]shared.InterfaceDef, error) {
 		return nil, err
 	}
 
-	return ifaces, nil
+	rows.Close()
+
+	return ifaces, dbMachineGetBandwidth(id, ifaces)
+}
+
+// dbMachineGetBandwidth retreives the bandwidth limits of the interfaces
+// of the machine. Interfaces without stored limits are not limited
+func dbMachineGetBandwidth(id string, ifaces []shared.InterfaceDef) error {
+	rows, err := DB.Query("SELECT idx, in_rate, in_burst, out_rate, out_burst FROM iface_bandwidth WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var n int
+		var bw shared.BandwidthDef
+
+		err := rows.Scan(&n, &bw.IngressRate, &bw.IngressBurst, &bw.EgressRate, &bw.EgressBurst)
+		if err != nil {
+			return err
+		}
+
+		if n >= 0 && n < len(ifaces) {
+			ifaces[n].Bandwidth = bw
+		}
+	}
+
+	return rows.Err()
 }
 
 // DBMachineCreate creates a new machine in the database
@@ -707,6 +1760,15 @@ func DBMachineCreate(def shared.MachineDef) error {
 	if err := DBMachineSetInterfaces(def); err != nil {
 		return err
 	}
+	if err := DBMachineSetRestartPolicy(def); err != nil {
+		return err
+	}
+	if err := DBThrottleSet(def.ID, def.DiskThrottle); err != nil {
+		return err
+	}
+	if err := DBMachineSetFlavor(def); err != nil {
+		return err
+	}
 
 	var opts shared.KvmOptsDef
 
@@ -715,6 +1777,22 @@ func DBMachineCreate(def shared.MachineDef) error {
 		return err
 	}
 
+	err = DBMachineSetState(def.ID, shared.StateCreating, "")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DBMachineSetDisk updates the disk size
+// of the machine in the database
+func DBMachineSetDisk(id string, disk uint64) error {
+	_, err := DB.Exec("UPDATE machine SET disk = ? WHERE id = ?", disk, id)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -746,6 +1824,21 @@ func DBMachineFetch(rows *sql.Rows) (shared.MachineDef, error) {
 		return def, err
 	}
 
+	def.RestartPolicy, err = DBMachineGetRestartPolicy(def.ID)
+	if err != nil {
+		return def, err
+	}
+
+	def.DiskThrottle, err = DBThrottleGet(def.ID)
+	if err != nil {
+		return def, err
+	}
+
+	def.Flavor, err = DBMachineGetFlavor(def.ID)
+	if err != nil {
+		return def, err
+	}
+
 	return def, nil
 }
 
@@ -896,6 +1989,15 @@ func DBMachineUpdate(def shared.MachineDef) error {
 	if err := DBMachineSetInterfaces(def); err != nil {
 		return err
 	}
+	if err := DBMachineSetRestartPolicy(def); err != nil {
+		return err
+	}
+	if err := DBThrottleSet(def.ID, def.DiskThrottle); err != nil {
+		return err
+	}
+	if err := DBMachineSetFlavor(def); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -918,11 +2020,86 @@ func DBMachineDelete(id string) error {
 		return err
 	}
 
+	_, err = DB.Exec("DELETE FROM iface_bandwidth WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
 	_, err = DB.Exec("DELETE FROM kvm_opt WHERE machine = ?", id)
 	if err != nil {
 		return err
 	}
 
+	_, err = DB.Exec("DELETE FROM machine_state WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM restart_policy WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM machine_flavor WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	err = DBThrottleDelete(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM vnc_security WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_hotplug WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_balloon WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_limits WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_volumes WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_boot WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_cpu WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM kvm_display WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM machine_event WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("DELETE FROM machine_exit WHERE machine = ?", id)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
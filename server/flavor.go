@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+var flavorNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,32}$`)
+
+// validateFlavor checks if the requested definition
+// is valid, and returns the coresponding http status code
+func validateFlavor(req shared.FlavorDef) (error, int) {
+	if len(req.Name) == 0 {
+		return fmt.Errorf("Missing 'Name'"), 400
+	}
+	if !flavorNameRegexp.MatchString(req.Name) {
+		return fmt.Errorf("Invalid 'Name': up to 32 letters, digits, '_', '.' or '-'"), 400
+	}
+
+	if req.Cores <= 0 {
+		return fmt.Errorf("'Cores' must be greater than 0"), 400
+	}
+	if req.Memory == 0 {
+		return fmt.Errorf("'Memory' can't be 0"), 400
+	}
+
+	if err, status := validateThrottle("DiskThrottle", req.DiskThrottle); err != nil {
+		return err, status
+	}
+
+	if req.CpuQuota < 0 {
+		return fmt.Errorf("Invalid 'CpuQuota'"), 400
+	}
+	if req.CpuWeight < 0 || req.CpuWeight > 10000 {
+		return fmt.Errorf("'CpuWeight' must be between 1 and 10000"), 400
+	}
+
+	// The limits must be supported by every backend they apply to
+	opts := flavorKvmOpts(shared.KvmOptsDef{}, shared.FlavorDef{}, req)
+
+	for _, b := range Backends() {
+		kb, ok := b.(KvmOptsBackend)
+		if !ok {
+			continue
+		}
+
+		if err, status := kb.ValidateKvmOpts(opts); err != nil {
+			return err, status
+		}
+	}
+
+	return nil, 200
+}
+
+// flavorLimited checks if the flavor has any CPU limit
+func flavorLimited(def shared.FlavorDef) bool {
+	return def.CpuQuota > 0 || def.CpuWeight > 0
+}
+
+// expandFlavor fills the sizing of the specified machine left at 0
+// from its flavor, if any, and returns the coresponding http status
+// code. Values that do not match the flavor are rejected
+func expandFlavor(req *shared.MachineDef) (error, int) {
+	if len(req.Flavor) == 0 {
+		return nil, 200
+	}
+
+	if !DBFlavorExists(req.Flavor) {
+		return fmt.Errorf("Flavor '%s' not found", req.Flavor), 404
+	}
+
+	f, err := DBFlavorGet(req.Flavor)
+	if err != nil {
+		return err, 500
+	}
+
+	if req.Cores == 0 {
+		req.Cores = f.Cores
+	} else if req.Cores != f.Cores {
+		return fmt.Errorf("'Cores' (%d) does not match flavor '%s' (%d)", req.Cores, f.Name, f.Cores), 400
+	}
+
+	if req.Memory == 0 {
+		req.Memory = f.Memory
+	} else if req.Memory != f.Memory {
+		return fmt.Errorf("'Memory' (%d MiB) does not match flavor '%s' (%d MiB)", req.Memory, f.Name, f.Memory), 400
+	}
+
+	// The disk may have been created larger than the flavor, from the size of the image
+	if req.Disk == 0 {
+		req.Disk = f.Disk
+	}
+
+	if !throttled(req.DiskThrottle) {
+		req.DiskThrottle = f.DiskThrottle
+	} else if req.DiskThrottle != f.DiskThrottle {
+		return fmt.Errorf("'DiskThrottle' does not match flavor '%s'", f.Name), 400
+	}
+
+	if flavorLimited(f) && len(req.Image) > 0 {
+		img, err := DBImageGet(req.Image)
+		if err != nil {
+			return err, 500
+		}
+
+		if img.Type == shared.BackendLXC {
+			return fmt.Errorf("The CPU limits of flavor '%s' are not supported for LXC machines", f.Name), 400
+		}
+	}
+
+	return nil, 200
+}
+
+// flavorKvmOpts returns the specified KVM options of a machine moving
+// from a flavor to another one (empty when the machine had none), with
+// the CPU limits of the new flavor applied to them. Without CPU limits in
+// the new flavor, only the limits set by the previous one are removed
+func flavorKvmOpts(opts shared.KvmOptsDef, from, to shared.FlavorDef) shared.KvmOptsDef {
+	if flavorLimited(to) {
+		opts.Limits.CpuQuota = to.CpuQuota
+		opts.Limits.CpuWeight = to.CpuWeight
+	} else if flavorLimited(from) && opts.Limits.CpuQuota == from.CpuQuota && opts.Limits.CpuWeight == from.CpuWeight {
+		opts.Limits.CpuQuota = 0
+		opts.Limits.CpuWeight = 0
+	}
+
+	return opts
+}
+
+// MachineApplyFlavorLimits saves the CPU limits of the flavor of the
+// specified machine in its KVM options, applied when it starts
+func MachineApplyFlavorLimits(def shared.MachineDef) error {
+	if len(def.Flavor) == 0 {
+		return nil
+	}
+
+	f, err := DBFlavorGet(def.Flavor)
+	if err != nil {
+		return err
+	}
+
+	opts, err := DBMachineGetKvmOpts(def.ID)
+	if err != nil {
+		return err
+	}
+
+	return DBMachineSetKvmOpts(def.ID, flavorKvmOpts(opts, shared.FlavorDef{}, f))
+}
@@ -0,0 +1,18 @@
+package server
+
+// HandlerAdmin - All the host administration handlers
+
+import (
+	"net/http"
+)
+
+// GET /admin/reconcile
+func HandleAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	report, err := Reconcile()
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, report)
+}
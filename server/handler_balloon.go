@@ -0,0 +1,91 @@
+package server
+
+// HandlerBalloon - Memory balloon of running machines
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// GET /machines/<id>/balloon
+func HandleMachineGetBalloon(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpBalloon)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	def, err := MachineBalloon(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, def)
+}
+
+// POST /machines/<id>/balloon
+func HandleMachineSetBalloon(w http.ResponseWriter, r *http.Request) {
+	var req shared.BalloonDef
+
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpBalloon)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, 400)
+		return
+	}
+
+	def, err := MachineBalloon(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if req.Target < def.Min || req.Target > def.Max {
+		ErrorResponse(w, r, fmt.Errorf("'Target' must be between %d and %d MiB", def.Min, def.Max), 400)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.SetBalloon(id, req.Target)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	// The guest may take some time to reach the target
+	def.Target = req.Target
+
+	SuccessResponse(w, r, def)
+}
@@ -28,13 +28,30 @@ func HandleCheckpointCreate(w http.ResponseWriter, r *http.Request) {
 	v := mux.Vars(r)
 	machine := v["id"]
 
-	err := json.NewDecoder(r.Body).Decode(&req)
+	if !DBMachineExists(machine) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(machine, OpCheckpoint)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		ErrorResponse(w, r, err, 400)
 		return
 	}
 
-	err, status := validateCheckpoint(req)
+	err, status = validateCheckpoint(req)
 	if err != nil {
 		ErrorResponse(w, r, err, status)
 		return
@@ -42,7 +59,7 @@ func HandleCheckpointCreate(w http.ResponseWriter, r *http.Request) {
 
 	req.Timestamp = time.Now().Unix()
 
-	err = MachineKvmCreateCheckpoint(machine, req.Name)
+	err = b.CreateCheckpoint(machine, req.Name)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
@@ -56,7 +73,18 @@ func HandleCheckpointList(w http.ResponseWriter, r *http.Request) {
 	v := mux.Vars(r)
 	machine := v["id"]
 
-	chks, err := MachineKvmListCheckpoints(machine)
+	if !DBMachineExists(machine) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	b, err := MachineBackendByID(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	chks, err := b.ListCheckpoints(machine)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
@@ -71,7 +99,24 @@ func HandleCheckpointRestore(w http.ResponseWriter, r *http.Request) {
 	machine := v["id"]
 	name := v["name"]
 
-	err := MachineKvmRestoreCheckpoint(machine, name)
+	if !DBMachineExists(machine) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(machine, OpCheckpoint)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.RestoreCheckpoint(machine, name)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
@@ -86,7 +131,18 @@ func HandleCheckpointDelete(w http.ResponseWriter, r *http.Request) {
 	machine := v["id"]
 	name := v["name"]
 
-	err := MachineKvmDeleteCheckpoint(machine, name)
+	if !DBMachineExists(machine) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	b, err := MachineBackendByID(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.DeleteCheckpoint(machine, name)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
@@ -0,0 +1,106 @@
+package server
+
+// HandlerConsole - All the serial console handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/quadrifoglio/wir/utils"
+)
+
+var consoleUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// GET /machines/<id>/console/log
+func HandleMachineConsoleLog(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	for _, path := range utils.RotatedFiles(MachineConsoleLog(id), ConsoleLogCount) {
+		f, err := os.Open(path)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+
+		_, err = io.Copy(w, f)
+		f.Close()
+
+		if err != nil {
+			log.Printf("%s %s from %s - %s\n", r.Method, r.URL, r.RemoteAddr, err)
+			return
+		}
+	}
+
+	log.Printf("%s %s from %s - 200 OK\n", r.Method, r.URL, r.RemoteAddr)
+}
+
+// GET /machines/<id>/console
+// Websocket: the output of the serial console is sent as binary
+// messages, and the messages received are written to it
+func HandleMachineConsole(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	c, err := MachineConsole(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 409)
+		return
+	}
+
+	ws, err := consoleUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("%s %s from %s - %s\n", r.Method, r.URL, r.RemoteAddr, err)
+		return
+	}
+
+	log.Printf("%s %s from %s - Console attached\n", r.Method, r.URL, r.RemoteAddr)
+
+	ch := c.Subscribe()
+
+	go func() {
+		defer c.Unsubscribe(ch)
+
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			_, err = c.Write(data)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for data := range ch {
+		err := ws.WriteMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			c.Unsubscribe(ch)
+			break
+		}
+	}
+
+	ws.Close()
+	log.Printf("%s %s from %s - Console detached\n", r.Method, r.URL, r.RemoteAddr)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// POST /flavors
+func HandleFlavorCreate(w http.ResponseWriter, r *http.Request) {
+	var req shared.FlavorDef
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, 400)
+		return
+	}
+
+	err, status := validateFlavor(req)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if DBFlavorExists(req.Name) {
+		ErrorResponse(w, r, fmt.Errorf("Flavor already exists"), 400)
+		return
+	}
+
+	err = DBFlavorCreate(req)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, req)
+}
+
+// GET /flavors
+func HandleFlavorList(w http.ResponseWriter, r *http.Request) {
+	flavors, err := DBFlavorList()
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, flavors)
+}
+
+// GET /flavors/<name>
+func HandleFlavorGet(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	name := v["name"]
+
+	if !DBFlavorExists(name) {
+		ErrorResponse(w, r, fmt.Errorf("Flavor not found"), 404)
+		return
+	}
+
+	flavor, err := DBFlavorGet(name)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, flavor)
+}
+
+// POST /flavors/<name>
+func HandleFlavorUpdate(w http.ResponseWriter, r *http.Request) {
+	var req shared.FlavorDef
+
+	v := mux.Vars(r)
+	name := v["name"]
+
+	if !DBFlavorExists(name) {
+		ErrorResponse(w, r, fmt.Errorf("Flavor not found"), 404)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, 400)
+		return
+	}
+
+	req.Name = name
+
+	err, status := validateFlavor(req)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	// The machines sized from the flavor must keep matching it
+	machines, err := DBFlavorMachines(name)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if len(machines) > 0 {
+		ErrorResponse(w, r, fmt.Errorf("Flavor is used by %d machine(s), which must be resized to another flavor first", len(machines)), 409)
+		return
+	}
+
+	err = DBFlavorUpdate(req)
+	if err != nil {
+		ErrorResponse(w, r, err, 404)
+		return
+	}
+
+	SuccessResponse(w, r, req)
+}
+
+// DELETE /flavors/<name>
+func HandleFlavorDelete(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	name := v["name"]
+
+	if !DBFlavorExists(name) {
+		ErrorResponse(w, r, fmt.Errorf("Flavor not found"), 404)
+		return
+	}
+
+	machines, err := DBFlavorMachines(name)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if len(machines) > 0 {
+		ErrorResponse(w, r, fmt.Errorf("Flavor is used by %d machine(s), which must be resized to another flavor first", len(machines)), 409)
+		return
+	}
+
+	err = DBFlavorDelete(name)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
@@ -0,0 +1,322 @@
+package server
+
+// HandlerHotplug - Attachment of devices to existing machines
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// validateVolumeHotplug checks if volumes can be attached to or detached
+// from the specified running machine, and returns the coresponding
+// http status code. KVM machines only hot-plug volumes on the SCSI bus
+func validateVolumeHotplug(def shared.MachineDef) (error, int) {
+	typ, err := MachineType(def)
+	if err != nil {
+		return err, 500
+	}
+
+	if typ != shared.BackendKVM {
+		return nil, 200
+	}
+
+	opts, err := DBMachineGetKvmOpts(def.ID)
+	if err != nil {
+		return err, 500
+	}
+
+	if opts.VolumeBus != shared.VolumeBusSCSI {
+		return fmt.Errorf("Volumes can only be attached to or detached from a running machine on the 'scsi' volume bus (KVM options)"), 409
+	}
+
+	return nil, 200
+}
+
+// POST /machines/<id>/volumes/<vol>/attach
+func HandleMachineVolumeAttach(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+	vol := v["vol"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+	if !DBVolumeExists(vol) {
+		ErrorResponse(w, r, fmt.Errorf("Volume not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpAttach)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	other, err := DBVolumeAttachedTo(vol)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if other == id {
+		ErrorResponse(w, r, fmt.Errorf("Volume already attached to the machine"), 409)
+		return
+	}
+	if len(other) > 0 {
+		ErrorResponse(w, r, fmt.Errorf("Volume attached to machine %s", other), 409)
+		return
+	}
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if err, status := validateMachineVolume(machine, vol); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackend(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	// Stopped machines get their volumes when they start
+	if b.IsRunning(id) {
+		if err, status := validateVolumeHotplug(machine); err != nil {
+			ErrorResponse(w, r, err, status)
+			return
+		}
+
+		err := b.AttachVolume(id, vol)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+	}
+
+	machine.Volumes = append(machine.Volumes, vol)
+
+	err = DBMachineSetVolumes(machine)
+	if err != nil {
+		// The database must reflect the devices plugged into the hypervisor
+		if b.IsRunning(id) {
+			if err := b.DetachVolume(id, vol); err != nil {
+				log.Printf("Not fatal - Machine %s - Failed to unplug volume %s: %s\n", id, vol, err)
+			}
+		}
+
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, machine)
+}
+
+// POST /machines/<id>/volumes/<vol>/detach
+func HandleMachineVolumeDetach(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+	vol := v["vol"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpDetach)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if !utils.SliceContainsStr(vol, machine.Volumes) {
+		ErrorResponse(w, r, fmt.Errorf("Volume not attached to the machine"), 404)
+		return
+	}
+
+	b, err := MachineBackend(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if b.IsRunning(id) {
+		if err, status := validateVolumeHotplug(machine); err != nil {
+			ErrorResponse(w, r, err, status)
+			return
+		}
+
+		err := b.DetachVolume(id, vol)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+	}
+
+	for i, v := range machine.Volumes {
+		if v == vol {
+			machine.Volumes = append(machine.Volumes[:i], machine.Volumes[i+1:]...)
+			break
+		}
+	}
+
+	err = DBMachineSetVolumes(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, machine)
+}
+
+// POST /machines/<id>/interfaces
+func HandleMachineInterfaceAdd(w http.ResponseWriter, r *http.Request) {
+	var req shared.InterfaceDef
+
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpAttach)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, 400)
+		return
+	}
+
+	err, status = validateInterface(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if !DBIsMACFree(req.MAC) {
+		ErrorResponse(w, r, fmt.Errorf("MAC address is already in use"), 400)
+		return
+	}
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	b, err := MachineBackend(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	// Stopped machines get their interfaces when they start
+	if b.IsRunning(id) {
+		err := b.AttachInterface(id, len(machine.Interfaces), req)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+	}
+
+	machine.Interfaces = append(machine.Interfaces, req)
+
+	err = DBMachineSetInterfaces(machine)
+	if err != nil {
+		// The database must reflect the devices plugged into the hypervisor
+		if b.IsRunning(id) {
+			if err := b.DetachInterface(id, len(machine.Interfaces)-1); err != nil {
+				log.Printf("Not fatal - Machine %s - Failed to unplug interface: %s\n", id, err)
+			}
+		}
+
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, machine)
+}
+
+// DELETE /machines/<id>/interfaces/<index>
+func HandleMachineInterfaceRemove(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpDetach)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	n, err := strconv.Atoi(v["index"])
+	if err != nil || n < 0 || n >= len(machine.Interfaces) {
+		ErrorResponse(w, r, fmt.Errorf("Interface not found"), 404)
+		return
+	}
+
+	b, err := MachineBackend(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if b.IsRunning(id) {
+		// The host interfaces of a running machine are named after their index
+		if n != len(machine.Interfaces)-1 {
+			ErrorResponse(w, r, fmt.Errorf("Only the last interface can be removed from a running machine"), 409)
+			return
+		}
+
+		err := b.DetachInterface(id, n)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+	}
+
+	machine.Interfaces = append(machine.Interfaces[:n], machine.Interfaces[n+1:]...)
+
+	err = DBMachineSetInterfaces(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, machine)
+}
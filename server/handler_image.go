@@ -53,23 +53,28 @@ func HandleImageCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	dst := ImageFile(req.ID)
+	job := StartJob(JobImageCreate, func(j *Job) (string, error) {
+		dst := ImageFile(req.ID)
 
-	err = system.FetchURL(req.Source, dst)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
-	}
+		j.Step(fmt.Sprintf("Fetching %s", req.Source))
 
-	req.Source = dst
+		err := system.FetchURL(j.Context(), req.Source, dst, j.Progress())
+		if err != nil {
+			os.Remove(dst)
+			return "", err
+		}
 
-	err = DBImageCreate(req)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
-	}
+		req.Source = dst
 
-	SuccessResponse(w, r, req)
+		err = DBImageCreate(req)
+		if err != nil {
+			return "", err
+		}
+
+		return req.ID, nil
+	})
+
+	SuccessResponse(w, r, job)
 }
 
 // GET /images
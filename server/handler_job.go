@@ -0,0 +1,45 @@
+package server
+
+// HandlerJob - All the job-related handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// GET /jobs
+func HandleJobList(w http.ResponseWriter, r *http.Request) {
+	SuccessResponse(w, r, ListJobs())
+}
+
+// GET /jobs/<id>
+func HandleJobGet(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+
+	j, err := GetJob(v["id"])
+	if err != nil {
+		ErrorResponse(w, r, err, 404)
+		return
+	}
+
+	SuccessResponse(w, r, j.Def())
+}
+
+// GET /jobs/<id>/cancel
+func HandleJobCancel(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+
+	if _, err := GetJob(v["id"]); err != nil {
+		ErrorResponse(w, r, err, 404)
+		return
+	}
+
+	def, err := CancelJob(v["id"])
+	if err != nil {
+		ErrorResponse(w, r, err, 409)
+		return
+	}
+
+	SuccessResponse(w, r, def)
+}
@@ -8,11 +8,11 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 
 	"github.com/quadrifoglio/wir/shared"
-	"github.com/quadrifoglio/wir/system"
 	"github.com/quadrifoglio/wir/utils"
 )
 
@@ -27,10 +27,30 @@ func validateMachine(req *shared.MachineDef) (error, int) {
 		if !DBImageExists(req.Image) {
 			return fmt.Errorf("Image not found"), 404
 		}
-	} else if req.Disk == 0 {
+	}
+
+	if err, status := expandFlavor(req); err != nil {
+		return err, status
+	}
+
+	if len(req.Image) == 0 && req.Disk == 0 {
 		return fmt.Errorf("Specify an 'Image' and/or a 'Disk' size"), 400
 	}
 
+	if err, status := validateThrottle("DiskThrottle", req.DiskThrottle); err != nil {
+		return err, status
+	}
+	if throttled(req.DiskThrottle) && len(req.Image) > 0 {
+		img, err := DBImageGet(req.Image)
+		if err != nil {
+			return err, 500
+		}
+
+		if img.Type == shared.BackendLXC {
+			return fmt.Errorf("'DiskThrottle' is not supported for LXC machines"), 400
+		}
+	}
+
 	if req.Cores == 0 {
 		return fmt.Errorf("'Cores' can't be 0"), 400
 	}
@@ -38,59 +58,139 @@ func validateMachine(req *shared.MachineDef) (error, int) {
 		return fmt.Errorf("'Memory' can't be 0"), 400
 	}
 
+	switch req.RestartPolicy {
+	case "":
+		req.RestartPolicy = shared.RestartNever
+	case shared.RestartNever, shared.RestartOnBoot, shared.RestartAlways, shared.RestartOnFailure:
+		break
+	default:
+		return fmt.Errorf("Invalid 'RestartPolicy' (must be never, on-boot, always, on-failure)"), 400
+	}
+
 	for _, v := range req.Volumes {
-		if !DBVolumeExists(v) {
-			return fmt.Errorf("Volume '%s' not found", v), 404
+		if err, status := validateMachineVolume(*req, v); err != nil {
+			return err, status
 		}
 	}
 
-	for i, iface := range req.Interfaces {
-		if len(iface.Network) == 0 {
-			return fmt.Errorf("Missing 'Network' for interface"), 400
-		}
-		if !DBNetworkExists(iface.Network) {
-			return fmt.Errorf("Network '%s' not found", iface.Network), 404
+	for i := range req.Interfaces {
+		if err, status := validateInterface(&req.Interfaces[i]); err != nil {
+			return err, status
 		}
+	}
+
+	return nil, 200
+}
+
+// validateMachineVolume checks that the specified volume exists and
+// can be used by the machine, and returns the coresponding http status code
+func validateMachineVolume(def shared.MachineDef, vol string) (error, int) {
+	if !DBVolumeExists(vol) {
+		return fmt.Errorf("Volume '%s' not found", vol), 404
+	}
+
+	v, err := DBVolumeGet(vol)
+	if err != nil {
+		return err, 500
+	}
+
+	typ, err := MachineType(def)
+	if err != nil {
+		return err, 500
+	}
+
+	// Volumes are qcow2 files for KVM and folders for LXC
+	if v.Type != typ {
+		return fmt.Errorf("Volume '%s' is a %s volume, it can't be used by a %s machine", vol, v.Type, typ), 400
+	}
+
+	return nil, 200
+}
+
+// validateInterface validates the specified network interface definition,
+// generating its MAC address and IP lease if need be, and returns the
+// coresponding http status code
+func validateInterface(iface *shared.InterfaceDef) (error, int) {
+	if len(iface.Network) == 0 {
+		return fmt.Errorf("Missing 'Network' for interface"), 400
+	}
+	if !DBNetworkExists(iface.Network) {
+		return fmt.Errorf("Network '%s' not found", iface.Network), 404
+	}
+
+	if iface.Bandwidth.IngressBurst > 0 && iface.Bandwidth.IngressRate == 0 {
+		return fmt.Errorf("'Bandwidth.IngressBurst' requires 'Bandwidth.IngressRate' for interface"), 400
+	}
+	if iface.Bandwidth.EgressBurst > 0 && iface.Bandwidth.EgressRate == 0 {
+		return fmt.Errorf("'Bandwidth.EgressBurst' requires 'Bandwidth.EgressRate' for interface"), 400
+	}
 
-		if len(iface.MAC) > 0 {
-			_, err := net.ParseMAC(iface.MAC)
+	if len(iface.MAC) > 0 {
+		_, err := net.ParseMAC(iface.MAC)
+		if err != nil {
+			return fmt.Errorf("Invalid 'MAC' for interface"), 400
+		}
+	} else {
+		for {
+			mac, err := utils.RandMAC(GlobalNodeID)
 			if err != nil {
-				return fmt.Errorf("Invalid 'MAC' for interface"), 400
+				return err, 500
 			}
-		} else {
-			for {
-				mac, err := utils.RandMAC(GlobalNodeID)
-				if err != nil {
-					return err, 500
-				}
-
-				req.Interfaces[i].MAC = mac
-
-				if DBIsMACFree(mac) {
-					break
-				}
+
+			iface.MAC = mac
+
+			if DBIsMACFree(mac) {
+				break
 			}
 		}
+	}
 
-		if len(iface.IP) > 0 {
-			ip := net.ParseIP(iface.IP)
-			if ip == nil {
-				return fmt.Errorf("Invalid 'IP' for interface"), 400
-			}
-		} else {
-			netw, err := DBNetworkGet(iface.Network)
+	if len(iface.IP) > 0 {
+		ip := net.ParseIP(iface.IP)
+		if ip == nil {
+			return fmt.Errorf("Invalid 'IP' for interface"), 400
+		}
+	} else {
+		netw, err := DBNetworkGet(iface.Network)
+		if err != nil {
+			return fmt.Errorf("Failed to get '%s' network: %s", iface.Network, err), 500
+		}
+
+		if netw.DHCP.Enabled && len(netw.DHCP.StartIP) > 0 { // If internal DHCP is used, we should associate an IP to the VM
+			ip, err := NetworkFreeLease(netw)
 			if err != nil {
-				return fmt.Errorf("Failed to get '%s' network: %s", iface.Network, err), 500
+				return fmt.Errorf("Can't get free lease in network '%s': %s\n", netw.Name, err), 500
 			}
 
-			if netw.DHCP.Enabled && len(netw.DHCP.StartIP) > 0 { // If internal DHCP is used, we should associate an IP to the VM
-				ip, err := NetworkFreeLease(netw)
-				if err != nil {
-					return fmt.Errorf("Can't get free lease in network '%s': %s\n", netw.Name, err), 500
-				}
+			iface.IP = ip.String()
+		}
+	}
+
+	return nil, 200
+}
+
+// validateMachineUpdate checks if the current definition of the machine
+// can be replaced by the requested one, which must have been validated,
+// and returns the coresponding http status code
+func validateMachineUpdate(b Backend, current, req shared.MachineDef) (error, int) {
+	// The database must reflect the devices plugged into the hypervisor
+	if b.IsRunning(current.ID) && strings.Join(req.Volumes, ",") != strings.Join(current.Volumes, ",") {
+		return fmt.Errorf("The volumes of a running machine must be changed with the attach and detach actions"), 409
+	}
+	if b.IsRunning(current.ID) && len(req.Interfaces) != len(current.Interfaces) {
+		return fmt.Errorf("Interfaces must be added to or removed from a running machine with the interface actions"), 409
+	}
 
-				req.Interfaces[i].IP = ip.String()
-			}
+	// The CPU topology, the balloon and the memory limit of KVM machines depend on the number of cores and the memory
+	if opts, err := DBMachineGetKvmOpts(current.ID); err == nil {
+		if err, status := validateKvmCpu(req.Cores, opts); err != nil {
+			return err, status
+		}
+		if err, status := validateKvmBalloon(req.Memory, opts); err != nil {
+			return err, status
+		}
+		if err, status := validateKvmLimits(req.Memory, opts); err != nil {
+			return err, status
 		}
 	}
 
@@ -128,9 +228,9 @@ func HandleMachineCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = MachineKvmCreate(&req)
+	b, err := MachineBackend(req)
 	if err != nil {
-		ErrorResponse(w, r, err, 500)
+		ErrorResponse(w, r, err, 400)
 		return
 	}
 
@@ -140,7 +240,51 @@ func HandleMachineCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	SuccessResponse(w, r, req)
+	job := StartJob(JobMachineCreate, func(j *Job) (string, error) {
+		err := createMachine(j, b, &req)
+		if err != nil {
+			if err := b.Delete(req.ID); err != nil {
+				log.Printf("Not fatal - Machine %s - Failed to delete after failed creation: %s\n", req.ID, err)
+			}
+			if err := DBMachineDelete(req.ID); err != nil {
+				log.Printf("Not fatal - Machine %s - Failed to remove after failed creation: %s\n", req.ID, err)
+			}
+
+			return "", err
+		}
+
+		return req.ID, nil
+	})
+
+	SuccessResponse(w, r, job)
+}
+
+// createMachine creates the data of the specified machine,
+// which must be in the 'creating' state, as part of a job
+func createMachine(j *Job, b Backend, def *shared.MachineDef) error {
+	j.Step("Creating disk")
+
+	err := b.Create(def)
+	if err != nil {
+		return err
+	}
+
+	if err := j.Canceled(); err != nil {
+		return err
+	}
+
+	// The backend may have computed the disk size from the image
+	err = DBMachineSetDisk(def.ID, def.Disk)
+	if err != nil {
+		return err
+	}
+
+	err = MachineApplyFlavorLimits(*def)
+	if err != nil {
+		return err
+	}
+
+	return MachineTransition(def.ID, shared.StateStopped, "")
 }
 
 // GET /machines
@@ -185,7 +329,13 @@ func HandleMachineUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err, status := validateMachineOperation(id, OpUpdate)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		ErrorResponse(w, r, err, 400)
 		return
@@ -193,7 +343,25 @@ func HandleMachineUpdate(w http.ResponseWriter, r *http.Request) {
 
 	req.ID = id
 
-	err, status := validateMachine(&req)
+	err, status = validateMachine(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	current, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	b, err := MachineBackend(current)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err, status = validateMachineUpdate(b, current, req)
 	if err != nil {
 		ErrorResponse(w, r, err, status)
 		return
@@ -201,15 +369,25 @@ func HandleMachineUpdate(w http.ResponseWriter, r *http.Request) {
 
 	err = DBMachineUpdate(req)
 	if err != nil {
-		ErrorResponse(w, r, err, 404)
+		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	SuccessResponse(w, r, req)
+	// vCPUs, memory and I/O limits are applied live when possible,
+	// once the update is saved
+	applied, pending := MachineUpdateLive(b, current, req)
+
+	SuccessResponse(w, r, shared.MachineUpdateDef{
+		MachineDef: req,
+		Applied:    applied,
+		Pending:    pending,
+	})
 }
 
-// DELETE /machines/<id>
-func HandleMachineDelete(w http.ResponseWriter, r *http.Request) {
+// POST /machines/<id>/resize
+func HandleMachineResize(w http.ResponseWriter, r *http.Request) {
+	var req shared.MachineResizeDef
+
 	v := mux.Vars(r)
 	id := v["id"]
 
@@ -218,117 +396,190 @@ func HandleMachineDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := MachineKvmDelete(id)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		ErrorResponse(w, r, err, 500)
+		ErrorResponse(w, r, err, 400)
 		return
 	}
 
-	machine, err := DBMachineGet(id)
+	err, status := validateMachineOperation(id, OpResize)
 	if err != nil {
-		ErrorResponse(w, r, err, 500)
+		ErrorResponse(w, r, err, status)
 		return
 	}
 
-	for i, _ := range machine.Interfaces {
-		err := system.DeleteInterface(MachineNicName(id, i))
-		if err != nil {
-			log.Printf("Could not delete machine '%s' interfaces: %s\n", id, err)
-		}
-
-		err = system.EbtablesFlush(MachineNicName(id, i))
-		if err != nil {
-			log.Printf("Could not delete ebtables rules for '%s' interfaces: %s\n", id, err)
-		}
+	if len(req.Flavor) == 0 {
+		ErrorResponse(w, r, fmt.Errorf("Missing 'Flavor'"), 400)
+		return
+	}
+	if !DBFlavorExists(req.Flavor) {
+		ErrorResponse(w, r, fmt.Errorf("Flavor '%s' not found", req.Flavor), 404)
+		return
 	}
 
-	err = DBMachineDelete(id)
+	flavor, err := DBFlavorGet(req.Flavor)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	SuccessResponse(w, r, nil)
-}
-
-// GET /machines/<id>/kvm
-func HandleMachineGetKvmOpts(w http.ResponseWriter, r *http.Request) {
-	v := mux.Vars(r)
-	id := v["id"]
-
-	if !DBMachineExists(id) {
-		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+	current, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	opts, err := DBMachineGetKvmOpts(id)
+	b, err := MachineBackend(current)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	SuccessResponse(w, r, opts)
-}
-
-// POST /machines/<id>/kvm
-func HandleMachineSetKvmOpts(w http.ResponseWriter, r *http.Request) {
-	var req shared.KvmOptsDef
-
-	v := mux.Vars(r)
-	id := v["id"]
+	// The sizing of the machine is taken from the new flavor
+	def := current
+	def.Flavor = flavor.Name
+	def.Cores = 0
+	def.Memory = 0
+	def.DiskThrottle = shared.ThrottleDef{}
 
-	if !DBMachineExists(id) {
-		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+	if flavor.Disk > 0 && flavor.Disk < current.Disk {
+		ErrorResponse(w, r, fmt.Errorf("The disk of the machine (%d bytes) can't be shrunk to %d bytes", current.Disk, flavor.Disk), 400)
+		return
+	}
+	if flavor.Disk > current.Disk && b.IsRunning(id) {
+		ErrorResponse(w, r, fmt.Errorf("The disk of the machine can only be grown while it is stopped"), 409)
 		return
 	}
+	if flavor.Disk > current.Disk {
+		def.Disk = flavor.Disk
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err, status = validateMachine(&def)
 	if err != nil {
-		ErrorResponse(w, r, err, 400)
+		ErrorResponse(w, r, err, status)
 		return
 	}
 
-	req.PID = -1 // The PID should not be modified by the request
+	err, status = validateMachineUpdate(b, current, def)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
 
-	if len(req.CDRom) > 0 && !utils.FileExists(req.CDRom) {
-		ErrorResponse(w, r, fmt.Errorf("'CDRom': File not found"), 400)
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	if req.VNC.Enabled {
-		if net.ParseIP(req.VNC.Address) == nil {
-			ErrorResponse(w, r, fmt.Errorf("Invalid 'VNC.Address' IP address"), 400)
+	// Only the CPU limits set by the previous flavor are replaced
+	var previous shared.FlavorDef
+	if len(current.Flavor) > 0 && DBFlavorExists(current.Flavor) {
+		previous, err = DBFlavorGet(current.Flavor)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+	}
+
+	// The CPU limits only apply to the machines configured by KVM options
+	newOpts := opts
+	if kb, ok := b.(KvmOptsBackend); ok {
+		newOpts = flavorKvmOpts(opts, previous, flavor)
+
+		if err, status := validateKvmLimits(def.Memory, newOpts); err != nil {
+			ErrorResponse(w, r, err, status)
 			return
 		}
-		if req.VNC.Port == 0 {
-			ErrorResponse(w, r, fmt.Errorf("Invalid 'VNC.Port' number"), 400)
+		if err, status := kb.ValidateKvmOpts(newOpts); err != nil {
+			ErrorResponse(w, r, err, status)
 			return
 		}
 	}
 
-	err = MachineKvmSetOpts(id, req)
+	// Everything is saved before the disk is grown, which can't be undone
+	err = DBMachineUpdate(def)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	err = DBMachineSetKvmOpts(id, req)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
+	if newOpts.Limits != opts.Limits {
+		err = DBMachineSetKvmOpts(id, newOpts)
+		if err != nil {
+			resizeRollback(current, opts)
+			ErrorResponse(w, r, err, 500)
+			return
+		}
 	}
 
-	opts, err := DBMachineGetKvmOpts(id)
+	// The machine is stopped, nothing was applied to it yet
+	if def.Disk > current.Disk {
+		err := DBMachineSetDisk(id, def.Disk)
+		if err != nil {
+			resizeRollback(current, opts)
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+
+		err = b.GrowDisk(id, def.Disk)
+		if err != nil {
+			resizeRollback(current, opts)
+			ErrorResponse(w, r, fmt.Errorf("Failed to grow the disk: %s", err), 500)
+			return
+		}
+	}
+
+	// vCPUs, memory and I/O limits are applied live when possible,
+	// once the new sizing is saved
+	applied, pending := MachineUpdateLive(b, current, def)
+
+	// The cgroup of the machine is only set up when it starts
+	if newOpts.Limits != opts.Limits && b.IsRunning(id) {
+		pending = append(pending, "CPU limits (applied when the machine restarts)")
+	}
+
+	from := current.Flavor
+	if len(from) == 0 {
+		from = "none"
+	}
+
+	msg := fmt.Sprintf("Flavor: %s -> %s", from, def.Flavor)
+	if def.Disk > current.Disk {
+		msg += fmt.Sprintf(", disk grown from %d to %d bytes", current.Disk, def.Disk)
+	}
+
+	err = DBMachineAddEvent(id, "resize", msg)
 	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
+		log.Printf("Not fatal - Machine %s - Failed to save event: %s\n", id, err)
 	}
 
-	SuccessResponse(w, r, opts)
+	SuccessResponse(w, r, shared.MachineUpdateDef{
+		MachineDef: def,
+		Applied:    applied,
+		Pending:    pending,
+	})
 }
 
-// GET /machines/<id>/start
-func HandleMachineStart(w http.ResponseWriter, r *http.Request) {
+// resizeRollback saves the sizing and the KVM options
+// the machine had before a failed resize
+func resizeRollback(def shared.MachineDef, opts shared.KvmOptsDef) {
+	err := DBMachineSetDisk(def.ID, def.Disk)
+	if err == nil {
+		err = DBMachineUpdate(def)
+	}
+	if err == nil {
+		opts.PID = -1
+		err = DBMachineSetKvmOpts(def.ID, opts)
+	}
+
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to restore the sizing after a failed resize: %s\n", def.ID, err)
+	}
+}
+
+// DELETE /machines/<id>
+func HandleMachineDelete(w http.ResponseWriter, r *http.Request) {
 	v := mux.Vars(r)
 	id := v["id"]
 
@@ -337,26 +588,31 @@ func HandleMachineStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := MachineKvmStart(id)
+	err, status := validateMachineOperation(id, OpDelete)
 	if err != nil {
-		ErrorResponse(w, r, err, 500)
+		ErrorResponse(w, r, err, status)
 		return
 	}
 
-	SuccessResponse(w, r, nil)
-}
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
 
-// GET /machines/<id>/stop
-func HandleMachineStop(w http.ResponseWriter, r *http.Request) {
-	v := mux.Vars(r)
-	id := v["id"]
+	b, err := MachineBackend(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
 
-	if !DBMachineExists(id) {
-		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+	err = b.Delete(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	err := MachineKvmStop(id)
+	err = DBMachineDelete(id)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
@@ -365,8 +621,8 @@ func HandleMachineStop(w http.ResponseWriter, r *http.Request) {
 	SuccessResponse(w, r, nil)
 }
 
-// GET /machines/<id>/status
-func HandleMachineStatus(w http.ResponseWriter, r *http.Request) {
+// GET /machines/<id>/kvm
+func HandleMachineGetKvmOpts(w http.ResponseWriter, r *http.Request) {
 	v := mux.Vars(r)
 	id := v["id"]
 
@@ -375,17 +631,19 @@ func HandleMachineStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	def, err := MachineKvmStatus(id)
+	opts, err := DBMachineGetKvmOpts(id)
 	if err != nil {
 		ErrorResponse(w, r, err, 500)
 		return
 	}
 
-	SuccessResponse(w, r, def)
+	SuccessResponse(w, r, opts)
 }
 
-// GET /machines/<id>/disk/data
-func HandleMachineDiskData(w http.ResponseWriter, r *http.Request) {
+// POST /machines/<id>/kvm
+func HandleMachineSetKvmOpts(w http.ResponseWriter, r *http.Request) {
+	var req shared.KvmOptsDef
+
 	v := mux.Vars(r)
 	id := v["id"]
 
@@ -394,15 +652,565 @@ func HandleMachineDiskData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	f, err := os.Open(MachineDisk(id))
+	err, status := validateMachineOperation(id, OpSetOpts)
 	if err != nil {
-		ErrorResponse(w, r, err, 500)
+		ErrorResponse(w, r, err, status)
 		return
 	}
 
-	defer f.Close()
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ErrorResponse(w, r, err, 400)
+		return
+	}
 
-	// TODO: Log
+	req.PID = -1 // The PID should not be modified by the request
+
+	machine, err := DBMachineGet(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	b, err := MachineBackend(machine)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	// Only the Linux options apply to the machines of the other backends
+	kb, ok := b.(KvmOptsBackend)
+	if !ok {
+		err = b.SetLinuxOpts(id, req.Linux)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+
+		opts, err := DBMachineGetKvmOpts(id)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+
+		SuccessResponse(w, r, opts)
+		return
+	}
+
+	if len(req.CDRom) > 0 && !utils.FileExists(req.CDRom) {
+		ErrorResponse(w, r, fmt.Errorf("'CDRom': File not found"), 400)
+		return
+	}
+
+	// Without address, the VNC server is proxied by the daemon
+	if req.VNC.Enabled && len(req.VNC.Address) > 0 {
+		if net.ParseIP(req.VNC.Address) == nil {
+			ErrorResponse(w, r, fmt.Errorf("Invalid 'VNC.Address' IP address"), 400)
+			return
+		}
+		if req.VNC.Port < 0 || req.VNC.Port > 65535-VncDisplayPort || req.VNC.WebsocketPort < 0 || req.VNC.WebsocketPort > 65535 {
+			ErrorResponse(w, r, fmt.Errorf("Invalid 'VNC.Port' or 'VNC.WebsocketPort' number"), 400)
+			return
+		}
+	}
+
+	if req.VNC.VerifyClient && !req.VNC.TLS {
+		ErrorResponse(w, r, fmt.Errorf("'VNC.VerifyClient' requires 'VNC.TLS'"), 400)
+		return
+	}
+	if req.VNC.PasswordExpiry < 0 {
+		ErrorResponse(w, r, fmt.Errorf("Invalid 'VNC.PasswordExpiry'"), 400)
+		return
+	}
+	if req.VNC.PasswordExpiry > 0 && len(req.VNC.Password) == 0 {
+		ErrorResponse(w, r, fmt.Errorf("'VNC.PasswordExpiry' requires a 'VNC.Password'"), 400)
+		return
+	}
+
+	if err, status := validateKvmCpu(machine.Cores, req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if err, status := validateKvmBoot(req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if err, status := validateKvmBalloon(machine.Memory, req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if err, status := validateKvmLimits(machine.Memory, req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if err, status := kb.ValidateKvmOpts(req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if len(req.Display) > 0 && req.Display != shared.DisplayVNC && req.Display != shared.DisplaySpice {
+		ErrorResponse(w, r, fmt.Errorf("Invalid 'Display': must be 'vnc' or 'spice'"), 400)
+		return
+	}
+	if len(req.Video) > 0 && req.Video != shared.VideoStd && req.Video != shared.VideoQXL && req.Video != shared.VideoVirtio {
+		ErrorResponse(w, r, fmt.Errorf("Invalid 'Video': must be 'std', 'qxl' or 'virtio'"), 400)
+		return
+	}
+	if len(req.VolumeBus) > 0 && req.VolumeBus != shared.VolumeBusIDE && req.VolumeBus != shared.VolumeBusSCSI {
+		ErrorResponse(w, r, fmt.Errorf("Invalid 'VolumeBus': must be 'ide' or 'scsi'"), 400)
+		return
+	}
+
+	if req.Display == shared.DisplaySpice {
+		if req.VNC.Enabled {
+			ErrorResponse(w, r, fmt.Errorf("'VNC.Enabled' can not be used with the 'spice' display"), 400)
+			return
+		}
+		if net.ParseIP(req.Spice.Address) == nil {
+			ErrorResponse(w, r, fmt.Errorf("Invalid 'Spice.Address' IP address"), 400)
+			return
+		}
+		if req.Spice.Port < 0 || req.Spice.Port > 65535 || req.Spice.TLSPort < 0 || req.Spice.TLSPort > 65535 {
+			ErrorResponse(w, r, fmt.Errorf("Invalid 'Spice.Port' or 'Spice.TLSPort' number"), 400)
+			return
+		}
+		if req.Spice.Port == 0 && req.Spice.TLSPort == 0 {
+			ErrorResponse(w, r, fmt.Errorf("'Spice.Port' or 'Spice.TLSPort' must be specified"), 400)
+			return
+		}
+		if req.Spice.Port > 0 && req.Spice.Port == req.Spice.TLSPort {
+			ErrorResponse(w, r, fmt.Errorf("'Spice.Port' and 'Spice.TLSPort' must be different"), 400)
+			return
+		}
+	} else if req.Spice.Agent {
+		ErrorResponse(w, r, fmt.Errorf("'Spice.Agent' requires the 'spice' display"), 400)
+		return
+	}
+
+	previous, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	vncPortMutex.Lock()
+	defer vncPortMutex.Unlock()
+
+	if err, status := MachineVncAllocate(id, &req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if err, status := MachineSpiceCheckPorts(id, req); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	err = b.SetLinuxOpts(id, req.Linux)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = DBMachineSetKvmOpts(id, req)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	// The server certificates may not be valid for the new addresses anymore
+	if req.VNC.Address != previous.VNC.Address || req.VNC.TLS != previous.VNC.TLS {
+		os.RemoveAll(MachineVncTlsPath(id))
+	}
+	if req.Spice.Address != previous.Spice.Address || (req.Spice.TLSPort == 0) != (previous.Spice.TLSPort == 0) {
+		os.RemoveAll(MachineSpiceTlsPath(id))
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, opts)
+}
+
+// GET /machines/<id>/start
+func HandleMachineStart(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpStart)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateStarting, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.Start(id)
+	if err != nil {
+		MachineFail(id, shared.StateStopped, err)
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateRunning, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
+
+// GET /machines/<id>/stop[?force=true]
+func HandleMachineStop(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpStop)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	prev, _, err := DBMachineGetState(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateStopping, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+
+	err = b.Stop(id, force)
+	if err != nil {
+		MachineFail(id, prev, err)
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateStopped, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
+
+// GET /machines/<id>/pause
+func HandleMachinePause(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpPause)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.Pause(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StatePaused, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
+
+// GET /machines/<id>/resume
+func HandleMachineResume(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpResume)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.Resume(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateRunning, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
+
+// GET /machines/<id>/reset
+func HandleMachineReset(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpReset)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	// A paused machine stays paused, its state is left unchanged
+	err = b.Reset(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
+
+// GET /machines/<id>/reboot
+func HandleMachineReboot(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	err, status := validateMachineOperation(id, OpReboot)
+	if err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateStopping, "Rebooting")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.Stop(id, false)
+	if err != nil {
+		MachineFail(id, shared.StateRunning, err)
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateStopped, "Rebooting")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateStarting, "Rebooting")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = b.Start(id)
+	if err != nil {
+		MachineFail(id, shared.StateStopped, err)
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	err = MachineTransition(id, shared.StateRunning, "")
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, nil)
+}
+
+// GET /machines/<id>/status
+func HandleMachineStatus(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	state, reason, err := MachineState(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	def, err := b.Status(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	def.State = state
+	def.Reason = reason
+
+	def.LastExit, err = DBMachineGetExit(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, def)
+}
+
+// GET /machines/<id>/events
+func HandleMachineEvents(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	events, err := DBMachineListEvents(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, events)
+}
+
+// GET /machines/<id>/disk/data
+func HandleMachineDiskData(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	f, err := os.Open(MachineDisk(id))
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	defer f.Close()
+
+	// TODO: Log
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+}
+
+// GET /machines/<id>/nvram/data
+func HandleMachineNvramData(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	f, err := os.Open(MachineNvramPath(id))
+	if os.IsNotExist(err) {
+		ErrorResponse(w, r, fmt.Errorf("The machine has no UEFI variables"), 404)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	defer f.Close()
 
 	_, err = io.Copy(w, f)
 	if err != nil {
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+var testRouter *mux.Router
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "wird-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.SetOutput(ioutil.Discard)
+
+	err = Init(Config{
+		Database:    filepath.Join(dir, "wird.sqlite"),
+		ImagePath:   filepath.Join(dir, "images"),
+		VolumePath:  filepath.Join(dir, "volumes"),
+		MachinePath: filepath.Join(dir, "machines"),
+		Backend:     BackendFake,
+		StopTimeout: 1,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	testRouter = mux.NewRouter()
+	testRouter.HandleFunc("/jobs/{id}", HandleJobGet).Methods("GET")
+	testRouter.HandleFunc("/machines", HandleMachineCreate).Methods("POST")
+	testRouter.HandleFunc("/machines/{id}", HandleMachineGet).Methods("GET")
+	testRouter.HandleFunc("/machines/{id}", HandleMachineUpdate).Methods("POST")
+	testRouter.HandleFunc("/machines/{id}", HandleMachineDelete).Methods("DELETE")
+	testRouter.HandleFunc("/machines/{id}/kvm", HandleMachineSetKvmOpts).Methods("POST")
+	testRouter.HandleFunc("/machines/{id}/start", HandleMachineStart).Methods("GET")
+	testRouter.HandleFunc("/machines/{id}/stop", HandleMachineStop).Methods("GET")
+	testRouter.HandleFunc("/machines/{id}/pause", HandleMachinePause).Methods("GET")
+	testRouter.HandleFunc("/machines/{id}/resume", HandleMachineResume).Methods("GET")
+	testRouter.HandleFunc("/machines/{id}/reset", HandleMachineReset).Methods("GET")
+	testRouter.HandleFunc("/machines/{id}/status", HandleMachineStatus).Methods("GET")
+
+	code := m.Run()
+
+	CloseDatabase()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testRequest sends a request to the API and returns the
+// status code, decoding the response into v if it is not nil
+func testRequest(t *testing.T, method, url string, body, v interface{}) int {
+	t.Helper()
+
+	var data []byte
+
+	if body != nil {
+		var err error
+
+		data, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewReader(data)))
+
+	if v != nil && w.Code == 200 {
+		err := json.NewDecoder(w.Body).Decode(v)
+		if err != nil {
+			t.Fatalf("%s %s: %s", method, url, err)
+		}
+	}
+
+	return w.Code
+}
+
+// testExpect sends a request without body to the API
+// and checks the status code of the response
+func testExpect(t *testing.T, method, url string, status int) {
+	t.Helper()
+
+	if code := testRequest(t, method, url, nil, nil); code != status {
+		t.Fatalf("%s %s: got status %d, expected %d", method, url, code, status)
+	}
+}
+
+// testMachineCreate creates a machine and
+// waits for its creation job to be done
+func testMachineCreate(t *testing.T) string {
+	t.Helper()
+
+	var job shared.JobDef
+
+	req := shared.MachineDef{Name: "test", Cores: 1, Memory: 512, Disk: 1048576}
+	if code := testRequest(t, "POST", "/machines", req, &job); code != 200 {
+		t.Fatalf("POST /machines: got status %d", code)
+	}
+
+	for i := 0; i < 100; i++ {
+		if code := testRequest(t, "GET", "/jobs/"+job.ID, nil, &job); code != 200 {
+			t.Fatalf("GET /jobs/%s: got status %d", job.ID, code)
+		}
+
+		switch job.Status {
+		case shared.JobDone:
+			return job.Result
+		case shared.JobFailed, shared.JobCanceled:
+			t.Fatalf("Machine creation %s: %s", job.Status, job.Error)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("Machine creation timed out")
+	return ""
+}
+
+// testExpectState checks the lifecycle state of the machine
+func testExpectState(t *testing.T, id, state string) {
+	t.Helper()
+
+	var status shared.MachineStatusDef
+
+	if code := testRequest(t, "GET", "/machines/"+id+"/status", nil, &status); code != 200 {
+		t.Fatalf("GET /machines/%s/status: got status %d", id, code)
+	}
+	if status.State != state {
+		t.Fatalf("Machine is %s, expected %s", status.State, state)
+	}
+}
+
+func TestMachineLifecycle(t *testing.T) {
+	id := testMachineCreate(t)
+	url := "/machines/" + id
+	testExpectState(t, id, shared.StateStopped)
+
+	testExpect(t, "GET", url+"/pause", 409)
+	testExpect(t, "GET", url+"/stop", 409)
+	testExpect(t, "GET", url+"/reset", 409)
+
+	testExpect(t, "GET", url+"/start", 200)
+	testExpectState(t, id, shared.StateRunning)
+	testExpect(t, "GET", url+"/start", 409)
+	testExpect(t, "DELETE", url, 409)
+
+	testExpect(t, "GET", url+"/pause", 200)
+	testExpectState(t, id, shared.StatePaused)
+
+	// A paused machine stays paused when it is reset
+	testExpect(t, "GET", url+"/reset", 200)
+	testExpectState(t, id, shared.StatePaused)
+
+	testExpect(t, "GET", url+"/resume", 200)
+	testExpectState(t, id, shared.StateRunning)
+	testExpect(t, "GET", url+"/resume", 409)
+
+	testExpect(t, "GET", url+"/reset", 200)
+	testExpectState(t, id, shared.StateRunning)
+
+	testExpect(t, "GET", url+"/stop", 200)
+	testExpectState(t, id, shared.StateStopped)
+
+	testExpect(t, "DELETE", url, 200)
+	testExpect(t, "GET", url, 404)
+}
+
+func TestMachineUpdateState(t *testing.T) {
+	id := testMachineCreate(t)
+	url := "/machines/" + id
+
+	var def shared.MachineDef
+	if code := testRequest(t, "GET", url, nil, &def); code != 200 {
+		t.Fatalf("GET %s: got status %d", url, code)
+	}
+
+	def.Name = "updated"
+
+	for _, state := range []string{shared.StateCreating, shared.StateStarting, shared.StateStopping, shared.StateMigrating} {
+		err := DBMachineSetState(id, state, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code := testRequest(t, "POST", url, def, nil); code != 409 {
+			t.Errorf("Update of a %s machine: got status %d, expected 409", state, code)
+		}
+	}
+
+	err := DBMachineSetState(id, shared.StateStopped, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := testRequest(t, "POST", url, def, nil); code != 200 {
+		t.Fatalf("Update of a stopped machine: got status %d", code)
+	}
+
+	testExpect(t, "GET", url+"/start", 200)
+
+	def.Name = "running"
+	if code := testRequest(t, "POST", url, def, nil); code != 200 {
+		t.Fatalf("Update of a running machine: got status %d", code)
+	}
+
+	testExpect(t, "GET", url+"/stop", 200)
+	testExpect(t, "DELETE", url, 200)
+}
+
+func TestMachineSetKvmOptsState(t *testing.T) {
+	id := testMachineCreate(t)
+	url := "/machines/" + id
+
+	// The host checks are left to the backend, the fake one supporting everything
+	var opts shared.KvmOptsDef
+	opts.CPU.Nested = true
+	opts.Boot.Firmware = shared.FirmwareUEFI
+	opts.Limits.CpuQuota = 50
+
+	if code := testRequest(t, "POST", url+"/kvm", opts, nil); code != 200 {
+		t.Fatalf("POST %s/kvm: got status %d", url, code)
+	}
+
+	testExpect(t, "GET", url+"/start", 200)
+
+	if code := testRequest(t, "POST", url+"/kvm", opts, nil); code != 409 {
+		t.Fatalf("Options of a running machine: got status %d, expected 409", code)
+	}
+
+	testExpect(t, "GET", url+"/stop", 200)
+	testExpect(t, "DELETE", url, 200)
+}
@@ -43,44 +43,49 @@ func HandleMachineFetch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the remote machine & image information
-	m, err := client.MachineGet(req.Remote, req.ID)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
-	}
+	job := StartJob(JobMachineFetch, func(j *Job) (string, error) {
+		// Get the remote machine & image information
+		m, err := client.MachineGet(req.Remote, req.ID)
+		if err != nil {
+			return "", err
+		}
 
-	img, err := client.ImageGet(req.Remote, m.Image)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
-	}
+		// Fetch the remote image, if not already on this host
+		if len(m.Image) > 0 {
+			img, err := client.ImageGet(req.Remote, m.Image)
+			if err != nil {
+				return "", err
+			}
+
+			err = fetchImage(j, req.Remote, &img)
+			if err != nil {
+				return "", err
+			}
+		}
 
-	// Fetch the remote image, if not already on this host
-	err = fetchImage(req.Remote, &img)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
-	}
+		// Fetch the remote machine
+		err = fetchMachine(j, req.Remote, &m)
+		if err != nil {
+			return "", err
+		}
 
-	// Fetch the remote machine
-	err = fetchMachine(req.Remote, &m)
-	if err != nil {
-		ErrorResponse(w, r, err, 500)
-		return
-	}
+		// If specified, delete the remote machine after fetching
+		if !req.KeepRemote {
+			j.Step("Deleting remote machine")
 
-	// If specified, delete the remote machine after fetching
-	if !req.KeepRemote {
-		err := client.MachineDelete(req.Remote, req.ID)
-		if err != nil {
-			ErrorResponse(w, r, err, 500)
-			return
+			err := client.MachineDelete(req.Remote, req.ID)
+			if err != nil {
+				return m.ID, err
+			}
 		}
-	}
+
+		return m.ID, nil
+	})
+
+	SuccessResponse(w, r, job)
 }
 
-func fetchImage(r shared.RemoteDef, img *shared.ImageDef) error {
+func fetchImage(j *Job, r shared.RemoteDef, img *shared.ImageDef) error {
 	if DBImageExists(img.ID) {
 		return nil
 	}
@@ -93,8 +98,11 @@ func fetchImage(r shared.RemoteDef, img *shared.ImageDef) error {
 		return err
 	}
 
-	err = system.DownloadHttp(fmt.Sprintf("http://%s:%d/images/%s/data", r.Host, r.Port, img.ID), newSource)
+	j.Step(fmt.Sprintf("Downloading image %s", img.ID))
+
+	err = system.DownloadHttp(j.Context(), fmt.Sprintf("http://%s:%d/images/%s/data", r.Host, r.Port, img.ID), newSource, j.Progress())
 	if err != nil {
+		os.Remove(newSource)
 		return err
 	}
 
@@ -109,7 +117,7 @@ func fetchImage(r shared.RemoteDef, img *shared.ImageDef) error {
 	return nil
 }
 
-func fetchMachine(r shared.RemoteDef, m *shared.MachineDef) error {
+func fetchMachine(j *Job, r shared.RemoteDef, m *shared.MachineDef) error {
 	var live bool // Will be true if this is a live migration
 
 	status, err := client.MachineStatus(r, m.ID)
@@ -142,11 +150,31 @@ func fetchMachine(r shared.RemoteDef, m *shared.MachineDef) error {
 		return err
 	}
 
-	err = system.DownloadHttp(fmt.Sprintf("http://%s:%d/machines/%s/disk/data", r.Host, r.Port, m.ID), newDisk)
+	j.Step(fmt.Sprintf("Downloading disk of machine %s", m.ID))
+
+	err = system.DownloadHttp(j.Context(), fmt.Sprintf("http://%s:%d/machines/%s/disk/data", r.Host, r.Port, m.ID), newDisk, j.Progress())
 	if err != nil {
+		os.RemoveAll(MachinePath(m.ID))
 		return err
 	}
 
+	// Download the UEFI variables. They only exist if the machine was
+	// started once, otherwise they are created when it first starts
+	if opts.Boot.Firmware == shared.FirmwareUEFI {
+		j.Step(fmt.Sprintf("Downloading UEFI variables of machine %s", m.ID))
+
+		err = system.DownloadHttp(j.Context(), fmt.Sprintf("http://%s:%d/machines/%s/nvram/data", r.Host, r.Port, m.ID), MachineNvramPath(m.ID), j.Progress())
+		if err != nil {
+			os.Remove(MachineNvramPath(m.ID))
+
+			// The checkpoint of a live migration includes the variables
+			if live {
+				os.RemoveAll(MachinePath(m.ID))
+				return err
+			}
+		}
+	}
+
 	// TODO: Migrate volumes
 
 	// Delete invalid network interfaces
@@ -156,8 +184,20 @@ func fetchMachine(r shared.RemoteDef, m *shared.MachineDef) error {
 		}
 	}
 
+	// The machine keeps its sizing, without a flavor unknown to this node
+	if len(m.Flavor) > 0 && !DBFlavorExists(m.Flavor) {
+		m.Flavor = ""
+	}
+
 	// Create local machine
-	err = MachineKvmCreate(m)
+	j.Step("Creating machine")
+
+	b, err := MachineBackend(*m)
+	if err != nil {
+		return err
+	}
+
+	err = b.Create(m)
 	if err != nil {
 		return err
 	}
@@ -167,35 +207,118 @@ func fetchMachine(r shared.RemoteDef, m *shared.MachineDef) error {
 		return err
 	}
 
-	// Migrate KVM options
-	err = MachineKvmSetOpts(m.ID, opts)
+	err = MachineTransition(m.ID, shared.StateMigrating, fmt.Sprintf("Fetching from %s:%d", r.Host, r.Port))
 	if err != nil {
 		return err
 	}
 
-	err = DBMachineSetKvmOpts(m.ID, opts)
+	err = fetchMachineState(r, b, *m, opts, status.Layout, live)
+	if err != nil {
+		MachineFail(m.ID, shared.StateStopped, err)
+		return err
+	}
+
+	if live {
+		return MachineTransition(m.ID, shared.StateRunning, "")
+	}
+
+	return MachineTransition(m.ID, shared.StateStopped, "")
+}
+
+// fetchMachineState applies the options of the fetched machine and,
+// if it is a live migration, moves its execution to this host
+func fetchMachineState(r shared.RemoteDef, b Backend, def shared.MachineDef, opts shared.KvmOptsDef, layout *shared.MachineLayoutDef, live bool) error {
+	id := def.ID
+
+	// Migrate KVM options, with VNC ports free on this host
+	opts.VNC.Port = 0
+	opts.VNC.WebsocketPort = 0
+
+	vncPortMutex.Lock()
+
+	err, _ := MachineVncAllocate(id, &opts)
+	if err == nil {
+		err, _ = MachineSpiceCheckPorts(id, opts)
+	}
+	if err == nil {
+		err = b.SetLinuxOpts(id, opts.Linux)
+	}
+	if err == nil {
+		err = DBMachineSetKvmOpts(id, opts)
+	}
+
+	vncPortMutex.Unlock()
+
 	if err != nil {
 		return err
 	}
 
 	// If it is a live migration, restore the created '_migration' checkpoint and delete it
 	if live {
-		err := client.MachineStop(r, m.ID)
+		err := client.MachineStop(r, id, true)
+		if err != nil {
+			return err
+		}
+
+		err = fetchMachineStart(b, def, layout)
 		if err != nil {
 			return err
 		}
 
-		err = MachineKvmStart(m.ID)
+		err = b.RestoreCheckpoint(id, "_migration")
 		if err != nil {
 			return err
 		}
 
-		err = MachineKvmRestoreCheckpoint(m.ID, "_migration")
+		err = b.DeleteCheckpoint(id, "_migration")
 		if err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// fetchMachineStart starts the fetched machine with the vCPUs and memory
+// it was started with on the distant node, then plugs the ones hot-added
+// there again, in the same order, for its checkpoint to be restorable
+func fetchMachineStart(b Backend, def shared.MachineDef, layout *shared.MachineLayoutDef) error {
+	if layout == nil {
+		return b.Start(def.ID)
+	}
+
+	boot := def
+	boot.Cores = layout.Cores
+	boot.Memory = layout.Memory
+
+	err := DBMachineUpdate(boot)
+	if err != nil {
+		return err
+	}
+
+	err = b.Start(def.ID)
+
+	// The machine keeps its sizing, whether it started or not
+	if err := DBMachineUpdate(def); err != nil {
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if layout.AddedCores > 0 {
+		err := b.HotAddCores(def.ID, layout.Cores+layout.AddedCores)
+		if err != nil {
+			return err
+		}
+	}
+
+	memory := layout.Memory
+	for _, size := range layout.Dimms {
+		memory += size
 
-		err = MachineKvmDeleteCheckpoint(m.ID, "_migration")
+		err := b.HotAddMemory(def.ID, memory)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,220 @@
+package server
+
+// HandlerVnc - All the remote display (VNC proxy, SPICE) handlers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+var vncUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	Subprotocols:    []string{"binary"}, // Requested by noVNC
+
+	// Access is controlled by the tokens
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// validateVncProxy checks that the specified machine has a
+// proxied VNC server and returns the coresponding http status code
+func validateVncProxy(id string) (error, int) {
+	if !DBMachineExists(id) {
+		return fmt.Errorf("Machine not found"), 404
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		return err, 500
+	}
+
+	if !opts.VNC.Enabled || len(opts.VNC.Address) > 0 {
+		return fmt.Errorf("The VNC server of the machine is not proxied"), 409
+	}
+
+	return nil, 200
+}
+
+// validateVncToken checks that tokens can be issued for the specified
+// machine, whose VNC server must either be proxied or use TLS (client
+// certificates), and returns the coresponding http status code
+func validateVncToken(id string) (error, int) {
+	if !DBMachineExists(id) {
+		return fmt.Errorf("Machine not found"), 404
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		return err, 500
+	}
+
+	if !opts.VNC.Enabled {
+		return fmt.Errorf("The machine has no VNC server"), 409
+	}
+
+	if len(opts.VNC.Address) > 0 && !opts.VNC.TLS {
+		return fmt.Errorf("The VNC server of the machine is neither proxied nor using TLS"), 409
+	}
+
+	return nil, 200
+}
+
+// GET /machines/<id>/vnc/token
+func HandleMachineVncToken(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if err, status := validateVncToken(id); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	token, err := NewVncToken(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, token)
+}
+
+// GET /machines/<id>/vnc/certs[?token=<token>]
+func HandleMachineVncCerts(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if !opts.VNC.Enabled || !opts.VNC.TLS {
+		ErrorResponse(w, r, fmt.Errorf("The VNC server of the machine does not use TLS"), 409)
+		return
+	}
+
+	// The private key of a client certificate grants access to the VNC server
+	if opts.VNC.VerifyClient && !UseVncToken(r.URL.Query().Get("token"), id) {
+		ErrorResponse(w, r, fmt.Errorf("Invalid or expired token"), 401)
+		return
+	}
+
+	certs, err := MachineVncCerts(id, opts)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, certs)
+}
+
+// GET /machines/<id>/spice/certs
+func HandleMachineSpiceCerts(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if !DBMachineExists(id) {
+		ErrorResponse(w, r, fmt.Errorf("Machine not found"), 404)
+		return
+	}
+
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if opts.Display != shared.DisplaySpice || opts.Spice.TLSPort == 0 {
+		ErrorResponse(w, r, fmt.Errorf("The SPICE server of the machine does not use TLS"), 409)
+		return
+	}
+
+	// SPICE clients are not authenticated with certificates
+	opts.VNC.VerifyClient = false
+
+	certs, err := MachineVncCerts(id, opts)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	SuccessResponse(w, r, certs)
+}
+
+// GET /machines/<id>/vnc?token=<token>
+// Websocket: proxy to the VNC server of the machine
+func HandleMachineVnc(w http.ResponseWriter, r *http.Request) {
+	v := mux.Vars(r)
+	id := v["id"]
+
+	if err, status := validateVncProxy(id); err != nil {
+		ErrorResponse(w, r, err, status)
+		return
+	}
+
+	if !UseVncToken(r.URL.Query().Get("token"), id) {
+		ErrorResponse(w, r, fmt.Errorf("Invalid or expired token"), 401)
+		return
+	}
+
+	conn, err := net.Dial("unix", MachineVncPath(id))
+	if err != nil {
+		ErrorResponse(w, r, fmt.Errorf("VNC server unavailable (is the machine running?)"), 409)
+		return
+	}
+
+	defer conn.Close()
+
+	ws, err := vncUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("%s %s from %s - %s\n", r.Method, r.URL.Path, r.RemoteAddr, err)
+		return
+	}
+
+	defer ws.Close()
+
+	log.Printf("%s %s from %s - VNC proxy connected\n", r.Method, r.URL.Path, r.RemoteAddr)
+
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			_, err = conn.Write(data)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+
+		err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+		if err != nil {
+			break
+		}
+	}
+
+	log.Printf("%s %s from %s - VNC proxy disconnected\n", r.Method, r.URL.Path, r.RemoteAddr)
+}
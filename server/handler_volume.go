@@ -29,6 +29,13 @@ func validateVolume(req shared.VolumeDef) (error, int) {
 		return fmt.Errorf("Missing 'Type'"), 400
 	}
 
+	if err, status := validateThrottle("Throttle", req.Throttle); err != nil {
+		return err, status
+	}
+	if throttled(req.Throttle) && req.Type == shared.BackendLXC {
+		return fmt.Errorf("'Throttle' is not supported for LXC volumes"), 400
+	}
+
 	return nil, 200
 }
 
@@ -126,6 +133,35 @@ func HandleVolumeUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The limits of a volume plugged into a running machine are changed live
+	machine, err := DBVolumeAttachedTo(id)
+	if err != nil {
+		ErrorResponse(w, r, err, 500)
+		return
+	}
+
+	if len(machine) > 0 {
+		current, err := DBVolumeGet(id)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+
+		b, err := MachineBackendByID(machine)
+		if err != nil {
+			ErrorResponse(w, r, err, 500)
+			return
+		}
+
+		if b.IsRunning(machine) && req.Throttle != current.Throttle {
+			err := b.SetThrottle(machine, id, req.Throttle)
+			if err != nil {
+				ErrorResponse(w, r, err, 500)
+				return
+			}
+		}
+	}
+
 	err = DBVolumeUpdate(req)
 	if err != nil {
 		ErrorResponse(w, r, err, 404)
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// Types of jobs
+const (
+	JobImageCreate   = "image create"
+	JobMachineCreate = "machine create"
+	JobMachineFetch  = "machine fetch"
+)
+
+// JobRetention is the time during which finished
+// jobs are kept before being forgotten
+const JobRetention = 24 * time.Hour
+
+// Job is a long-running operation executed in the
+// background. Jobs are only kept in memory
+type Job struct {
+	mutex  sync.Mutex
+	def    shared.JobDef
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// JobFunc is the function executed by a job. It returns
+// the ID of the created resource, if any
+type JobFunc func(j *Job) (string, error)
+
+var (
+	jobMutex sync.Mutex
+	jobs     = make(map[string]*Job)
+)
+
+// StartJob creates a new job of the specified
+// type and runs it in the background
+func StartJob(typ string, fn JobFunc) shared.JobDef {
+	j := new(Job)
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+
+	j.def.Type = typ
+	j.def.Status = shared.JobPending
+	j.def.Created = time.Now().Unix()
+	j.def.Updated = j.def.Created
+
+	jobMutex.Lock()
+
+	for {
+		j.def.ID = utils.RandID()
+		if _, ok := jobs[j.def.ID]; !ok {
+			break
+		}
+	}
+
+	jobs[j.def.ID] = j
+	cleanJobs()
+
+	jobMutex.Unlock()
+
+	go j.run(fn)
+
+	return j.Def()
+}
+
+// run executes the job function and records its outcome
+func (j *Job) run(fn JobFunc) {
+	j.update(func(def *shared.JobDef) {
+		def.Status = shared.JobRunning
+	})
+
+	result, err := fn(j)
+
+	j.update(func(def *shared.JobDef) {
+		def.Result = result
+
+		if j.ctx.Err() != nil {
+			def.Status = shared.JobCanceled
+			def.Error = "Canceled"
+		} else if err != nil {
+			def.Status = shared.JobFailed
+			def.Error = err.Error()
+		} else {
+			def.Status = shared.JobDone
+		}
+	})
+
+	if err != nil {
+		log.Printf("Job %s (%s) failed: %s\n", j.def.ID, j.def.Type, err)
+	}
+}
+
+// update modifies the job definition under lock
+func (j *Job) update(fn func(def *shared.JobDef)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	fn(&j.def)
+	j.def.Updated = time.Now().Unix()
+}
+
+// Def returns a copy of the job definition
+func (j *Job) Def() shared.JobDef {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.def
+}
+
+// Context returns the context of the job,
+// canceled when the job is canceled
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Step sets the description of the current
+// step of the job and resets its progress
+func (j *Job) Step(step string) {
+	j.update(func(def *shared.JobDef) {
+		def.Step = step
+		def.Progress = 0
+		def.Total = 0
+	})
+}
+
+// Progress returns a function that reports the
+// progress of a transfer as the progress of the job
+func (j *Job) Progress() system.TransferProgress {
+	return func(done, total uint64) {
+		j.update(func(def *shared.JobDef) {
+			def.Progress = done
+			def.Total = total
+		})
+	}
+}
+
+// Canceled returns an error if the job was canceled
+func (j *Job) Canceled() error {
+	return j.ctx.Err()
+}
+
+// finished checks if the job is over
+func (j *Job) finished() bool {
+	def := j.Def()
+	return def.Status != shared.JobPending && def.Status != shared.JobRunning
+}
+
+// cleanJobs forgets the jobs finished for longer than the
+// retention time. The job mutex must be held
+func cleanJobs() {
+	for id, j := range jobs {
+		if j.finished() && time.Since(time.Unix(j.Def().Updated, 0)) > JobRetention {
+			delete(jobs, id)
+		}
+	}
+}
+
+// JobsRunning checks if a job of one of the
+// specified types is pending or running
+func JobsRunning(types ...string) bool {
+	for _, def := range ListJobs() {
+		if def.Status != shared.JobPending && def.Status != shared.JobRunning {
+			continue
+		}
+
+		if utils.SliceContainsStr(def.Type, types) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListJobs returns all the known jobs, oldest first
+func ListJobs() []shared.JobDef {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	defs := make([]shared.JobDef, 0, len(jobs))
+	for _, j := range jobs {
+		defs = append(defs, j.Def())
+	}
+
+	sort.Slice(defs, func(a, b int) bool {
+		if defs[a].Created == defs[b].Created {
+			return defs[a].ID < defs[b].ID
+		}
+
+		return defs[a].Created < defs[b].Created
+	})
+
+	return defs
+}
+
+// GetJob returns the job with the specified ID
+func GetJob(id string) (*Job, error) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("Job not found")
+	}
+
+	return j, nil
+}
+
+// CancelJob requests the cancellation of the
+// specified job, if it is not over yet
+func CancelJob(id string) (shared.JobDef, error) {
+	j, err := GetJob(id)
+	if err != nil {
+		return shared.JobDef{}, err
+	}
+
+	if j.finished() {
+		return j.Def(), fmt.Errorf("Job is already %s", j.Def().Status)
+	}
+
+	j.cancel()
+
+	return j.Def(), nil
+}
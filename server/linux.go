@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/amoghe/go-crypt"
+
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// LinuxSetHostname sets the hostname of the Linux
+// system whose root filesystem is located at 'root'
+func LinuxSetHostname(root, hostname string) error {
+	return utils.ReplaceFileContents(fmt.Sprintf("%s/etc/hostname", root), []byte(hostname))
+}
+
+// LinuxSetRootPassword sets the root password of the Linux
+// system whose root filesystem is located at 'root'
+func LinuxSetRootPassword(root, passwd string) error {
+	shadowPath := fmt.Sprintf("%s/etc/shadow", root)
+
+	data, err := ioutil.ReadFile(shadowPath)
+	if err != nil {
+		return err
+	}
+
+	salt := utils.RandID()
+	str, err := crypt.Crypt(passwd, fmt.Sprintf("$6$%s$", salt[:8]))
+	if err != nil {
+		return err
+	}
+
+	regex := regexp.MustCompile("^root:[^:]+:")
+	dataStr := regex.ReplaceAllLiteralString(string(data), fmt.Sprintf("root:%s:", str))
+
+	err = utils.ReplaceFileContents(shadowPath, []byte(dataStr))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/xid"
@@ -18,6 +19,18 @@ import (
 	"github.com/quadrifoglio/wir/utils"
 )
 
+const (
+	NicMinBurst = 16 // Smallest burst (KiB) allowed above the bandwidth limits of the interfaces
+)
+
+var (
+	monitorMutex  sync.Mutex
+	monitoredNics = make(map[string]bool) // Machine interfaces whose traffic is being monitored
+
+	bridgeOnce  sync.Once
+	bridgeError error // Failure to prepare the host for the bridged networks
+)
+
 // StartNetworks is called when the daemon starts
 // to initialize all the existing networks
 func StartNetworks() error {
@@ -33,22 +46,60 @@ func StartNetworks() error {
 		}
 	}
 
-	err = system.EbtablesSetup()
-	if err != nil {
-		return err
-	}
-
-	go StartNetworkDHCP()
 	return nil
 }
 
-// CreateNetwork creates the specified network
-// by using Linux bridges
+// CreateNetwork creates the specified
+// network with every backend
 func CreateNetwork(def shared.NetworkDef) error {
 	if len(def.Name) > 12 {
 		return fmt.Errorf("Network name must be less than 12 characters long")
 	}
 
+	for _, b := range Backends() {
+		err := b.CreateNetwork(def)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteNetwork deletes the specified
+// network with every backend
+func DeleteNetwork(name string) error {
+	for _, b := range Backends() {
+		err := b.DeleteNetwork(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BridgeSetup prepares the host for the bridged networks the first
+// time it is called: traffic filtering and internal DHCP server
+func BridgeSetup() error {
+	bridgeOnce.Do(func() {
+		bridgeError = system.EbtablesSetup()
+		if bridgeError == nil {
+			go StartNetworkDHCP()
+		}
+	})
+
+	return bridgeError
+}
+
+// BridgeCreateNetwork creates the specified network
+// by using Linux bridges, unless it already exists
+func BridgeCreateNetwork(def shared.NetworkDef) error {
+	err := BridgeSetup()
+	if err != nil {
+		return err
+	}
+
 	if !system.InterfaceExists(NetworkNicName(def.Name)) {
 		err := system.CreateBridge(NetworkNicName(def.Name))
 		if err != nil {
@@ -84,7 +135,29 @@ func AttachInterfaceToNetwork(machineId string, n int, def shared.InterfaceDef)
 		return err
 	}
 
+	err = SetInterfaceBandwidth(machineId, n, def.Bandwidth)
+	if err != nil {
+		return err
+	}
+
+	nic := MachineNicName(machineId, n)
+
+	monitorMutex.Lock()
+	defer monitorMutex.Unlock()
+
+	if monitoredNics[nic] {
+		return nil
+	}
+
+	monitoredNics[nic] = true
+
 	go func() {
+		defer func() {
+			monitorMutex.Lock()
+			delete(monitoredNics, nic)
+			monitorMutex.Unlock()
+		}()
+
 		for {
 			pps, err := system.GetInterfacePPS(MachineNicName(machineId, n), "rx")
 			if err != nil {
@@ -110,10 +183,115 @@ func AttachInterfaceToNetwork(machineId string, n int, def shared.InterfaceDef)
 	return nil
 }
 
-// DeleteNetwork deletes the specified network
-// and the associated bridge
-func DeleteNetwork(id string) error {
-	return system.DeleteInterface(NetworkNicName(id))
+// nicBurst returns the burst size (KiB) allowed
+// above the specified bandwidth limit (kbit/s)
+func nicBurst(rate, burst uint64) uint64 {
+	if burst == 0 {
+		burst = rate / 80 // 100ms of traffic
+	}
+	if burst < NicMinBurst {
+		burst = NicMinBurst
+	}
+
+	return burst
+}
+
+// SetInterfaceBandwidth applies the bandwidth limits to the host side of the
+// n-th interface of the specified machine: the traffic received by the machine
+// leaves the host through it, and the traffic sent by the machine enters it
+func SetInterfaceBandwidth(machineId string, n int, def shared.BandwidthDef) error {
+	nic := MachineNicName(machineId, n)
+
+	err := system.TcShapeEgress(nic, def.IngressRate, nicBurst(def.IngressRate, def.IngressBurst))
+	if err != nil {
+		return err
+	}
+
+	return system.TcPoliceIngress(nic, def.EgressRate, nicBurst(def.EgressRate, def.EgressBurst))
+}
+
+// BridgeDeleteNetwork deletes the bridge
+// of the specified network, if it still exists
+func BridgeDeleteNetwork(name string) error {
+	if !system.InterfaceExists(NetworkNicName(name)) {
+		return nil
+	}
+
+	return system.DeleteInterface(NetworkNicName(name))
+}
+
+// BridgeDeleteInterfaces removes the host network
+// interfaces of the specified machine and their filtering rules
+// Failures are logged, the interfaces being of no use anymore
+func BridgeDeleteInterfaces(def shared.MachineDef) {
+	for i := range def.Interfaces {
+		nic := MachineNicName(def.ID, i)
+
+		if system.InterfaceExists(nic) {
+			err := system.DeleteInterface(nic)
+			if err != nil {
+				log.Printf("Could not delete machine '%s' interfaces: %s\n", def.ID, err)
+			}
+		}
+
+		err := system.EbtablesFlush(nic)
+		if err != nil {
+			log.Printf("Could not delete ebtables rules for '%s' interfaces: %s\n", def.ID, err)
+		}
+	}
+}
+
+// BridgeReconcile recreates the missing bridges of the networks
+func BridgeReconcile(fixed, problem ReportFunc) {
+	nets, err := DBNetworkList()
+	if err != nil {
+		problem("Failed to list networks: %s", err)
+		return
+	}
+
+	for _, net := range nets {
+		if system.InterfaceExists(NetworkNicName(net.Name)) {
+			continue
+		}
+
+		err := BridgeCreateNetwork(net)
+		if err != nil {
+			problem("Network %s: bridge %s is missing and could not be created: %s", net.Name, NetworkNicName(net.Name), err)
+		} else {
+			fixed("Network %s: recreated missing bridge %s", net.Name, NetworkNicName(net.Name))
+		}
+	}
+}
+
+// BridgeReconcileInterfaces re-attaches the interfaces of the specified
+// machine to their networks if it is running, and removes them otherwise
+func BridgeReconcileInterfaces(def shared.MachineDef, running bool, fixed, problem ReportFunc) {
+	for i, iface := range def.Interfaces {
+		nic := MachineNicName(def.ID, i)
+
+		if running {
+			err := AttachInterfaceToNetwork(def.ID, i, iface)
+			if err != nil {
+				problem("Machine %s: failed to re-attach interface %s: %s", def.ID, nic, err)
+			}
+		} else if system.InterfaceExists(nic) {
+			err := system.EbtablesFlush(nic)
+			if err == nil {
+				err = system.DeleteInterface(nic)
+			}
+
+			if err != nil {
+				problem("Machine %s: failed to remove leftover interface %s: %s", def.ID, nic, err)
+			} else {
+				fixed("Machine %s: removed leftover interface %s", def.ID, nic)
+			}
+		} else {
+			err := system.EbtablesFlush(nic)
+			if err != nil {
+				problem("Machine %s: failed to remove leftover ebtables rules of %s: %s", def.ID, nic, err)
+			}
+		}
+	}
 }
 
 // StartNetworkDHCP starts an internal DHCP server to handle
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/quadrifoglio/wir/utils"
+)
+
+const (
+	PkiCAValidity   = 10 * 365 * 24 * time.Hour // Validity of the certificate authority
+	PkiCertValidity = 2 * 365 * 24 * time.Hour  // Validity of the issued certificates
+)
+
+var pkiMutex sync.Mutex
+
+// PkiCAFile returns the path of the certificate
+// of the authority managed by the server
+func PkiCAFile() string {
+	return filepath.Join(GlobalPkiPath, "ca-cert.pem")
+}
+
+// PkiCAKeyFile returns the path of the private key
+// of the authority managed by the server
+func PkiCAKeyFile() string {
+	return filepath.Join(GlobalPkiPath, "ca-key.pem")
+}
+
+// pkiGenerateKey generates a new private key and returns it
+// along with its PEM encoding
+func pkiGenerateKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// pkiSerial returns a random certificate serial number
+func pkiSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// pkiDecode decodes the first PEM block of the file
+func pkiDecode(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	return block.Bytes, nil
+}
+
+// PkiCA returns the certificate authority of the
+// server, generating it if it does not exist yet
+func PkiCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pkiMutex.Lock()
+	defer pkiMutex.Unlock()
+
+	if !utils.FileExists(PkiCAFile()) || !utils.FileExists(PkiCAKeyFile()) {
+		err := pkiCreateCA()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	der, err := pkiDecode(PkiCAFile())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err = pkiDecode(PkiCAKeyFile())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+// pkiCreateCA generates the certificate authority of the server
+func pkiCreateCA() error {
+	err := os.MkdirAll(GlobalPkiPath, 0700)
+	if err != nil {
+		return err
+	}
+
+	key, keyPEM, err := pkiGenerateKey()
+	if err != nil {
+		return err
+	}
+
+	serial, err := pkiSerial()
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("wird CA - %s", hostname)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(PkiCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(PkiCAKeyFile(), keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(PkiCAFile(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// PkiIssue issues a certificate signed by the authority of the server
+// for a server (valid for the specified hosts) or a client, and returns
+// the certificate and its private key, PEM encoded
+func PkiIssue(name string, server bool, hosts []string) ([]byte, []byte, error) {
+	ca, caKey, err := PkiCA()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, keyPEM, err := pkiGenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := pkiSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(PkiCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else if len(h) > 0 {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// PkiServerSetup creates a folder containing the CA and a server
+// certificate issued by it for the specified address and the host
+// name, in the layout expected by QEMU (ca-cert.pem, server-cert.pem,
+// server-key.pem). An existing server certificate is kept
+func PkiServerSetup(dir, name, address string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	ca, err := ioutil.ReadFile(PkiCAFile())
+	if os.IsNotExist(err) {
+		_, _, err = PkiCA()
+		if err == nil {
+			ca, err = ioutil.ReadFile(PkiCAFile())
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "ca-cert.pem"), ca, 0644)
+	if err != nil {
+		return err
+	}
+
+	if utils.FileExists(filepath.Join(dir, "server-cert.pem")) {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	hosts := []string{hostname}
+
+	if ip := net.ParseIP(address); ip != nil && !ip.IsUnspecified() {
+		hosts = append(hosts, address)
+	}
+
+	cert, key, err := PkiIssue(name, true, hosts)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "server-key.pem"), key, 0600)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "server-cert.pem"), cert, 0644)
+}
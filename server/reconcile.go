@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+var reconcileMutex sync.Mutex
+
+// Reconcile checks the database against the state of the host
+// (networks, hypervisor processes, disks, network interfaces) and
+// repairs what can be repaired. Inconsistencies that can not be
+// fixed are reported
+func Reconcile() (shared.ReconcileDef, error) {
+	var report shared.ReconcileDef
+
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	fixed := func(format string, args ...interface{}) {
+		report.Fixed = append(report.Fixed, fmt.Sprintf(format, args...))
+	}
+	problem := func(format string, args ...interface{}) {
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, b := range Backends() {
+		b.Reconcile(fixed, problem)
+	}
+
+	machines, err := DBMachineList()
+	if err != nil {
+		return report, err
+	}
+
+	for _, m := range machines {
+		b, err := MachineBackend(m)
+		if err != nil {
+			problem("Machine %s: %s", m.ID, err)
+			continue
+		}
+
+		b.ReconcileMachine(m, fixed, problem)
+
+		// Synchronize the lifecycle state with the backend
+		before, _, err := DBMachineGetState(m.ID)
+		if err != nil {
+			return report, err
+		}
+
+		if before == shared.StateCreating && !JobsRunning(JobMachineCreate, JobMachineFetch) {
+			MachineFail(m.ID, shared.StateStopped, fmt.Errorf("The creation of the machine was interrupted"))
+			fixed("Machine %s: creation was interrupted, marked as stopped", m.ID)
+			continue
+		}
+
+		after, _, err := MachineState(m.ID)
+		if err != nil {
+			problem("Machine %s: failed to get state: %s", m.ID, err)
+		} else if before != after {
+			fixed("Machine %s: state changed from %s to %s", m.ID, before, after)
+		}
+	}
+
+	return report, nil
+}
+
+// reconcileKvmMachine repairs the QEMU process, the serial
+// console, the disk and the interfaces of the specified machine
+func reconcileKvmMachine(def shared.MachineDef, fixed, problem ReportFunc) {
+	reconcileKvmPid(def.ID, fixed, problem)
+
+	running := MachineKvmIsRunning(def.ID)
+
+	if running {
+		if _, err := MachineConsole(def.ID); err != nil {
+			err := MachineConsoleAttach(def.ID)
+			if err != nil {
+				problem("Machine %s: failed to re-attach serial console: %s", def.ID, err)
+			} else {
+				fixed("Machine %s: re-attached serial console", def.ID)
+			}
+		}
+	}
+
+	if !utils.FileExists(MachineDisk(def.ID)) {
+		problem("Machine %s: disk %s is missing", def.ID, MachineDisk(def.ID))
+	}
+
+	BridgeReconcileInterfaces(def, running, fixed, problem)
+}
+
+// reconcileLxcMachine checks the root filesystem and
+// repairs the interfaces of the specified container
+func reconcileLxcMachine(def shared.MachineDef, fixed, problem ReportFunc) {
+	if !utils.FileExists(MachineRootfs(def.ID)) {
+		problem("Machine %s: root filesystem %s is missing", def.ID, MachineRootfs(def.ID))
+	}
+
+	BridgeReconcileInterfaces(def, MachineLxcIsRunning(def.ID), fixed, problem)
+}
+
+// reconcileKvmPid checks that the PID stored for the specified
+// machine is a QEMU process running that machine, and forgets it otherwise
+func reconcileKvmPid(id string, fixed, problem ReportFunc) {
+	opts, err := DBMachineGetKvmOpts(id)
+	if err != nil {
+		problem("Machine %s: failed to get KVM options: %s", id, err)
+		return
+	}
+
+	if opts.PID <= 0 {
+		return
+	}
+
+	cmdline, err := system.ProcessCmdline(opts.PID)
+	if err == nil && len(cmdline) > 0 && strings.Contains(cmdline[0], "qemu-system") &&
+		strings.Contains(strings.Join(cmdline, " "), MachineMonitorPath(id)) {
+		return
+	}
+
+	pid := opts.PID
+	opts.PID = 0
+
+	err = DBMachineSetKvmOpts(id, opts)
+	if err != nil {
+		problem("Machine %s: failed to clear stale PID %d: %s", id, pid, err)
+		return
+	}
+
+	fixed("Machine %s: PID %d is not its QEMU process anymore, cleared", id, pid)
+}
+
+// reconcileKvmCgroups removes the slices of the machines whose
+// QEMU process exited while the server was not running to clean them up
+func reconcileKvmCgroups(fixed, problem ReportFunc) {
+	if !system.CgroupV2() || !system.CgroupExists(CgroupSlice) {
+		return
+	}
+
+	slices, err := system.CgroupChildren(CgroupSlice)
+	if err != nil {
+		problem("Cgroup %s: failed to list the slices: %s", CgroupSlice, err)
+		return
+	}
+
+	for _, s := range slices {
+		if !strings.HasPrefix(s, "wir-") || !strings.HasSuffix(s, ".slice") {
+			continue
+		}
+
+		// The slice of a starting machine is created before its PID is saved
+		id := strings.TrimSuffix(strings.TrimPrefix(s, "wir-"), ".slice")
+		if DBMachineExists(id) {
+			state, _, err := DBMachineGetState(id)
+			if err != nil || state == shared.StateStarting || MachineKvmIsRunning(id) {
+				continue
+			}
+		}
+
+		err := system.CgroupDelete(MachineCgroupPath(id))
+		if err != nil {
+			problem("Machine %s: failed to remove stale cgroup %s: %s", id, MachineCgroupPath(id), err)
+		} else {
+			fixed("Machine %s: removed stale cgroup %s", id, MachineCgroupPath(id))
+		}
+	}
+}
+
+// LogReconcile runs a reconciliation pass and logs its report
+func LogReconcile() error {
+	report, err := Reconcile()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range report.Fixed {
+		log.Printf("Reconcile: fixed: %s\n", f)
+	}
+	for _, p := range report.Problems {
+		log.Printf("Reconcile: problem: %s\n", p)
+	}
+
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/quadrifoglio/wir/shared"
 	"github.com/quadrifoglio/wir/utils"
 )
 
@@ -14,24 +16,111 @@ var (
 	GlobalImagePath   string
 	GlobalVolumePath  string
 	GlobalMachinePath string
+	GlobalPkiPath     string // Folder of the certificate authority managed by the server
+
+	GlobalBackend     string        // Backend forced for all machines (empty: by image type)
+	GlobalStopTimeout time.Duration // Time given to machines to shut down before being killed
+
+	GlobalVncDisplays [2]int // Range of VNC display numbers allocated to the machines
+	GlobalVncWsPorts  [2]int // Range of VNC websocket ports allocated to the machines
+
+	GlobalOvmfCode string // UEFI firmware code of the machines
+	GlobalOvmfVars string // Template of the UEFI variables of the machines
+)
+
+const (
+	DefaultStopTimeout = 60 * time.Second
+	DefaultVncDisplays = "1-99"      // TCP ports 5901 to 5999
+	DefaultVncWsPorts  = "5700-5799" // VNC websocket ports
+	DefaultOvmfCode    = "/usr/share/OVMF/OVMF_CODE.fd"
+	DefaultOvmfVars    = "/usr/share/OVMF/OVMF_VARS.fd"
 )
 
+// Config contains the parameters of the server
+// Parameters left empty take their default value
+type Config struct {
+	NodeID   byte
+	Database string // Path of the database file
+
+	ImagePath   string
+	VolumePath  string
+	MachinePath string
+	PkiPath     string // Folder of the certificate authority managed by the server
+
+	Backend     string // Backend forced for all machines (empty: by image type)
+	StopTimeout int    // Seconds given to machines to shut down before being killed
+
+	VncDisplays string // Range of VNC display numbers allocated to the machines (min-max)
+	VncWsPorts  string // Range of VNC websocket ports allocated to the machines (min-max)
+
+	OvmfCode string // UEFI firmware code of the machines
+	OvmfVars string // Template of the UEFI variables of the machines
+}
+
 // Init initializes the parameters
 // of the server
-func Init(nodeId byte, db string, img, vol, machine string) error {
-	GlobalNodeID = nodeId
-	GlobalImagePath = img
-	GlobalVolumePath = vol
-	GlobalMachinePath = machine
-
-	if !utils.FileExists(filepath.Dir(db)) {
-		err := os.MkdirAll(filepath.Dir(db), 0755)
+func Init(c Config) error {
+	GlobalNodeID = c.NodeID
+	GlobalImagePath = c.ImagePath
+	GlobalVolumePath = c.VolumePath
+	GlobalMachinePath = c.MachinePath
+	GlobalPkiPath = c.PkiPath
+	GlobalBackend = c.Backend
+	GlobalStopTimeout = time.Duration(c.StopTimeout) * time.Second
+	GlobalOvmfCode = c.OvmfCode
+	GlobalOvmfVars = c.OvmfVars
+
+	if GlobalStopTimeout == 0 {
+		GlobalStopTimeout = DefaultStopTimeout
+	}
+
+	if len(GlobalPkiPath) == 0 {
+		GlobalPkiPath = filepath.Join(filepath.Dir(GlobalMachinePath), "pki")
+	}
+
+	if len(GlobalOvmfCode) == 0 {
+		GlobalOvmfCode = DefaultOvmfCode
+	}
+	if len(GlobalOvmfVars) == 0 {
+		GlobalOvmfVars = DefaultOvmfVars
+	}
+
+	if len(c.VncDisplays) == 0 {
+		c.VncDisplays = DefaultVncDisplays
+	}
+	if len(c.VncWsPorts) == 0 {
+		c.VncWsPorts = DefaultVncWsPorts
+	}
+
+	var err error
+
+	GlobalVncDisplays[0], GlobalVncDisplays[1], err = utils.ParseRange(c.VncDisplays)
+	if err != nil {
+		return fmt.Errorf("VNC displays: %s", err)
+	}
+
+	GlobalVncWsPorts[0], GlobalVncWsPorts[1], err = utils.ParseRange(c.VncWsPorts)
+	if err != nil {
+		return fmt.Errorf("VNC websocket ports: %s", err)
+	}
+
+	if GlobalBackend == BackendFake {
+		fake := NewFakeBackend()
+
+		RegisterBackend(shared.BackendKVM, fake)
+		RegisterBackend(shared.BackendLXC, fake)
+	} else if len(GlobalBackend) > 0 {
+		return fmt.Errorf("Unknown backend '%s'", GlobalBackend)
+	}
+
+	if !utils.FileExists(filepath.Dir(c.Database)) {
+		err := os.MkdirAll(filepath.Dir(c.Database), 0755)
 		if err != nil {
 			return err
 		}
 	}
 
-	err := InitDatabase(db)
+	err = InitDatabase(c.Database)
 	if err != nil {
 		return err
 	}
@@ -41,6 +130,19 @@ func Init(nodeId byte, db string, img, vol, machine string) error {
 		return err
 	}
 
+	err = LogReconcile()
+	if err != nil {
+		return err
+	}
+
+	err = StartMachines()
+	if err != nil {
+		return err
+	}
+
+	go Supervise()
+	go BalloonMonitor()
+
 	return nil
 }
 
@@ -73,3 +175,51 @@ func MachineDisk(id string) string {
 func MachineMonitorPath(id string) string {
 	return fmt.Sprintf("%s/monitor.sock", MachinePath(id))
 }
+
+// MachineSerialPath returns the path to the
+// socket of the machine's serial port
+func MachineSerialPath(id string) string {
+	return fmt.Sprintf("%s/serial.sock", MachinePath(id))
+}
+
+// MachineConsoleLog returns the path of the file
+// where the machine's serial output is logged
+func MachineConsoleLog(id string) string {
+	return fmt.Sprintf("%s/console.log", MachinePath(id))
+}
+
+// MachineVncPath returns the path to the socket
+// of the machine's VNC server, when it is proxied
+func MachineVncPath(id string) string {
+	return fmt.Sprintf("%s/vnc.sock", MachinePath(id))
+}
+
+// MachineVncTlsPath returns the folder containing the
+// certificates of the machine's VNC server
+func MachineVncTlsPath(id string) string {
+	return fmt.Sprintf("%s/vnc-tls", MachinePath(id))
+}
+
+// MachineSpiceTlsPath returns the folder containing the
+// certificates of the machine's SPICE server
+func MachineSpiceTlsPath(id string) string {
+	return fmt.Sprintf("%s/spice-tls", MachinePath(id))
+}
+
+// MachineNvramPath returns the path of the file containing
+// the UEFI variables of the machine
+func MachineNvramPath(id string) string {
+	return fmt.Sprintf("%s/nvram.qcow2", MachinePath(id))
+}
+
+// MachineRootfs returns the path of the root filesystem
+// folder for the specified container name
+func MachineRootfs(id string) string {
+	return fmt.Sprintf("%s/rootfs", MachinePath(id))
+}
+
+// MachineLxcConfig returns the path of the LXC configuration
+// file for the specified container name
+func MachineLxcConfig(id string) string {
+	return fmt.Sprintf("%s/config", MachinePath(id))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// MachineSpiceCheckPorts checks that the ports of the SPICE server of the
+// specified machine are neither used by the remote display of another
+// machine nor by the host. It returns the coresponding http status code
+func MachineSpiceCheckPorts(id string, opts shared.KvmOptsDef) (error, int) {
+	if opts.Display != shared.DisplaySpice {
+		return nil, 200
+	}
+
+	ports, err := DBDisplayPortsInUse(id)
+	if err != nil {
+		return err, 500
+	}
+
+	for _, p := range []int{opts.Spice.Port, opts.Spice.TLSPort} {
+		if p == 0 {
+			continue
+		}
+
+		if err, status := displayPortCheck(ports, opts.Spice.Address, p, "SPICE port"); err != nil {
+			return err, status
+		}
+	}
+
+	return nil, 200
+}
+
+// MachineSpiceTlsSetup creates the folder containing the CA and the
+// server certificate used by the SPICE server of the machine
+func MachineSpiceTlsSetup(id string, opts shared.KvmOptsDef) error {
+	return PkiServerSetup(MachineSpiceTlsPath(id), fmt.Sprintf("wird SPICE - machine %s", id), opts.Spice.Address)
+}
+
+// MachineSpiceArgs returns the command line arguments
+// of the SPICE server of the specified machine
+func MachineSpiceArgs(id string, opts shared.KvmOptsDef) []string {
+	spice := fmt.Sprintf("addr=%s", opts.Spice.Address)
+
+	if opts.Spice.Port > 0 {
+		spice = fmt.Sprintf("%s,port=%d", spice, opts.Spice.Port)
+	}
+	if opts.Spice.TLSPort > 0 {
+		spice = fmt.Sprintf("%s,tls-port=%d,x509-dir=%s", spice, opts.Spice.TLSPort, MachineSpiceTlsPath(id))
+	}
+
+	// The password is set through the monitor once the machine is started
+	if len(opts.Spice.Password) == 0 {
+		spice = fmt.Sprintf("%s,disable-ticketing=on", spice)
+	}
+
+	return []string{"-spice", spice}
+}
+
+// MachineSpiceAgentArgs returns the command line arguments of
+// the channel used by the SPICE agent of the guest
+func MachineSpiceAgentArgs() []string {
+	return []string{
+		"-device", "virtio-serial-pci",
+		"-chardev", "spicevmc,id=vdagent0,name=vdagent",
+		"-device", "virtserialport,chardev=vdagent0,name=com.redhat.spice.0",
+	}
+}
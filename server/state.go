@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// Machine operations whose validity
+// depends on the lifecycle state
+const (
+	OpStart      = "start"
+	OpStop       = "stop"
+	OpPause      = "pause"
+	OpResume     = "resume"
+	OpReset      = "reset"
+	OpReboot     = "reboot"
+	OpDelete     = "delete"
+	OpSetOpts    = "set options"
+	OpCheckpoint = "checkpoint"
+	OpAttach     = "attach"
+	OpDetach     = "detach"
+	OpBalloon    = "balloon"
+	OpResize     = "resize"
+	OpUpdate     = "update"
+)
+
+var (
+	// stateTransitions lists the states that can
+	// be reached from each state
+	stateTransitions = map[string][]string{
+		shared.StateCreating:  {shared.StateStopped, shared.StateMigrating},
+		shared.StateStopped:   {shared.StateStarting, shared.StateRunning, shared.StateMigrating},
+		shared.StateStarting:  {shared.StateRunning, shared.StateStopped, shared.StateCrashed},
+		shared.StateRunning:   {shared.StateRunning, shared.StatePaused, shared.StateStopping, shared.StateCrashed, shared.StateMigrating},
+		shared.StatePaused:    {shared.StateRunning, shared.StateStopping, shared.StateCrashed},
+		shared.StateStopping:  {shared.StateStopped, shared.StateRunning, shared.StateCrashed},
+		shared.StateCrashed:   {shared.StateStarting, shared.StateStopped, shared.StateRunning},
+		shared.StateMigrating: {shared.StateStopped, shared.StateRunning},
+	}
+
+	// stateOperations lists the states in
+	// which each operation is allowed
+	stateOperations = map[string][]string{
+		OpStart:      {shared.StateStopped, shared.StateCrashed},
+		OpStop:       {shared.StateRunning, shared.StatePaused},
+		OpPause:      {shared.StateRunning},
+		OpResume:     {shared.StatePaused},
+		OpReset:      {shared.StateRunning, shared.StatePaused},
+		OpReboot:     {shared.StateRunning},
+		OpDelete:     {shared.StateStopped, shared.StateCrashed},
+		OpSetOpts:    {shared.StateStopped, shared.StateCrashed},
+		OpCheckpoint: {shared.StateRunning, shared.StatePaused},
+		OpAttach:     {shared.StateStopped, shared.StateCrashed, shared.StateRunning},
+		OpDetach:     {shared.StateStopped, shared.StateCrashed, shared.StateRunning},
+		OpBalloon:    {shared.StateRunning, shared.StatePaused},
+		OpResize:     {shared.StateStopped, shared.StateCrashed, shared.StateRunning, shared.StatePaused},
+		OpUpdate:     {shared.StateStopped, shared.StateCrashed, shared.StateRunning, shared.StatePaused},
+	}
+)
+
+// MachineState returns the lifecycle state of the machine and the
+// reason of the last change, after checking it against the backend:
+// a running machine whose hypervisor is gone is marked as crashed
+func MachineState(id string) (string, string, error) {
+	state, reason, err := DBMachineGetState(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch state {
+	case shared.StateRunning, shared.StatePaused:
+		if !b.IsRunning(id) {
+			state = shared.StateCrashed
+			reason = "The hypervisor process exited unexpectedly"
+
+			err := DBMachineSetState(id, state, reason)
+			if err != nil {
+				return "", "", err
+			}
+		}
+	case shared.StateStopped, shared.StateCrashed:
+		if b.IsRunning(id) {
+			state = shared.StateRunning
+			reason = "The machine was found running"
+
+			err := DBMachineSetState(id, state, reason)
+			if err != nil {
+				return "", "", err
+			}
+		}
+	}
+
+	return state, reason, nil
+}
+
+// MachineTransition moves the machine to the specified state
+// if the transition is allowed from its current state
+func MachineTransition(id, state, reason string) error {
+	current, _, err := DBMachineGetState(id)
+	if err != nil {
+		return err
+	}
+
+	if !utils.SliceContainsStr(state, stateTransitions[current]) {
+		return fmt.Errorf("Machine can't go from '%s' to '%s'", current, state)
+	}
+
+	return DBMachineSetState(id, state, reason)
+}
+
+// MachineFail moves the machine to the specified state after
+// an operation failed, recording the error as the reason
+func MachineFail(id, state string, cause error) {
+	err := DBMachineSetState(id, state, cause.Error())
+	if err != nil {
+		log.Printf("Not fatal - Machine %s - Failed to save state: %s\n", id, err)
+	}
+}
+
+// validateMachineOperation checks if the specified operation is allowed
+// in the current state of the machine, and returns the coresponding http status code
+func validateMachineOperation(id, op string) (error, int) {
+	state, _, err := MachineState(id)
+	if err != nil {
+		return err, 500
+	}
+
+	if !utils.SliceContainsStr(state, stateOperations[op]) {
+		return fmt.Errorf("Operation '%s' not allowed: machine is %s", op, state), 409
+	}
+
+	return nil, 200
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+const (
+	SupervisorInterval = 5 * time.Second  // Time between two checks of the machines
+	RestartBackoffMin  = 5 * time.Second  // Delay before the first restart attempt
+	RestartBackoffMax  = 5 * time.Minute  // Maximum delay between two restart attempts
+	RestartResetDelay  = 10 * time.Minute // Uptime after which the restart attempts are forgotten
+)
+
+// restartInfo keeps track of the automatic
+// restart attempts of a machine
+type restartInfo struct {
+	Attempts  int       // Number of consecutive restart attempts
+	LastStart time.Time // Time of the last restart attempt
+}
+
+var (
+	restartMutex sync.Mutex
+	restarts     = make(map[string]*restartInfo)
+)
+
+// restartBackoff returns the delay to wait before
+// the n-th consecutive restart attempt
+func restartBackoff(n int) time.Duration {
+	d := RestartBackoffMin
+	for i := 0; i < n && d < RestartBackoffMax; i++ {
+		d *= 2
+	}
+
+	if d > RestartBackoffMax {
+		d = RestartBackoffMax
+	}
+
+	return d
+}
+
+// MachineAutoStart starts the specified machine on behalf
+// of the daemon, recording a failure as a crash
+func MachineAutoStart(id, reason string) error {
+	b, err := MachineBackendByID(id)
+	if err != nil {
+		return err
+	}
+
+	err = MachineTransition(id, shared.StateStarting, reason)
+	if err != nil {
+		return err
+	}
+
+	err = b.Start(id)
+	if err != nil {
+		MachineFail(id, shared.StateCrashed, err)
+		return err
+	}
+
+	return MachineTransition(id, shared.StateRunning, reason)
+}
+
+// StartMachines is called when the daemon starts to start
+// the machines according to their restart policy
+func StartMachines() error {
+	machines, err := DBMachineList()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range machines {
+		if m.RestartPolicy == shared.RestartNever {
+			continue
+		}
+
+		state, _, err := MachineState(m.ID)
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to get state: %s\n", m.ID, err)
+			continue
+		}
+
+		if state != shared.StateStopped && state != shared.StateCrashed {
+			continue
+		}
+
+		// Only crashed machines are restarted by the 'on-failure' policy
+		if m.RestartPolicy == shared.RestartOnFailure && state != shared.StateCrashed {
+			continue
+		}
+
+		err = MachineAutoStart(m.ID, "Started by the daemon")
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to start at daemon startup: %s\n", m.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Supervise periodically checks the state of the machines
+// and restarts the ones that stopped unexpectedly according to
+// their restart policy. It never returns
+func Supervise() {
+	for {
+		time.Sleep(SupervisorInterval)
+
+		machines, err := DBMachineList()
+		if err != nil {
+			log.Printf("Supervisor: failed to list machines: %s\n", err)
+			continue
+		}
+
+		for _, m := range machines {
+			superviseMachine(m)
+		}
+
+		forgetRestarts(machines)
+	}
+}
+
+// forgetRestarts drops the restart attempts of the machines
+// that are not in the specified list, as they were deleted
+func forgetRestarts(machines []shared.MachineDef) {
+	exists := make(map[string]bool, len(machines))
+	for _, m := range machines {
+		exists[m.ID] = true
+	}
+
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+
+	for id := range restarts {
+		if !exists[id] {
+			delete(restarts, id)
+		}
+	}
+}
+
+// stoppedUnexpectedly checks if the hypervisor process of
+// the specified stopped machine exited without being asked to
+func stoppedUnexpectedly(id string) bool {
+	exit, err := DBMachineGetExit(id)
+	if err != nil {
+		log.Printf("Supervisor: machine %s: failed to get exit status: %s\n", id, err)
+		return false
+	}
+
+	return exit != nil && !exit.Expected
+}
+
+// superviseMachine restarts the specified machine if it crashed,
+// or powered itself off, and its restart policy requires it
+func superviseMachine(m shared.MachineDef) {
+	state, _, err := MachineState(m.ID)
+	if err != nil {
+		log.Printf("Supervisor: machine %s: failed to get state: %s\n", m.ID, err)
+		return
+	}
+
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+
+	info, ok := restarts[m.ID]
+	if !ok {
+		info = new(restartInfo)
+		restarts[m.ID] = info
+	}
+
+	if state == shared.StateRunning && info.Attempts > 0 && time.Since(info.LastStart) > RestartResetDelay {
+		info.Attempts = 0
+	}
+
+	switch m.RestartPolicy {
+	case shared.RestartOnFailure:
+		if state != shared.StateCrashed {
+			return
+		}
+	case shared.RestartAlways:
+		if state != shared.StateCrashed && (state != shared.StateStopped || !stoppedUnexpectedly(m.ID)) {
+			return
+		}
+	default:
+		return
+	}
+
+	if time.Since(info.LastStart) < restartBackoff(info.Attempts) {
+		return
+	}
+
+	info.Attempts++
+	info.LastStart = time.Now()
+
+	log.Printf("Supervisor: machine %s is %s, restarting it (attempt %d)\n", m.ID, state, info.Attempts)
+
+	err = MachineAutoStart(m.ID, "Restarted by the supervisor")
+	if err != nil {
+		log.Printf("Supervisor: machine %s: restart failed: %s\n", m.ID, err)
+	}
+}
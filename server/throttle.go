@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+// validateThrottle checks the I/O limits of the specified drive
+// field ('DiskThrottle', 'Throttle') and returns the coresponding
+// http status code
+func validateThrottle(field string, def shared.ThrottleDef) (error, int) {
+	limits := []struct {
+		name         string
+		value, burst uint64
+	}{
+		{"ReadIOPS", def.ReadIOPS, def.ReadIOPSBurst},
+		{"WriteIOPS", def.WriteIOPS, def.WriteIOPSBurst},
+		{"ReadBPS", def.ReadBPS, def.ReadBPSBurst},
+		{"WriteBPS", def.WriteBPS, def.WriteBPSBurst},
+	}
+
+	for _, l := range limits {
+		if l.burst > 0 && l.burst < l.value {
+			return fmt.Errorf("'%s.%sBurst' can't be lower than '%s.%s'", field, l.name, field, l.name), 400
+		}
+		if l.burst > 0 && l.value == 0 {
+			return fmt.Errorf("'%s.%sBurst' requires '%s.%s'", field, l.name, field, l.name), 400
+		}
+	}
+
+	return nil, 200
+}
+
+// throttled checks if the specified drive has any I/O limit
+func throttled(def shared.ThrottleDef) bool {
+	return def != shared.ThrottleDef{}
+}
+
+// kvmThrottleArgs returns the arguments of the QMP 'block_set_io_throttle'
+// command applying the specified limits to a QEMU device
+func kvmThrottleArgs(device string, def shared.ThrottleDef) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          device,
+		"iops":        0,
+		"iops_rd":     def.ReadIOPS,
+		"iops_wr":     def.WriteIOPS,
+		"bps":         0,
+		"bps_rd":      def.ReadBPS,
+		"bps_wr":      def.WriteBPS,
+		"iops_rd_max": def.ReadIOPSBurst,
+		"iops_wr_max": def.WriteIOPSBurst,
+		"bps_rd_max":  def.ReadBPSBurst,
+		"bps_wr_max":  def.WriteBPSBurst,
+	}
+}
+
+// kvmDriveThrottleOpts returns the options of a QEMU '-drive'
+// argument applying the specified limits, with a leading comma
+func kvmDriveThrottleOpts(def shared.ThrottleDef) string {
+	var opts []string
+
+	for k, v := range kvmThrottleArgs("", def) {
+		if n, ok := v.(uint64); ok && n > 0 {
+			opts = append(opts, fmt.Sprintf(",%s=%d", k, n))
+		}
+	}
+
+	sort.Strings(opts)
+	return strings.Join(opts, "")
+}
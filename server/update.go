@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/quadrifoglio/wir/shared"
+)
+
+const (
+	KvmMemorySlots = 16 // Number of memory DIMMs that can be hot-added to a running machine
+)
+
+// kvmMaxMemory returns the amount of memory (MiB) the machine can
+// grow to while it is running, without any reservation by default
+func kvmMaxMemory(def shared.MachineDef, opts shared.KvmOptsDef) uint64 {
+	max := opts.Hotplug.MaxMemory
+	if max < def.Memory {
+		max = def.Memory
+	}
+
+	return max
+}
+
+// MachineKvmMemoryArgs returns the command line arguments defining the memory
+// of the specified machine, reserving the memory that can be hot-added
+func MachineKvmMemoryArgs(def shared.MachineDef, opts shared.KvmOptsDef) []string {
+	max := kvmMaxMemory(def, opts)
+	if max == def.Memory {
+		return []string{"-m", fmt.Sprintf("%d", def.Memory)}
+	}
+
+	return []string{"-m", fmt.Sprintf("%dM,slots=%d,maxmem=%dM", def.Memory, KvmMemorySlots, max)}
+}
+
+// MachineUpdateLive applies the saved update of the machine to its
+// hypervisor if it is running: added vCPUs and memory are plugged, and
+// the I/O limits of the disk and the bandwidth limits of the interfaces
+// are changed. The other changes of the interfaces require a restart.
+// It returns the changes that were applied, and the ones that require
+// a restart
+func MachineUpdateLive(b Backend, current, req shared.MachineDef) ([]string, []string) {
+	var applied, pending []string
+
+	if !b.IsRunning(current.ID) {
+		return applied, pending
+	}
+
+	if req.Cores != current.Cores {
+		change := fmt.Sprintf("Cores: %d -> %d", current.Cores, req.Cores)
+
+		if req.Cores < current.Cores {
+			pending = append(pending, fmt.Sprintf("%s (vCPUs can not be removed from a running machine)", change))
+		} else if err := b.HotAddCores(current.ID, req.Cores); err != nil {
+			pending = append(pending, fmt.Sprintf("%s (%s)", change, err))
+		} else {
+			applied = append(applied, change)
+		}
+	}
+
+	if req.Memory != current.Memory {
+		change := fmt.Sprintf("Memory: %d -> %d MiB", current.Memory, req.Memory)
+
+		if req.Memory < current.Memory {
+			pending = append(pending, fmt.Sprintf("%s (memory can not be removed from a running machine, see the balloon)", change))
+		} else if err := b.HotAddMemory(current.ID, req.Memory); err != nil {
+			pending = append(pending, fmt.Sprintf("%s (%s)", change, err))
+		} else {
+			applied = append(applied, change)
+		}
+	}
+
+	if req.DiskThrottle != current.DiskThrottle {
+		change := "Disk I/O limits"
+
+		if err := b.SetThrottle(current.ID, "", req.DiskThrottle); err != nil {
+			pending = append(pending, fmt.Sprintf("%s (%s)", change, err))
+		} else {
+			applied = append(applied, change)
+		}
+	}
+
+	for n, iface := range req.Interfaces {
+		if n >= len(current.Interfaces) {
+			continue
+		}
+
+		// The tap device of the interface stays as it was plugged
+		prev := current.Interfaces[n]
+		if iface.Network != prev.Network {
+			pending = append(pending, fmt.Sprintf("Network of interface %d: %s -> %s", n, prev.Network, iface.Network))
+		}
+		if iface.MAC != prev.MAC {
+			pending = append(pending, fmt.Sprintf("MAC address of interface %d: %s -> %s", n, prev.MAC, iface.MAC))
+		}
+		if iface.IP != prev.IP {
+			pending = append(pending, fmt.Sprintf("IP address of interface %d: %s -> %s", n, prev.IP, iface.IP))
+		}
+
+		if iface.Bandwidth == prev.Bandwidth {
+			continue
+		}
+
+		change := fmt.Sprintf("Bandwidth limits of interface %d", n)
+
+		if err := b.SetBandwidth(current.ID, n, iface.Bandwidth); err != nil {
+			pending = append(pending, fmt.Sprintf("%s (%s)", change, err))
+		} else {
+			applied = append(applied, change)
+		}
+	}
+
+	for _, change := range applied {
+		err := DBMachineAddEvent(current.ID, "update", fmt.Sprintf("%s, applied to the running machine", change))
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to save event: %s\n", current.ID, err)
+		}
+	}
+
+	for _, change := range pending {
+		err := DBMachineAddEvent(current.ID, "update", fmt.Sprintf("%s, requires a restart", change))
+		if err != nil {
+			log.Printf("Not fatal - Machine %s - Failed to save event: %s\n", current.ID, err)
+		}
+	}
+
+	return applied, pending
+}
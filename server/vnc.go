@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/quadrifoglio/wir/shared"
+	"github.com/quadrifoglio/wir/system"
+)
+
+// VncTokenLifetime is the time during which a
+// VNC proxy access token can be used
+const VncTokenLifetime = 30 * time.Second
+
+// vncToken gives access to the VNC proxy of a machine
+type vncToken struct {
+	Machine string
+	Expires time.Time
+}
+
+var (
+	vncTokenMutex sync.Mutex
+	vncTokens     = make(map[string]vncToken)
+)
+
+// NewVncToken creates a single-use token giving access
+// to the VNC proxy of the specified machine
+func NewVncToken(id string) (shared.VncTokenDef, error) {
+	var def shared.VncTokenDef
+
+	buf := make([]byte, 24)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return def, err
+	}
+
+	def.Token = hex.EncodeToString(buf)
+	expires := time.Now().Add(VncTokenLifetime)
+	def.Expires = expires.Unix()
+
+	vncTokenMutex.Lock()
+	defer vncTokenMutex.Unlock()
+
+	// Forget the expired tokens
+	for t, token := range vncTokens {
+		if time.Now().After(token.Expires) {
+			delete(vncTokens, t)
+		}
+	}
+
+	vncTokens[def.Token] = vncToken{Machine: id, Expires: expires}
+
+	return def, nil
+}
+
+// UseVncToken checks that the token gives access to the
+// VNC proxy of the specified machine, and invalidates it
+func UseVncToken(token, id string) bool {
+	vncTokenMutex.Lock()
+	defer vncTokenMutex.Unlock()
+
+	t, ok := vncTokens[token]
+	if !ok {
+		return false
+	}
+
+	delete(vncTokens, token)
+
+	return t.Machine == id && time.Now().Before(t.Expires)
+}
+
+// VncDisplayPort is the TCP port of the first VNC display
+const VncDisplayPort = 5900
+
+// vncPortMutex must be held from the allocation of VNC
+// ports to the moment they are saved into the database
+var vncPortMutex sync.Mutex
+
+// MachineVncAllocate assigns free VNC display and websocket ports from the
+// configured ranges to the options of the specified machine, unless they
+// were requested explicitly, in which case they are checked for conflicts
+// the same way. It returns the coresponding http status code
+func MachineVncAllocate(id string, opts *shared.KvmOptsDef) (error, int) {
+	// Only a VNC server listening on TCP needs ports
+	if !opts.VNC.Enabled || len(opts.VNC.Address) == 0 {
+		opts.VNC.Port = 0
+		opts.VNC.WebsocketPort = 0
+
+		return nil, 200
+	}
+
+	ports, err := DBDisplayPortsInUse(id)
+	if err != nil {
+		return err, 500
+	}
+
+	if opts.VNC.Port != 0 {
+		if err, status := displayPortCheck(ports, opts.VNC.Address, VncDisplayPort+opts.VNC.Port, "VNC display port"); err != nil {
+			return err, status
+		}
+	} else {
+		// Display 0 can not be allocated: a null port means 'allocate'
+		for d := GlobalVncDisplays[0]; d <= GlobalVncDisplays[1]; d++ {
+			if d == 0 {
+				continue
+			}
+
+			if err, _ := displayPortCheck(ports, opts.VNC.Address, VncDisplayPort+d, "VNC display port"); err == nil {
+				opts.VNC.Port = d
+				break
+			}
+		}
+
+		if opts.VNC.Port == 0 {
+			return fmt.Errorf("No VNC display available"), 503
+		}
+	}
+
+	if opts.VNC.WebsocketPort != 0 {
+		if opts.VNC.WebsocketPort == VncDisplayPort+opts.VNC.Port {
+			return fmt.Errorf("'VNC.WebsocketPort' can't be the port of the VNC display (%d)", opts.VNC.WebsocketPort), 400
+		}
+
+		if err, status := displayPortCheck(ports, opts.VNC.Address, opts.VNC.WebsocketPort, "VNC websocket port"); err != nil {
+			return err, status
+		}
+	} else {
+		for p := GlobalVncWsPorts[0]; p <= GlobalVncWsPorts[1]; p++ {
+			if p == 0 {
+				continue
+			}
+
+			if p == VncDisplayPort+opts.VNC.Port {
+				continue
+			}
+
+			if err, _ := displayPortCheck(ports, opts.VNC.Address, p, "VNC websocket port"); err == nil {
+				opts.VNC.WebsocketPort = p
+				break
+			}
+		}
+
+		if opts.VNC.WebsocketPort == 0 {
+			return fmt.Errorf("No VNC websocket port available"), 503
+		}
+	}
+
+	return nil, 200
+}
+
+// displayPortCheck checks that the specified TCP port, requested for
+// the remote display of a machine listening on the address, is neither
+// assigned to another machine nor used on the host
+// It returns the coresponding http status code
+func displayPortCheck(ports map[int]string, address string, port int, name string) (error, int) {
+	if m, ok := ports[port]; ok {
+		return fmt.Errorf("%s %d is already used by machine %s", name, port, m), 409
+	}
+
+	if !system.TCPPortFree(address, port) {
+		return fmt.Errorf("%s %d is already in use on the host", name, port), 409
+	}
+
+	return nil, 200
+}
+
+// MachineVncTlsSetup creates the folder containing the CA and the server
+// certificate used by the VNC server of the machine
+func MachineVncTlsSetup(id string, opts shared.KvmOptsDef) error {
+	return PkiServerSetup(MachineVncTlsPath(id), fmt.Sprintf("wird VNC - machine %s", id), opts.VNC.Address)
+}
+
+// MachineVncClientName returns the common name of the client
+// certificates issued for the VNC server of the specified machine
+func MachineVncClientName(id string) string {
+	return fmt.Sprintf("wird VNC client - machine %s", id)
+}
+
+// MachineVncCerts returns the certificate authority to be trusted by
+// the VNC clients of the specified machine and, if the machine requires
+// it, a newly issued client certificate
+func MachineVncCerts(id string, opts shared.KvmOptsDef) (shared.VncCertsDef, error) {
+	var def shared.VncCertsDef
+
+	_, _, err := PkiCA()
+	if err != nil {
+		return def, err
+	}
+
+	ca, err := ioutil.ReadFile(PkiCAFile())
+	if err != nil {
+		return def, err
+	}
+
+	def.CACert = string(ca)
+
+	if opts.VNC.VerifyClient {
+		cert, key, err := PkiIssue(MachineVncClientName(id), false, nil)
+		if err != nil {
+			return def, err
+		}
+
+		def.ClientCert = string(cert)
+		def.ClientKey = string(key)
+	}
+
+	return def, nil
+}
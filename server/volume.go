@@ -27,7 +27,7 @@ func CreateVolume(def shared.VolumeDef) error {
 		}
 	}
 
-	if def.Type == "kvm" {
+	if def.Type == shared.BackendKVM {
 		img := qemu.NewImage(file, qemu.ImageFormatQCOW2, def.Size*KiB)
 
 		err := img.Create()
@@ -35,8 +35,12 @@ func CreateVolume(def shared.VolumeDef) error {
 			return err
 		}
 	}
-	if def.Type == "qemu" {
-		// TODO: Add LXC volumes
+	if def.Type == shared.BackendLXC {
+		// LXC volumes are folders bind-mounted into the containers
+		err := os.MkdirAll(file, 0755)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -51,7 +55,7 @@ func DeleteVolume(id string) error {
 		return fmt.Errorf("Volume file not found")
 	}
 
-	err := os.Remove(filepath.Dir(file))
+	err := os.RemoveAll(filepath.Dir(file))
 	if err != nil {
 		return err
 	}
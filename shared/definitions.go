@@ -5,6 +5,67 @@ const (
 	BackendLXC = "lxc"
 )
 
+// Machine lifecycle states
+const (
+	StateCreating  = "creating"
+	StateStopped   = "stopped"
+	StateStarting  = "starting"
+	StateRunning   = "running"
+	StatePaused    = "paused"
+	StateStopping  = "stopping"
+	StateCrashed   = "crashed"
+	StateMigrating = "migrating"
+)
+
+// Machine restart policies
+const (
+	RestartNever     = "never"      // Never start the machine automatically
+	RestartOnBoot    = "on-boot"    // Start the machine when the daemon starts
+	RestartAlways    = "always"     // Start the machine when the daemon starts, and whenever it stops unexpectedly
+	RestartOnFailure = "on-failure" // Restart the machine when it crashed
+)
+
+// KVM remote display protocols
+const (
+	DisplayVNC   = "vnc"   // VNC server, see KvmOptsDef.VNC
+	DisplaySpice = "spice" // SPICE server, see KvmOptsDef.Spice
+)
+
+// KVM video devices
+const (
+	VideoStd    = "std"    // Standard VGA
+	VideoQXL    = "qxl"    // QXL paravirtual graphics, best suited for SPICE
+	VideoVirtio = "virtio" // virtio-gpu
+)
+
+// KVM volume buses
+const (
+	VolumeBusIDE  = "ide"  // IDE, like the disk
+	VolumeBusSCSI = "scsi" // virtio-scsi, volumes can be attached to the running machine
+)
+
+// KVM firmwares
+const (
+	FirmwareBIOS = "bios" // SeaBIOS
+	FirmwareUEFI = "uefi" // OVMF
+)
+
+// KVM boot devices
+const (
+	BootDisk    = "disk"    // Main hard drive of the machine
+	BootCDRom   = "cdrom"   // CD-ROM, if any
+	BootNetwork = "network" // Network interfaces (PXE)
+)
+
+// Job statuses
+const (
+	JobPending  = "pending"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
 // RemoteDef represents an API server
 // It is used in the 'client' package
 type RemoteDef struct {
@@ -21,6 +82,13 @@ type IndexDef struct {
 	MemoryTotal uint64  // Total memory available to the system in KiB
 }
 
+// ReconcileDef is the report returned by the
+// reconciliation handler (/admin/reconcile)
+type ReconcileDef struct {
+	Fixed    []string // Inconsistencies that were repaired
+	Problems []string // Inconsistencies that could not be repaired
+}
+
 // ImageDef is the data structure used in communications
 // with all the Image* HTTP handlers (/images)
 type ImageDef struct {
@@ -45,6 +113,20 @@ type NetworkDef struct {
 	}
 }
 
+// ThrottleDef represents the I/O limits of a drive
+// A value of 0 means no limit
+type ThrottleDef struct {
+	ReadIOPS  uint64 // Read operations per second
+	WriteIOPS uint64 // Write operations per second
+	ReadBPS   uint64 // Bytes read per second
+	WriteBPS  uint64 // Bytes written per second
+
+	ReadIOPSBurst  uint64 // Read operations per second allowed for one second bursts (requires ReadIOPS)
+	WriteIOPSBurst uint64 // Write operations per second allowed for one second bursts (requires WriteIOPS)
+	ReadBPSBurst   uint64 // Bytes read per second allowed for one second bursts (requires ReadBPS)
+	WriteBPSBurst  uint64 // Bytes written per second allowed for one second bursts (requires WriteBPS)
+}
+
 // VolumeDef is the data structure used in communications
 // with all the Volume* HTTP handlers (/volumes)
 type VolumeDef struct {
@@ -52,6 +134,18 @@ type VolumeDef struct {
 	Name string // Name of the volume
 	Type string // Type of the volume (kvm, lxc)
 	Size uint64 // Size of the volume in KiB
+
+	Throttle ThrottleDef // I/O limits of the volume (kvm only)
+}
+
+// BandwidthDef represents the bandwidth limits of
+// a network interface, as seen from the machine
+// A rate of 0 means no limit
+type BandwidthDef struct {
+	IngressRate  uint64 // Rate of the traffic received by the machine in kbit/s
+	IngressBurst uint64 // Traffic received above the rate in bursts in KiB, 0 for 100ms of traffic at the rate
+	EgressRate   uint64 // Rate of the traffic sent by the machine in kbit/s
+	EgressBurst  uint64 // Traffic sent above the rate in bursts in KiB, 0 for 100ms of traffic at the rate
 }
 
 // InterfaceDef represents a network interface
@@ -60,6 +154,8 @@ type InterfaceDef struct {
 	Network string // Name of the network to which the interface is attached
 	MAC     string // MAC address of the interface
 	IP      string // IP address of the interface in CIDR notation
+
+	Bandwidth BandwidthDef // Bandwidth limits of the interface
 }
 
 // MachineFetchDef represents a machine fetch request sent to an API server
@@ -80,18 +176,107 @@ type MachineDef struct {
 	Cores  int    // Number of CPUs
 	Memory uint64 // Memory in MiB
 	Disk   uint64 // Disk size in bytes
+	Flavor string // Name of the flavor the machine is sized from, if any (fills the sizing left at 0)
+
+	DiskThrottle ThrottleDef // I/O limits of the disk (KVM only)
+
+	RestartPolicy string // When the machine should be started automatically (never, on-boot, always, on-failure)
 
 	Volumes    []string       // IDs of the attached volumes
 	Interfaces []InterfaceDef // List of network interfaces
 }
 
+// MachineUpdateDef is the data structure used as a response
+// to the MachineUpdate HTTP handler (POST /machines/<id>)
+type MachineUpdateDef struct {
+	MachineDef
+
+	Applied []string `json:",omitempty"` // Changes applied to the running machine
+	Pending []string `json:",omitempty"` // Changes that will only take effect when the machine restarts
+}
+
+// MachineResizeDef is the data structure used as a request
+// to the MachineResize HTTP handler (/machines/<id>/resize)
+type MachineResizeDef struct {
+	Flavor string // Name of the flavor to move the machine to
+}
+
+// FlavorDef is the data structure used in communications
+// with all the Flavor* HTTP handlers (/flavors)
+type FlavorDef struct {
+	Name   string // ID/Name of the flavor
+	Cores  int    // Number of CPUs
+	Memory uint64 // Memory in MiB
+	Disk   uint64 // Disk size in bytes, 0 for the size of the image
+
+	DiskThrottle ThrottleDef // I/O limits of the disk (KVM only)
+
+	CpuQuota  int // Maximum CPU time in percent of one host CPU, 0 for no limit (KVM only)
+	CpuWeight int // Relative share of the CPU time between 1 and 10000, 0 for the default (KVM only)
+}
+
 // MachineStatusDef is the data structure used as a response
 // to the MachineStatus HTTP handler (/machines/<id>/status)
 type MachineStatusDef struct {
+	State     string  // Lifecycle state of the machine (stopped, running, paused...)
+	Reason    string  // Reason of the last state change, or last error
 	Running   bool    // True if the machine is currently running
+	Paused    bool    // True if the execution of the machine is suspended
 	CpuUsage  float32 // Percentage of the time the CPU is busy
 	RamUsage  uint64  // Currently used RAM in MiB
 	DiskUsage uint64  // Current size of the disk image in bytes
+
+	LastExit *MachineExitDef   `json:",omitempty"` // Termination of the last hypervisor process, if any
+	Cgroup   *MachineCgroupDef `json:",omitempty"` // Resource limits of the running hypervisor process, if any
+	Layout   *MachineLayoutDef `json:",omitempty"` // vCPUs and memory of the running hypervisor process, if known
+}
+
+// MachineLayoutDef describes the vCPUs and memory a running machine
+// was started with, and the ones hot-added to it since
+type MachineLayoutDef struct {
+	Cores      int      // vCPUs the machine was started with
+	AddedCores int      // vCPUs hot-added since
+	Memory     uint64   // Memory in MiB the machine was started with
+	Dimms      []uint64 // Size in MiB of each memory DIMM hot-added since, in order
+}
+
+// MachineCgroupDef describes the cgroup the
+// hypervisor process of a machine runs in
+type MachineCgroupDef struct {
+	Path          string       // Path of the cgroup, relative to the mount point of the cgroup hierarchy
+	CpuQuota      int          // Maximum CPU time in percent of one host CPU, 0 for no limit
+	CpuWeight     int          // Relative share of the CPU time
+	CpuSet        string       // Host CPUs the machine is allowed to run on
+	CpuThrottled  uint64       // Time in microseconds during which the machine was throttled by its CPU quota
+	MemoryMax     uint64       // Maximum memory in MiB, 0 for no limit
+	MemoryCurrent uint64       // Memory in MiB currently used by the hypervisor process
+	Vcpus         []VcpuPinDef // Host CPUs each vCPU thread runs on
+}
+
+// VcpuPinDef describes the host CPUs
+// a vCPU thread of a machine runs on
+type VcpuPinDef struct {
+	Index  int    // Index of the vCPU
+	Thread int    // ID of the host thread running the vCPU
+	CpuSet string // Host CPUs the thread is allowed to run on
+}
+
+// MachineExitDef describes the termination
+// of the hypervisor process of a machine
+type MachineExitDef struct {
+	Timestamp int64    // Unix time of the exit
+	Code      int      // Exit code (-1 if killed by a signal)
+	Signal    string   // Signal that killed the process, if any
+	Expected  bool     // True if the exit was requested through the API
+	Stderr    []string // Last lines of error output of the process
+}
+
+// MachineEventDef represents an entry in the event
+// history of a machine (/machines/<id>/events)
+type MachineEventDef struct {
+	Timestamp int64  // Unix time of the event
+	Type      string // Type of the event (new lifecycle state, or 'exit')
+	Message   string // Description of the event
 }
 
 // KvmOptsDef is the data structure used to represent
@@ -100,20 +285,80 @@ type KvmOptsDef struct {
 	PID   int    // The QEMU/KVM proccess ID
 	CDRom string // Path to a disk image to insert into the machine as a CD-ROM
 
+	Display string // Remote display protocol: vnc (default) or spice
+	Video   string // Video device: std (default), qxl or virtio
+
+	VolumeBus string // Bus of the volumes: ide (default) or scsi (virtio-scsi, needed to attach volumes to the running machine)
+
+	CPU struct {
+		Model   string   // CPU model: 'host' to pass the host CPU through, a QEMU model name (e.g. Skylake-Server), empty for the default
+		Sockets int      // Number of sockets, 0 for 1 (Cores must be a multiple of Sockets * Threads)
+		Threads int      // Number of threads per core, 0 for 1
+		Nested  bool     // Expose the virtualization extensions of the host to the guest
+		Flags   []string // CPU flags to enable ('flag' or '+flag') or disable ('-flag')
+	}
+
+	Boot struct {
+		Firmware string   // Firmware: bios (default) or uefi
+		Order    []string // Boot devices by priority (disk, cdrom, network), empty for cdrom then disk
+		Menu     bool     // Show the boot menu of the firmware
+	}
+
+	Hotplug struct {
+		MaxCores  int    // Number of vCPUs the running machine can grow to, 0 for no hot-plug
+		MaxMemory uint64 // Memory in MiB the running machine can grow to, 0 for no hot-plug
+	}
+
+	Balloon struct {
+		Min  uint64 // Lowest memory target in MiB, 0 for a quarter of the machine's memory
+		Auto bool   // Reclaim the idle memory of the guest when the host is under memory pressure
+	}
+
+	Limits struct {
+		CpuQuota  int    // Maximum CPU time in percent of one host CPU (e.g. 150 for one and a half), 0 for no limit
+		CpuWeight int    // Relative share of the CPU time between 1 and 10000, 0 for the default (100)
+		CpuSet    string // Host CPUs the machine runs on (e.g. '0-3,8'), each vCPU being pinned to one of them, empty for all
+		MemoryMax uint64 // Maximum memory in MiB of the QEMU process, including its own overhead, 0 for no limit
+	}
+
 	VNC struct {
 		Enabled       bool   // Wether to use the VNC server
-		Address       string // Bind address of the VNC server (ip:port)
+		Address       string // Bind address of the VNC server, empty to proxy it through the API (/machines/<id>/vnc)
 		Port          int    // Port number
 		WebsocketPort int    // Websocket port number, if any
 		Password      string // Password (string)
 
-		// TODO: Add parapeters (ssl, authentication...)
+		TLS            bool // Encrypt the connections with TLS, using x509 certificates managed by the server
+		VerifyClient   bool // Require the clients to present a certificate issued by the server (TLS only)
+		PasswordExpiry int  // Seconds during which the password is valid after the machine starts, 0 for no expiry
 	}
 
-	Linux struct {
-		Hostname     string // Linux hostname
-		RootPassword string // Linux root password in clear text
+	Spice struct {
+		Address  string // Bind address of the SPICE server
+		Port     int    // Port number of unencrypted connections, 0 to only accept TLS
+		TLSPort  int    // Port number of TLS connections, using x509 certificates managed by the server, 0 for none
+		Password string // Password, empty to disable authentication
+		Agent    bool   // Add the channel of the SPICE guest agent (clipboard sharing, display resizing)
 	}
+
+	Linux LinuxOptsDef
+}
+
+// LinuxOptsDef represents the options applied to the
+// Linux system of a machine, whatever its backend
+type LinuxOptsDef struct {
+	Hostname     string // Linux hostname
+	RootPassword string // Linux root password in clear text
+}
+
+// BalloonDef is the data structure used in transactions with
+// the memory balloon HTTP handler (/machines/<id>/balloon)
+type BalloonDef struct {
+	Target uint64 `json:",omitempty"` // Memory to leave to the guest in MiB, between Min and Max (request only)
+	Actual uint64 // Memory currently available to the guest in MiB (read-only)
+	Min    uint64 // Lowest allowed target in MiB (read-only)
+	Max    uint64 // Highest allowed target in MiB, the memory of the machine (read-only)
+	Auto   bool   // Wether the automatic balloon policy is enabled (read-only)
 }
 
 // CheckpointDef is the data structure used in transactions with
@@ -122,3 +367,33 @@ type CheckpointDef struct {
 	Name      string // Name of the checkpoint
 	Timestamp int64  // Timestamp of the checkpoint
 }
+
+// JobDef represents a long-running operation executed in
+// the background by an API server (/jobs)
+type JobDef struct {
+	ID       string // Unique identifier
+	Type     string // Operation performed by the job (image create, machine create, machine fetch)
+	Status   string // Status of the job (pending, running, done, failed, canceled)
+	Step     string // Description of the current step
+	Progress uint64 // Number of bytes processed by the current step
+	Total    uint64 // Total number of bytes to be processed by the current step, 0 if unknown
+	Error    string // Error message, if the job failed
+	Result   string // ID of the resource created by the job, if any
+	Created  int64  // Unix time of the creation of the job
+	Updated  int64  // Unix time of the last update of the job
+}
+
+// VncTokenDef is a short-lived token giving access to
+// the VNC proxy of a machine (/machines/<id>/vnc)
+type VncTokenDef struct {
+	Token   string // Token to pass as the 'token' query parameter
+	Expires int64  // Unix time after which the token is no longer valid
+}
+
+// VncCertsDef contains the certificates needed by a client to connect to
+// a VNC or SPICE server using TLS (/machines/<id>/vnc/certs, /machines/<id>/spice/certs)
+type VncCertsDef struct {
+	CACert     string // Certificate authority of the server (PEM)
+	ClientCert string // Client certificate, if the server verifies clients (PEM)
+	ClientKey  string // Private key of the client certificate (PEM)
+}
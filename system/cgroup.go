@@ -0,0 +1,196 @@
+package system
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// CgroupMount is the mount point of the
+// unified (v2) cgroup hierarchy
+const CgroupMount = "/sys/fs/cgroup"
+
+// CgroupV2 returns true if the unified cgroup
+// hierarchy is mounted on the system
+func CgroupV2() bool {
+	_, err := os.Stat(filepath.Join(CgroupMount, "cgroup.controllers"))
+	return err == nil
+}
+
+// CgroupCreate creates the specified cgroup (path relative to the mount
+// point) and enables the specified controllers in all of its parents
+func CgroupCreate(path string, controllers []string) error {
+	err := os.MkdirAll(filepath.Join(CgroupMount, path), 0755)
+	if err != nil {
+		return err
+	}
+
+	var enable []string
+	for _, c := range controllers {
+		enable = append(enable, "+"+c)
+	}
+
+	// From the root of the hierarchy to the parent of the cgroup
+	parent := CgroupMount
+	for _, dir := range append([]string{""}, strings.Split(filepath.Dir(path), "/")...) {
+		if dir == "." {
+			break
+		}
+
+		parent = filepath.Join(parent, dir)
+
+		err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0644)
+		if err != nil {
+			return fmt.Errorf("enable controllers in %s: %s", parent, err)
+		}
+	}
+
+	return nil
+}
+
+// CgroupDelete removes the specified cgroup, which must not contain
+// any process. Cgroups that do not exist are ignored
+func CgroupDelete(path string) error {
+	err := syscall.Rmdir(filepath.Join(CgroupMount, path))
+	if err != nil && err != syscall.ENOENT {
+		return err
+	}
+
+	return nil
+}
+
+// CgroupExists returns true if the specified cgroup exists
+func CgroupExists(path string) bool {
+	_, err := os.Stat(filepath.Join(CgroupMount, path, "cgroup.procs"))
+	return err == nil
+}
+
+// CgroupChildren returns the names of the
+// cgroups directly below the specified one
+func CgroupChildren(path string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(CgroupMount, path))
+	if err != nil {
+		return nil, err
+	}
+
+	var children []string
+	for _, e := range entries {
+		if e.IsDir() {
+			children = append(children, e.Name())
+		}
+	}
+
+	return children, nil
+}
+
+// CgroupSet writes the value of an interface file
+// (e.g. cpu.max) of the specified cgroup
+func CgroupSet(path, file, value string) error {
+	err := ioutil.WriteFile(filepath.Join(CgroupMount, path, file), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("set %s: %s", file, err)
+	}
+
+	return nil
+}
+
+// CgroupGet reads the value of an interface
+// file of the specified cgroup
+func CgroupGet(path, file string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(CgroupMount, path, file))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CgroupStat returns the value of the specified key of
+// a flat keyed interface file of the cgroup (e.g. cpu.stat)
+func CgroupStat(path, file, key string) (uint64, error) {
+	data, err := CgroupGet(path, file)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, l := range strings.Split(data, "\n") {
+		fields := strings.Fields(l)
+
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	return 0, fmt.Errorf("%s: '%s' not found", file, key)
+}
+
+// CgroupAddProcess moves all the threads of the
+// specified process to the cgroup
+func CgroupAddProcess(path string, pid int) error {
+	return CgroupSet(path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// ParseCpuList parses a list of CPUs in the format used by
+// the kernel (e.g. '0-3,8,10-11') and returns their indexes
+func ParseCpuList(list string) ([]int, error) {
+	var cpus []int
+
+	for _, r := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(r), "-", 2)
+
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU list '%s'", list)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU list '%s'", list)
+			}
+		}
+
+		for i := first; i <= last; i++ {
+			cpus = append(cpus, i)
+		}
+	}
+
+	return cpus, nil
+}
+
+// SetThreadAffinity restricts the specified
+// thread to run on a single CPU of the host
+func SetThreadAffinity(tid, cpu int) error {
+	mask := make([]uint64, cpu/64+1)
+	mask[cpu/64] = 1 << uint(cpu%64)
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return fmt.Errorf("sched_setaffinity %d: %s", tid, errno)
+	}
+
+	return nil
+}
+
+// ThreadAffinity returns the list of CPUs the
+// specified thread of a process is allowed to run on
+func ThreadAffinity(pid, tid int) (string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/status", pid, tid))
+	if err != nil {
+		return "", err
+	}
+
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(l, "Cpus_allowed_list:") {
+			return strings.TrimSpace(strings.TrimPrefix(l, "Cpus_allowed_list:")), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid /proc/%d/task/%d/status file", pid, tid)
+}
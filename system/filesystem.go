@@ -83,6 +83,19 @@ func ResizeQcow2(path string, size uint64) error {
 	return nil
 }
 
+// ConvertRawToQcow2 creates a qcow2 image
+// with the content of a raw disk image
+func ConvertRawToQcow2(src, dst string) error {
+	cmd := exec.Command("qemu-img", "convert", "-f", "raw", "-O", "qcow2", src, dst)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
 // NBDConnectQcow2 connects the specified QCOW2 image
 // to the NBD device on the host
 func NBDConnectQcow2(file string) error {
@@ -0,0 +1,130 @@
+package system
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/quadrifoglio/wir/utils"
+)
+
+// ExtractTar extracts the specified (optionally compressed)
+// tar archive into the 'dst' directory
+func ExtractTar(src, dst string) error {
+	cmd := exec.Command("tar", "--numeric-owner", "-xpf", src, "-C", dst)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tar: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
+// LxcStart starts the specified container in the background
+// 'path' is the folder in which the container's folder is located
+func LxcStart(name, path string) error {
+	cmd := exec.Command("lxc-start", "-n", name, "-P", path, "-d")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("lxc-start: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
+// LxcStop stops the specified container, waiting at most 'timeout' seconds
+// for a clean shutdown before killing it
+// If 'kill' is true, the container is killed right away
+func LxcStop(name, path string, timeout int, kill bool) error {
+	args := []string{"-n", name, "-P", path}
+	if kill {
+		args = append(args, "-k")
+	} else {
+		args = append(args, "-t", strconv.Itoa(timeout))
+	}
+
+	cmd := exec.Command("lxc-stop", args...)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("lxc-stop: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
+// LxcFreeze suspends the execution of all
+// the processes of the specified container
+func LxcFreeze(name, path string) error {
+	cmd := exec.Command("lxc-freeze", "-n", name, "-P", path)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("lxc-freeze: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
+// LxcUnfreeze resumes the execution of all
+// the processes of the specified container
+func LxcUnfreeze(name, path string) error {
+	cmd := exec.Command("lxc-unfreeze", "-n", name, "-P", path)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("lxc-unfreeze: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
+// LxcState returns the state of the specified
+// container (STOPPED, RUNNING...)
+func LxcState(name, path string) (string, error) {
+	cmd := exec.Command("lxc-info", "-n", name, "-P", path, "-s", "-H")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("lxc-info: %s", utils.OneLine(out))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// LxcStats returns respectively the total CPU time used by the container
+// in nanoseconds and its current memory usage in bytes
+func LxcStats(name, path string) (uint64, uint64, error) {
+	var cpu, mem uint64
+
+	cmd := exec.Command("lxc-info", "-n", name, "-P", path, "-S", "-H")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, 0, fmt.Errorf("lxc-info: %s", utils.OneLine(out))
+	}
+
+	for _, l := range strings.Split(string(out), "\n") {
+		t := strings.SplitN(l, ":", 2)
+		if len(t) != 2 {
+			continue
+		}
+
+		v, err := strconv.ParseUint(strings.TrimSpace(t[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch strings.TrimSpace(t[0]) {
+		case "CPU use":
+			cpu = v
+		case "Memory use":
+			mem = v
+		}
+	}
+
+	return cpu, mem, nil
+}
@@ -46,6 +46,18 @@ func CreateBridge(name string) error {
 	return nil
 }
 
+// TCPPortFree checks if the specified TCP port
+// can be listened on at the specified address
+func TCPPortFree(addr string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+
+	l.Close()
+	return true
+}
+
 // SetInterfaceMaster adds the specified interface
 // to the master bridge
 func SetInterfaceMaster(name, master string) error {
@@ -85,6 +97,62 @@ func DeleteInterface(name string) error {
 	return nil
 }
 
+// TcShapeEgress limits the traffic sent through the specified interface
+// to rate kbit/s, with bursts of burst KiB, using a token bucket filter
+// A rate of 0 removes the limit
+func TcShapeEgress(iface string, rate, burst uint64) error {
+	if rate == 0 {
+		// Fails if there is no limit
+		exec.Command("tc", "qdisc", "del", "dev", iface, "root").Run()
+		return nil
+	}
+
+	cmd := exec.Command("tc", "qdisc", "replace", "dev", iface, "root", "tbf",
+		"rate", fmt.Sprintf("%dkbit", rate),
+		"burst", fmt.Sprintf("%dkb", burst),
+		"latency", "50ms",
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tc qdisc replace: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
+// TcPoliceIngress drops the traffic received on the specified interface
+// above rate kbit/s, allowing bursts of burst KiB
+// A rate of 0 removes the limit
+func TcPoliceIngress(iface string, rate, burst uint64) error {
+	// Fails if there is no limit
+	exec.Command("tc", "qdisc", "del", "dev", iface, "ingress").Run()
+
+	if rate == 0 {
+		return nil
+	}
+
+	cmd := exec.Command("tc", "qdisc", "add", "dev", iface, "handle", "ffff:", "ingress")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tc qdisc add: %s", utils.OneLine(out))
+	}
+
+	cmd = exec.Command("tc", "filter", "add", "dev", iface, "parent", "ffff:",
+		"protocol", "all", "u32", "match", "u32", "0", "0",
+		"police", "rate", fmt.Sprintf("%dkbit", rate), "burst", fmt.Sprintf("%dkb", burst),
+		"drop", "flowid", ":1",
+	)
+
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tc filter add: %s", utils.OneLine(out))
+	}
+
+	return nil
+}
+
 // EbtablesClear removes the rules created by this program
 // so they don't accumulate at each startup
 func EbtablesClear() {
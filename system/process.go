@@ -0,0 +1,129 @@
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Process represents a child process started by the daemon
+// whose termination is being waited for
+type Process struct {
+	Pid  int           // Process ID
+	Done chan struct{} // Closed when the process exits
+
+	mutex    sync.Mutex
+	stderr   []string
+	maxLines int
+
+	ExitTime time.Time // Time at which the process exited
+	ExitCode int       // Exit code of the process (-1 if killed by a signal)
+	Signal   string    // Name of the signal that killed the process, if any
+}
+
+// StartProcess starts the specified command in a new session, keeping the last
+// 'lines' lines of its error output. 'stderrCb' is called for each of these lines
+func StartProcess(name string, args []string, lines int, stderrCb func(s string)) (*Process, error) {
+	p := new(Process)
+	p.Done = make(chan struct{})
+	p.maxLines = lines
+
+	cmd := exec.Command(name, args...)
+	cmd.SysProcAttr = new(syscall.SysProcAttr)
+	cmd.SysProcAttr.Setsid = true
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	p.Pid = cmd.Process.Pid
+	output := make(chan struct{})
+
+	go func() {
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			p.addLine(s.Text())
+			stderrCb(s.Text())
+		}
+
+		close(output)
+	}()
+
+	go func() {
+		<-output // The output must be read entirely before waiting
+		err := cmd.Wait()
+
+		p.mutex.Lock()
+		p.ExitTime = time.Now()
+
+		if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			p.ExitCode = -1
+			p.Signal = ws.Signal().String()
+		} else if err != nil {
+			p.ExitCode = cmd.ProcessState.ExitCode()
+		}
+
+		p.mutex.Unlock()
+		close(p.Done)
+	}()
+
+	// Give the process some time to fail on invalid arguments
+	select {
+	case <-p.Done:
+		return nil, fmt.Errorf("'%s': %s", name, p.ExitReason())
+	case <-time.After(50 * time.Millisecond):
+		return p, nil
+	}
+}
+
+// addLine keeps the specified line of error
+// output, discarding the oldest ones
+func (p *Process) addLine(l string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stderr = append(p.stderr, l)
+	if len(p.stderr) > p.maxLines {
+		p.stderr = p.stderr[len(p.stderr)-p.maxLines:]
+	}
+}
+
+// Stderr returns the last lines of
+// error output of the process
+func (p *Process) Stderr() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	lines := make([]string, len(p.stderr))
+	copy(lines, p.stderr)
+
+	return lines
+}
+
+// ExitReason returns a description of the
+// termination of the process
+func (p *Process) ExitReason() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	reason := fmt.Sprintf("exit code %d", p.ExitCode)
+	if len(p.Signal) > 0 {
+		reason = fmt.Sprintf("killed by signal '%s'", p.Signal)
+	}
+
+	if len(p.stderr) > 0 {
+		reason = fmt.Sprintf("%s: %s", reason, strings.TrimSpace(p.stderr[len(p.stderr)-1]))
+	}
+
+	return reason
+}
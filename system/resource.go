@@ -7,6 +7,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/quadrifoglio/wir/utils"
@@ -155,6 +156,53 @@ func CpuUsage() (float32, error) {
 	return float32((total2-total1)-(idle2-idle1)) / float32(total2-total1) * 100, nil
 }
 
+// CpuVirtFlag returns the CPU flag of the hardware virtualization
+// extensions of the host (vmx or svm), or an empty string if the
+// CPU does not support them
+func CpuVirtFlag() (string, error) {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if !strings.HasPrefix(s.Text(), "flags") {
+			continue
+		}
+
+		for _, flag := range strings.Fields(s.Text()) {
+			if flag == "vmx" || flag == "svm" {
+				return flag, nil
+			}
+		}
+
+		break
+	}
+
+	return "", s.Err()
+}
+
+// NestedVirtEnabled returns true if the KVM module of the
+// host allows guests to run their own hypervisors
+func NestedVirtEnabled() bool {
+	for _, module := range []string{"kvm_intel", "kvm_amd"} {
+		data, err := ioutil.ReadFile(fmt.Sprintf("/sys/module/%s/parameters/nested", module))
+		if err != nil {
+			continue
+		}
+
+		v := strings.TrimSpace(string(data))
+		if v == "Y" || v == "1" {
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetProcessRamUsage returns the current number or megabytes
 // used by the specified process
 func ProcessRamUsage(pid int) (uint64, error) {
@@ -219,6 +267,39 @@ func ProcessCpuUsage(pid int) (float32, error) {
 	return 100 * ((totalTime / hz) / seconds), nil
 }
 
+// ProcessExists checks if a process with
+// the specified PID is alive
+func ProcessExists(pid int) bool {
+	return syscall.Kill(pid, syscall.Signal(0)) == nil
+}
+
+// ProcessCmdline returns the command line
+// arguments of the specified process
+func ProcessCmdline(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00"), nil
+}
+
+// WaitProcessExit waits for the specified process to exit, for at most
+// the specified duration, and returns true if it did
+func WaitProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		if !ProcessExists(pid) {
+			return true
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	return !ProcessExists(pid)
+}
+
 // TicksPerSecond returns the number of CPU clock
 // ticks per second
 func TicksPerSecond() uint64 {
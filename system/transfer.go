@@ -1,21 +1,25 @@
 package system
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/quadrifoglio/wir/utils"
 )
 
+// TransferProgress is called during a transfer with the number
+// of bytes copied so far and the total (0 if unknown)
+type TransferProgress func(done, total uint64)
+
 // FetchURL fetches the specified resource and
 // stores it in the 'dst' file path
-func FetchURL(src, dst string) error {
+func FetchURL(ctx context.Context, src, dst string, progress TransferProgress) error {
 	dir := filepath.Dir(dst)
 
 	if !utils.FileExists(dir) {
@@ -33,18 +37,12 @@ func FetchURL(src, dst string) error {
 
 		switch url.Scheme {
 		case "file":
-			return CopyFile(src[7:], dst)
-		case "http":
-		case "https":
-			return DownloadHttp(url.String(), dst)
-		default:
-			break
+			return CopyFile(ctx, src[7:], dst, progress)
+		case "http", "https":
+			return DownloadHttp(ctx, url.String(), dst, progress)
 		}
 	} else {
-		err := CopyFile(src, dst)
-		if err != nil {
-			return err
-		}
+		return CopyFile(ctx, src, dst, progress)
 	}
 
 	return fmt.Errorf("Invalid source path (must be URL or file path)")
@@ -52,38 +50,92 @@ func FetchURL(src, dst string) error {
 
 // CopyFile copies the specified 'src' file
 // into the 'dst' file path
-func CopyFile(src, dst string) error {
-	cmd := exec.Command("cp", src, dst)
+func CopyFile(ctx context.Context, src, dst string, progress TransferProgress) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
 
-	out, err := cmd.CombinedOutput()
+	info, err := in.Stat()
 	if err != nil {
-		return fmt.Errorf("%s", utils.OneLine(out))
+		return err
 	}
 
-	return nil
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	return copyProgress(ctx, out, in, uint64(info.Size()), progress)
 }
 
 // DownloadHttp downloads the specified HTTP ressource
 // and stores it in the 'dst' file path
-func DownloadHttp(url, dst string) error {
-	out, err := os.Create(dst)
+func DownloadHttp(ctx context.Context, url, dst string, progress TransferProgress) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
-	defer out.Close()
-
-	resp, err := http.Get(url)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	out, err := os.Create(dst)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	defer out.Close()
+
+	var total uint64
+	if resp.ContentLength > 0 {
+		total = uint64(resp.ContentLength)
+	}
+
+	return copyProgress(ctx, out, resp.Body, total, progress)
+}
+
+// copyProgress copies 'src' into 'dst', reporting the progress
+// and stopping if the context is canceled
+func copyProgress(ctx context.Context, dst io.Writer, src io.Reader, total uint64, progress TransferProgress) error {
+	var done uint64
+	buf := make([]byte, 1024*1024)
+
+	progress(0, total)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			_, err := dst.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+
+			done += uint64(n)
+			progress(done, total)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // FileExists returns wether the specified
@@ -13,7 +14,7 @@ func FileExists(path string) bool {
 	_, err := os.Stat(path)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			log.Println("file exists check: %s", err)
+			log.Println("file exists check:", err)
 		}
 
 		return false
@@ -39,6 +40,26 @@ func FileSize(path string) (uint64, error) {
 	return uint64(stat.Size()), nil
 }
 
+// DirectorySize returns the total size of the
+// files contained in the specified directory in bytes
+func DirectorySize(path string) (uint64, error) {
+	var size uint64
+
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
 // ReplaceFileContents replaces the content of the specified file
 // byte the data provided in 'data'
 func ReplaceFileContents(path string, data []byte) error {
@@ -56,3 +77,118 @@ func ReplaceFileContents(path string, data []byte) error {
 
 	return nil
 }
+
+// RotatingFile is a file that is renamed when it grows over
+// a maximum size, keeping a fixed number of old files
+// (path.1 being the most recent one)
+type RotatingFile struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	count   int
+
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the specified file for appending,
+// rotating it when it grows over 'maxSize' bytes and keeping
+// 'count' old files
+func OpenRotatingFile(path string, maxSize int64, count int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, count: count}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// open opens the current file
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = stat.Size()
+
+	return nil
+}
+
+// rotate renames the current file and the old
+// ones, deleting the oldest, then reopens it
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.count))
+
+	for i := f.count - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+
+	if f.count > 0 {
+		err := os.Rename(f.path, f.path+".1")
+		if err != nil {
+			return err
+		}
+	} else {
+		os.Remove(f.path)
+	}
+
+	return f.open()
+}
+
+// Write appends the data to the file,
+// rotating it first if needed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the current file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
+
+// RotatedFiles returns the paths of the existing files
+// of a rotating file, from the oldest to the current one
+func RotatedFiles(path string, count int) []string {
+	var files []string
+
+	for i := count; i > 0; i-- {
+		p := fmt.Sprintf("%s.%d", path, i)
+		if FileExists(p) {
+			files = append(files, p)
+		}
+	}
+
+	if FileExists(path) {
+		files = append(files, path)
+	}
+
+	return files
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -48,6 +49,31 @@ func UintTokens(s string) ([]uint64, error) {
 	return vals, nil
 }
 
+// ParseRange parses a range of integers
+// in the 'min-max' form
+func ParseRange(s string) (int, int, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid range '%s': must be 'min-max'", s)
+	}
+
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid range '%s': %s", s, err)
+	}
+
+	max, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid range '%s': %s", s, err)
+	}
+
+	if min > max {
+		return 0, 0, fmt.Errorf("Invalid range '%s': min is greater than max", s)
+	}
+
+	return min, max, nil
+}
+
 // OneLine transforms the input byte sequence into
 // a one-line string
 func OneLine(b []byte) string {